@@ -0,0 +1,40 @@
+package cloudwatcher
+
+import (
+	"time"
+)
+
+// Backoff defines an exponential backoff policy
+type Backoff struct {
+	Initial    time.Duration // delay before the first retry (default 1s)
+	Max        time.Duration // upper bound of the delay (default 1m)
+	Multiplier float64       // growth factor between two retries (default 2)
+}
+
+// Duration returns the delay to wait before the retry number attempt (starting from 1)
+func (b Backoff) Duration(attempt int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = time.Second
+	}
+	max := b.Max
+	if max <= 0 {
+		max = time.Minute
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(initial)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if d >= float64(max) {
+			return max
+		}
+	}
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
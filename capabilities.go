@@ -0,0 +1,130 @@
+package cloudwatcher
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Capabilities describes the features supported by a watcher
+type Capabilities struct {
+	Tags              bool     // TagsChanged events are sent when the tags (or the file modes) of an object change
+	ContentHash       bool     // the objects contain a hash of their content
+	PushNotifications bool     // the changes are notified by the service instead of being found by the polling
+	DirectoryEvents   bool     // the events are sent also for the directories
+	ConfigKeys        []string // keys accepted by SetConfig (checked by SetConfig), if nil the keys are not checked
+}
+
+// Capabilities returns no features, the watchers override it with the ones they support
+func (w *WatcherBase) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// CheckConfig returns an error if the configuration contains keys not supported by the watcher
+func (c Capabilities) CheckConfig(config map[string]string) error {
+	if c.ConfigKeys == nil {
+		return nil
+	}
+	unknown := make([]string, 0)
+	for k := range config {
+		if !inArray(k, c.ConfigKeys) {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unsupported config keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Ops returns the types of the events that the watcher can send
+func (c Capabilities) Ops() []Op {
+	ops := []Op{FileCreated, FileChanged, FileDeleted, CacheReset}
+	if c.Tags {
+		ops = append(ops, TagsChanged)
+	}
+	return ops
+}
+
+// Check returns an error if the filter contains unknown event types, or if it accepts only events that the watcher
+// can't send
+func (f *Filter) Check(c Capabilities) error {
+	if f == nil || len(f.Ops) == 0 {
+		return nil
+	}
+	supported := c.Ops()
+	unsupported := make([]string, 0)
+	for _, op := range f.Ops {
+		if op > CacheReset {
+			return fmt.Errorf("unknown event type %d", op)
+		}
+		if !containsOp(supported, op) {
+			unsupported = append(unsupported, op.String())
+		}
+	}
+	if len(unsupported) == len(f.Ops) {
+		return fmt.Errorf("%s events are not supported by the watcher", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+func containsOp(ops []Op, op Op) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns the capabilities of a source merging the events of c and o: the events sent by any of them, and the
+// features of the objects supported by both
+func (c Capabilities) merge(o Capabilities) Capabilities {
+	return Capabilities{
+		Tags:              c.Tags || o.Tags,
+		ContentHash:       c.ContentHash && o.ContentHash,
+		PushNotifications: c.PushNotifications && o.PushNotifications,
+		DirectoryEvents:   c.DirectoryEvents || o.DirectoryEvents,
+	}
+}
+
+// configKeys returns the json names of the fields of a configuration struct
+func configKeys(config interface{}) []string {
+	t := reflect.TypeOf(config)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	keys := make([]string, 0, t.NumField())
+	for _, f := range configFields(t) {
+		keys = append(keys, configName(f))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configFields returns the fields of the configuration with a json name, including the ones of the embedded structs
+// (their Index is relative to t)
+func configFields(t reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for _, e := range configFields(f.Type) {
+				e.Index = append([]int{i}, e.Index...)
+				fields = append(fields, e)
+			}
+			continue
+		}
+		if name := configName(f); name != "" && name != "-" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// configName returns the key of the field in the configuration map
+func configName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
@@ -0,0 +1,112 @@
+package cloudwatcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCapabilities_CheckConfig(t *testing.T) {
+	w, err := New("s3", "/", time.Minute)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	c := w.Capabilities()
+	if !c.Tags || !c.ContentHash || c.PushNotifications {
+		t.Errorf("wrong capabilities: %#v", c)
+	}
+
+	if err := c.CheckConfig(map[string]string{"bucket_name": "test", "ssl_enabled": "true"}); err != nil {
+		t.Errorf("%s", err)
+	}
+	err = c.CheckConfig(map[string]string{"bucket_name": "test", "token_file": "x", "disable_fsnotify": "true"})
+	if err == nil || err.Error() != "unsupported config keys: disable_fsnotify, token_file" {
+		t.Errorf("wrong error returned: %v", err)
+	}
+
+	// the keys are not checked if they are unknown
+	if err := (Capabilities{}).CheckConfig(map[string]string{"anything": "x"}); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestLocalWatcher_Capabilities(t *testing.T) {
+	w, err := New("local", t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if c := w.Capabilities(); !c.PushNotifications || !c.DirectoryEvents {
+		t.Errorf("wrong capabilities: %#v", c)
+	}
+	if err := w.SetConfig(map[string]string{"disable_fsnotify": "true"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	c := w.Capabilities()
+	if c.PushNotifications {
+		t.Errorf("the polling doesn't push the changes")
+	}
+	if !reflect.DeepEqual(c.ConfigKeys, []string{"debug", "delete_confirmations", "disable_fsnotify", "keys", "max_delete_ratio", "max_deletes"}) {
+		t.Errorf("wrong config keys: %v", c.ConfigKeys)
+	}
+}
+
+func TestFilter_Check(t *testing.T) {
+	tags := &Filter{Ops: []Op{TagsChanged}}
+	if err := tags.Check(Capabilities{}); err == nil {
+		t.Errorf("the filter accepts only TagsChanged events")
+	}
+	if err := tags.Check(Capabilities{Tags: true}); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	var none *Filter
+	for _, f := range []*Filter{none, {}, {Ops: []Op{TagsChanged, FileCreated}}, {Ops: []Op{CacheReset}}} {
+		if err := f.Check(Capabilities{}); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+
+	err := (&Filter{Ops: []Op{FileCreated, Op(42)}}).Check(Capabilities{Tags: true})
+	if err == nil || err.Error() != "unknown event type 42" {
+		t.Errorf("wrong error returned: %v", err)
+	}
+}
+
+func TestCapabilities_Ops(t *testing.T) {
+	if ops := (Capabilities{}).Ops(); !reflect.DeepEqual(ops, []Op{FileCreated, FileChanged, FileDeleted, CacheReset}) {
+		t.Errorf("wrong ops: %v", ops)
+	}
+	if ops := (Capabilities{Tags: true}).Ops(); len(ops) != 5 || ops[4] != TagsChanged {
+		t.Errorf("wrong ops: %v", ops)
+	}
+}
+
+func TestWatcher_SetConfigKeys(t *testing.T) {
+	for _, service := range []string{"s3", "gdrive", "dropbox", "local", "git", "memory"} {
+		w, err := New(service, t.TempDir(), time.Minute)
+		if err != nil {
+			t.Fatalf("%s: %s", service, err)
+		}
+		err = w.SetConfig(map[string]string{"unknown_key": "x"})
+		if err == nil || err.Error() != "unsupported config keys: unknown_key" {
+			t.Errorf("%s: wrong error returned: %v", service, err)
+		}
+	}
+}
+
+func TestMux_Capabilities(t *testing.T) {
+	s3, _ := New("s3", "/", time.Minute)
+	gdrive, _ := New("gdrive", "/", time.Minute)
+	m, err := NewMux(s3, gdrive)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the TagsChanged events of s3 are sent by the Mux
+	c := m.Capabilities()
+	if !c.Tags || !c.ContentHash {
+		t.Errorf("wrong capabilities: %#v", c)
+	}
+	if err := c.CheckConfig(map[string]string{"bucket_name": "test"}); err == nil {
+		t.Errorf("the Mux can't be configured")
+	}
+}
@@ -0,0 +1,45 @@
+package cloudwatcher
+
+import (
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+// Clock is the source of time of the watchers: it drives the polling and the timestamps of the events. The
+// cloudwatchertest package contains a fake implementation.
+type Clock = clock.Clock
+
+// Ticker delivers the ticks of a Clock
+type Ticker = clock.Ticker
+
+// Option customizes the watchers created by New
+type Option func(*options)
+
+type options struct {
+	clock     Clock
+	scheduler *Scheduler
+	limiter   *RateLimiter
+}
+
+// WithClock sets the clock of the watcher, the default one is the system clock
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// clockSetter is implemented by all the watchers including WatcherBase
+type clockSetter interface {
+	setClock(c Clock)
+}
+
+func (w *WatcherBase) setClock(c Clock) {
+	w.clk = c
+}
+
+// getClock returns the clock of the watcher
+func (w *WatcherBase) getClock() Clock {
+	if w.clk == nil {
+		return clock.Real
+	}
+	return w.clk
+}
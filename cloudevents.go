@@ -0,0 +1,142 @@
+package cloudwatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CloudEvents constants
+const (
+	CloudEventsSpecVersion      = "1.0"
+	CloudEventsContentType      = "application/cloudevents+json"
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+// CloudEvent is the CloudEvents 1.0 envelope of an Event, its JSON encoding is the structured content mode
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            *time.Time  `json:"time,omitempty"`
+	DataContentType string      `json:"datacontenttype,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+// sourcer is implemented by the watchers that can describe the watched location as an URI
+type sourcer interface {
+	source() string
+}
+
+// NewCloudEvent converts the event in a CloudEvent. The source should identify the watched location (see
+// CloudEventSource), if it is empty only the service of the object is used (i.e. "s3://").
+// The time is the last modification of the object, or the time of the conversion (that follows the detection of the
+// change) for the deletions and when the modification time is unknown.
+func NewCloudEvent(e Event, source string) *CloudEvent {
+	return newCloudEvent(e, source, time.Now())
+}
+
+func newCloudEvent(e Event, source string, detected time.Time) *CloudEvent {
+	o, known := e.Object.(snapshotObject)
+	if source == "" {
+		backend := "unknown"
+		if known {
+			backend = o.backend()
+		}
+		source = backend + "://"
+	}
+
+	c := &CloudEvent{
+		SpecVersion: CloudEventsSpecVersion,
+		ID:          EventID(e),
+		Source:      source,
+		Type:        CloudEventType(e.Type),
+		Subject:     e.Key,
+	}
+	// the modification time of a deleted object is the one of its last change, not of the deletion
+	var t time.Time
+	if known {
+		t = o.modTime()
+	}
+	if e.Type == FileDeleted || t.IsZero() {
+		t = detected
+	}
+	t = t.UTC()
+	c.Time = &t
+	if e.Object != nil {
+		c.DataContentType = "application/json"
+		c.Data = e.Object
+	}
+	return c
+}
+
+// CloudEventType returns the CloudEvents type of the event's type, i.e. "io.cloudwatcher.file.created"
+func CloudEventType(op Op) string {
+	switch op {
+	case FileCreated:
+		return "io.cloudwatcher.file.created"
+	case FileChanged:
+		return "io.cloudwatcher.file.changed"
+	case FileDeleted:
+		return "io.cloudwatcher.file.deleted"
+	case TagsChanged:
+		return "io.cloudwatcher.file.tags_changed"
+	case CacheReset:
+		return "io.cloudwatcher.cache.reset"
+	default:
+		return "io.cloudwatcher.unknown"
+	}
+}
+
+// CloudEventSource returns the source of the events generated by the watcher: the service followed by the bucket,
+// the repository or the root directory (i.e. "s3://bucket/prefix")
+func CloudEventSource(w Watcher) string {
+	if s, ok := w.(sourcer); ok {
+		return s.source()
+	}
+	return ""
+}
+
+// EncodeBinary returns the body of the HTTP binary content mode and sets the attributes in the "ce-" headers, the
+// values are percent-encoded as required by the HTTP binding (i.e. the keys with non-ASCII characters)
+func (c *CloudEvent) EncodeBinary(h http.Header) ([]byte, error) {
+	h.Set("ce-specversion", encodeHeaderValue(c.SpecVersion))
+	h.Set("ce-id", encodeHeaderValue(c.ID))
+	h.Set("ce-source", encodeHeaderValue(c.Source))
+	h.Set("ce-type", encodeHeaderValue(c.Type))
+	if c.Subject != "" {
+		h.Set("ce-subject", encodeHeaderValue(c.Subject))
+	}
+	if c.Time != nil {
+		h.Set("ce-time", c.Time.Format(time.RFC3339Nano))
+	}
+
+	if c.Data == nil {
+		return nil, nil
+	}
+	body, err := json.Marshal(c.Data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding data: %s", err)
+	}
+	h.Set("Content-Type", c.DataContentType)
+	return body, nil
+}
+
+// encodeHeaderValue percent-encodes the bytes of the UTF-8 string that are not printable ASCII characters, the space,
+// the double quote and the percent sign
+func encodeHeaderValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -2,6 +2,8 @@ package cloudwatcher
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -16,6 +18,62 @@ type WatcherBase struct {
 
 	watchDir    string
 	pollingTime time.Duration
+
+	statusMu     sync.Mutex
+	starts       uint64
+	syncs        uint64
+	lastSync     time.Time
+	syncChanged  chan struct{} // closed and replaced at the start and at the end of each synchronization
+	heldCount    int
+	pendingCount int
+
+	// limiter limits the requests to the service, fixedLimiter is set if it's not the one of the configuration
+	limiter      *RateLimiter
+	fixedLimiter bool
+
+	// syncRequests is read by the polling loop of the watchers supporting SyncNow
+	syncRequests chan bool
+
+	// cycle is held by the synchronizations and by Reconfigure
+	cycleOnce  sync.Once
+	cycle      chan struct{}
+	cacheReset bool
+
+	// heldDeletes are the deletions held back by the mass-deletion guard, tombstones counts the consecutive
+	// synchronizations missing the cached objects
+	heldDeletes      []string
+	deletesConfirmed bool
+	tombstones       map[string]int
+
+	// keys are the keys checked by the keys mode (nil if the watcher lists its directory) and if they have already
+	// been looked up, keyCache the objects found
+	keys     map[string]bool
+	keyCache map[string]snapshotObject
+
+	// rawConfig is the configuration with the secret references, resolvedConfig the values they had
+	rawConfig      map[string]string
+	resolvedConfig map[string]string
+
+	// scheduler runs the polling of the watchers created WithScheduler, scheduled is set once started
+	scheduler   *Scheduler
+	scheduledMu sync.Mutex
+	scheduled   *scheduledEntry
+
+	clk Clock
+}
+
+// Status contains the state of a watcher
+type Status struct {
+	Dir      string
+	Interval time.Duration
+	Syncs    uint64    // number of completed synchronizations
+	LastSync time.Time // end of the last synchronization
+
+	HeldDeletes    int // deletions held back by the mass-deletion guard
+	PendingDeletes int // missing objects waiting for the confirmation of their deletion
+
+	RateLimit float64 // requests per second allowed by the rate limiter, zero if the requests are not limited
+	RateBurst int     // requests allowed at once by the rate limiter
 }
 
 // Watcher has to be implemented by all the watchers
@@ -25,16 +83,51 @@ type Watcher interface {
 	Close()
 	GetEvents() chan Event
 	GetErrors() chan error
+	Status() Status
+	Capabilities() Capabilities
 }
 
 // New creates a new instance of a watcher
-func New(serviceName string, dir string, interval time.Duration) (Watcher, error) {
+func New(serviceName string, dir string, interval time.Duration, opts ...Option) (Watcher, error) {
 	f, ok := supportedServices[serviceName]
 	if !ok {
 		return nil, fmt.Errorf("service %s is not yet supported", serviceName)
 	}
-	return f(dir, interval)
+	w, err := f(dir, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock != nil {
+		if s, ok := w.(clockSetter); ok {
+			s.setClock(o.clock)
+		}
+	}
+	if o.scheduler != nil {
+		if s, ok := w.(schedulerSetter); ok {
+			s.setScheduler(o.scheduler)
+		}
+	}
+	if o.limiter != nil {
+		if s, ok := w.(rateLimiterSetter); ok {
+			s.setRateLimiter(o.limiter)
+		}
+	}
+	return w, nil
+}
 
+// Services returns the names of the supported services
+func Services() []string {
+	names := make([]string, 0, len(supportedServices))
+	for name := range supportedServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // GetEvents returns a chan of Event
@@ -47,6 +140,102 @@ func (w *WatcherBase) GetErrors() chan error {
 	return w.Errors
 }
 
+// Status returns the current state of the watcher
+func (w *WatcherBase) Status() Status {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	var rate float64
+	var burst int
+	if w.limiter != nil {
+		rate, burst = w.limiter.Limit()
+	}
+	return Status{
+		Dir:      w.watchDir,
+		Interval: w.pollingTime,
+		Syncs:    w.syncs,
+		LastSync: w.lastSync,
+
+		HeldDeletes:    w.heldCount,
+		PendingDeletes: w.pendingCount,
+
+		RateLimit: rate,
+		RateBurst: burst,
+	}
+}
+
+// syncStarted records the start of a synchronization
+func (w *WatcherBase) syncStarted() {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.starts++
+	w.notifySync()
+}
+
+// syncDone records the end of a synchronization
+func (w *WatcherBase) syncDone() {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.syncs++
+	w.lastSync = w.getClock().Now()
+	w.notifySync()
+}
+
+// notifySync wakes up the waiters of syncState, it has to be called with statusMu held
+func (w *WatcherBase) notifySync() {
+	if w.syncChanged != nil {
+		close(w.syncChanged)
+		w.syncChanged = nil
+	}
+}
+
+// syncState returns the number of started and completed synchronizations, and a chan closed when they change
+func (w *WatcherBase) syncState() (uint64, uint64, <-chan struct{}) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	if w.syncChanged == nil {
+		w.syncChanged = make(chan struct{})
+	}
+	return w.starts, w.syncs, w.syncChanged
+}
+
+// requestSync asks the polling loop to synchronize, the requests are merged while a synchronization is pending
+func (w *WatcherBase) requestSync() error {
+	if e := w.getScheduled(); e != nil {
+		w.scheduler.request(e)
+		return nil
+	}
+	if w.syncRequests == nil {
+		return fmt.Errorf("the watcher doesn't support the synchronization on demand")
+	}
+	select {
+	case w.syncRequests <- true:
+	default:
+	}
+	return nil
+}
+
+// syncRequester is implemented by the watchers that can be synchronized on demand
+type syncRequester interface {
+	requestSync() error
+}
+
+// Syncer can be implemented by the watchers defined outside of this package to support SyncNow
+type Syncer interface {
+	SyncNow() error
+}
+
+// SyncNow starts a synchronization of the watcher without waiting for the polling interval
+func SyncNow(w Watcher) error {
+	switch s := w.(type) {
+	case Syncer:
+		return s.SyncNow()
+	case syncRequester:
+		return s.requestSync()
+	default:
+		return fmt.Errorf("the watcher doesn't support the synchronization on demand")
+	}
+}
+
 func init() {
 	supportedServices = make(map[string]storageFunc)
 }
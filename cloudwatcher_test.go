@@ -1,8 +1,13 @@
 package cloudwatcher
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
 )
 
 func TestNew(t *testing.T) {
@@ -16,3 +21,46 @@ func TestNew(t *testing.T) {
 		t.Errorf("error during creation: %s", err)
 	}
 }
+
+func TestLocalWatcher_DirectoryNotFound(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "watched")
+	if _, err := New("local", dir, time.Hour); !isNotFound(err) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("test"), 0644)
+	w, err := New("local", dir, time.Hour, WithClock(clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.SetConfig(map[string]string{"disable_fsnotify": "true"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer w.Close()
+	waitSyncs(t, w, 1)
+
+	// the removal of the directory is fatal, its files are not reported as deleted
+	os.RemoveAll(dir)
+	SyncNow(w)
+	waitSyncs(t, w, 2)
+	select {
+	case err := <-w.GetErrors():
+		if !isNotFound(err) {
+			t.Errorf("wrong error returned: %v", err)
+		}
+	default:
+		t.Errorf("error expected")
+	}
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("no events expected: %v", events)
+	}
+}
+
+func isNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
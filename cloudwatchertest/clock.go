@@ -0,0 +1,18 @@
+// Package cloudwatchertest contains the helpers to test the code using cloudwatcher
+package cloudwatchertest
+
+import (
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+// FakeClock is a cloudwatcher.Clock that moves only when Advance is called. Pass it to cloudwatcher.New with
+// cloudwatcher.WithClock, wait for the polling ticker with BlockUntil(1) and call Advance(interval) to trigger a
+// synchronization.
+type FakeClock = clock.Fake
+
+// NewFakeClock creates a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}
@@ -0,0 +1,301 @@
+package cloudwatchertest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+// ConformanceInterval is the polling interval the watchers created by a Factory have to use: the suite triggers a
+// synchronization advancing the clock by this amount
+const ConformanceInterval = time.Minute
+
+// conformanceTimeout is the time waited for a synchronization or for an event
+const conformanceTimeout = 10 * time.Second
+
+// Factory creates a configured (but not started) watcher on the storage mutated by the Driver. The watcher has to
+// poll every ConformanceInterval using clock. The suite calls it many times: all the watchers share the same storage.
+type Factory func(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher
+
+// Driver mutates the storage watched by the watchers of a Factory. The names are flat file names ("create.txt"),
+// each one is used by a single test of the suite. The methods call t.Fatal if the storage can't be changed.
+type Driver interface {
+	// Create stores a new object
+	Create(t *testing.T, name string)
+	// Change modifies the content of an existing object
+	Change(t *testing.T, name string)
+	// Delete removes an existing object
+	Delete(t *testing.T, name string)
+	// SetTags changes the tags (or what the backend reports as TagsChanged) of an existing object, it returns false
+	// if the backend doesn't support them
+	SetTags(t *testing.T, name string) bool
+	// Key returns the key of the events of the object
+	Key(name string) string
+}
+
+// RunConformance verifies that the watchers created by factory behave as the built-in ones:
+//   - the first synchronization doesn't send events for the existing objects, also when a watcher is restarted
+//   - a new object sends one FileCreated, a modified one a FileChanged, a removed one a FileDeleted and a change of
+//     the tags a TagsChanged, all of them with a non nil Object and nothing else
+//   - a synchronization without changes doesn't send events
+//   - Close closes the Events and the Errors chans
+//   - the events can be filtered by type and key with a Hub
+func RunConformance(t *testing.T, factory Factory, driver Driver) {
+	t.Run("Create", func(t *testing.T) {
+		h := startHarness(t, factory)
+		driver.Create(t, "create.txt")
+		expectEvents(t, h.step(t), ev(driver.Key("create.txt"), cloudwatcher.FileCreated))
+		expectEvents(t, h.step(t))
+	})
+
+	t.Run("Change", func(t *testing.T) {
+		h := startHarness(t, factory)
+		driver.Create(t, "change.txt")
+		h.step(t)
+		driver.Change(t, "change.txt")
+		expectEvents(t, h.step(t), ev(driver.Key("change.txt"), cloudwatcher.FileChanged))
+		expectEvents(t, h.step(t))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		h := startHarness(t, factory)
+		driver.Create(t, "delete.txt")
+		h.step(t)
+		driver.Delete(t, "delete.txt")
+		expectEvents(t, h.step(t), ev(driver.Key("delete.txt"), cloudwatcher.FileDeleted))
+		expectEvents(t, h.step(t))
+	})
+
+	t.Run("TagsChanged", func(t *testing.T) {
+		h := startHarness(t, factory)
+		driver.Create(t, "tags.txt")
+		h.step(t)
+		if !driver.SetTags(t, "tags.txt") {
+			t.Skip("tags not supported")
+		}
+		expectEvents(t, h.step(t), ev(driver.Key("tags.txt"), cloudwatcher.TagsChanged))
+		expectEvents(t, h.step(t))
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		h := startHarness(t, factory)
+		driver.Create(t, "restart.txt")
+		expectEvents(t, h.step(t), ev(driver.Key("restart.txt"), cloudwatcher.FileCreated))
+		h.close(t)
+
+		// the objects found by the first synchronization are not new
+		h = startHarness(t, factory)
+		expectEvents(t, h.drain(t))
+		driver.Change(t, "restart.txt")
+		expectEvents(t, h.step(t), ev(driver.Key("restart.txt"), cloudwatcher.FileChanged))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		h := startHarness(t, factory)
+		h.close(t)
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		clock := NewFakeClock(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+		w := factory(t, clock)
+		hub, err := cloudwatcher.NewHub(w)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		byKey := hub.Subscribe(&cloudwatcher.Filter{Include: []string{"**filter-*.txt"}}, nil)
+		byType := hub.Subscribe(&cloudwatcher.Filter{Ops: []cloudwatcher.Op{cloudwatcher.FileCreated}}, nil)
+		if err := hub.Start(); err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer hub.Close()
+		h := &harness{w: w, clock: clock, hub: true}
+		h.waitStarted(t)
+
+		driver.Create(t, "filter-a.txt")
+		driver.Create(t, "filter-b.log")
+		h.step(t)
+		driver.Delete(t, "filter-a.txt")
+		h.step(t)
+		// the marker is accepted by both the filters, the events before it are all the ones delivered
+		driver.Create(t, "filter-marker.txt")
+		h.step(t)
+
+		marker := driver.Key("filter-marker.txt")
+		expectEvents(t, receiveUntil(t, byKey, marker),
+			ev(driver.Key("filter-a.txt"), cloudwatcher.FileCreated),
+			ev(driver.Key("filter-a.txt"), cloudwatcher.FileDeleted),
+			ev(marker, cloudwatcher.FileCreated))
+		expectEvents(t, sortEvents(receiveUntil(t, byType, marker)),
+			ev(driver.Key("filter-a.txt"), cloudwatcher.FileCreated),
+			ev(driver.Key("filter-b.log"), cloudwatcher.FileCreated),
+			ev(marker, cloudwatcher.FileCreated))
+	})
+}
+
+// harness drives a watcher with a fake clock
+type harness struct {
+	w      cloudwatcher.Watcher
+	clock  *FakeClock
+	hub    bool // the events are consumed by a Hub
+	closed bool
+}
+
+func startHarness(t *testing.T, factory Factory) *harness {
+	t.Helper()
+	h := &harness{clock: NewFakeClock(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))}
+	h.w = factory(t, h.clock)
+	if err := h.w.Start(); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	t.Cleanup(func() {
+		if !h.closed {
+			h.close(t)
+		}
+	})
+	h.waitStarted(t)
+	return h
+}
+
+// waitStarted waits for the polling ticker and the end of the first synchronization
+func (h *harness) waitStarted(t *testing.T) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		h.clock.BlockUntil(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(conformanceTimeout):
+		t.Fatalf("the watcher doesn't use the clock for the polling")
+	}
+	h.waitSyncs(t, 1)
+}
+
+func (h *harness) waitSyncs(t *testing.T, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(conformanceTimeout)
+	for h.w.Status().Syncs < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("synchronization %d not completed", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// step runs a synchronization and returns the events sent by it
+func (h *harness) step(t *testing.T) []cloudwatcher.Event {
+	t.Helper()
+	syncs := h.w.Status().Syncs
+	h.clock.Advance(ConformanceInterval)
+	h.waitSyncs(t, syncs+1)
+	return h.drain(t)
+}
+
+// drain returns the events already sent, the events of a synchronization are sent before its end
+func (h *harness) drain(t *testing.T) []cloudwatcher.Event {
+	t.Helper()
+	if h.hub {
+		return nil
+	}
+	events := make([]cloudwatcher.Event, 0)
+	for {
+		select {
+		case e := <-h.w.GetEvents():
+			events = append(events, e)
+		case err := <-h.w.GetErrors():
+			t.Errorf("unexpected error: %s", err)
+		default:
+			return sortEvents(events)
+		}
+	}
+}
+
+// close stops the watcher and checks that both the chans are closed
+func (h *harness) close(t *testing.T) {
+	t.Helper()
+	h.closed = true
+	h.w.Close()
+	timeout := time.After(conformanceTimeout)
+	events, errors := h.w.GetEvents(), h.w.GetErrors()
+	for events != nil || errors != nil {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		case _, ok := <-errors:
+			if !ok {
+				errors = nil
+			}
+		case <-timeout:
+			t.Fatalf("the chans are not closed by Close")
+		}
+	}
+}
+
+// expected is an event expected by the suite
+type expected struct {
+	key string
+	op  cloudwatcher.Op
+}
+
+func ev(key string, op cloudwatcher.Op) expected {
+	return expected{key: key, op: op}
+}
+
+// expectEvents checks the received events, all of them need an Object
+func expectEvents(t *testing.T, events []cloudwatcher.Event, want ...expected) {
+	t.Helper()
+	if len(events) != len(want) {
+		t.Errorf("%d events received, %d expected: %v", len(events), len(want), describe(events))
+		return
+	}
+	for i, e := range events {
+		if e.Key != want[i].key || e.Type != want[i].op {
+			t.Errorf("event %d: received %s %s, expected %s %s", i, e.Type, e.Key, want[i].op, want[i].key)
+		}
+		if e.Object == nil {
+			t.Errorf("event %d: %s %s without Object", i, e.Type, e.Key)
+		}
+	}
+}
+
+func receiveUntil(t *testing.T, s *cloudwatcher.Subscription, key string) []cloudwatcher.Event {
+	t.Helper()
+	events := make([]cloudwatcher.Event, 0)
+	timeout := time.After(conformanceTimeout)
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				t.Fatalf("subscription closed before %s", key)
+			}
+			events = append(events, e)
+			if e.Key == key {
+				return events
+			}
+		case <-timeout:
+			t.Fatalf("event of %s not received, received %v", key, describe(events))
+		}
+	}
+}
+
+// sortEvents orders the events of a synchronization by key: the order of the events of different objects is not
+// defined
+func sortEvents(events []cloudwatcher.Event) []cloudwatcher.Event {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+	return events
+}
+
+func describe(events []cloudwatcher.Event) []string {
+	s := make([]string, 0, len(events))
+	for _, e := range events {
+		s = append(s, e.Type.String()+" "+e.Key)
+	}
+	return s
+}
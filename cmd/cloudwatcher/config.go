@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"gopkg.in/yaml.v3"
+)
+
+// daemonConfig is the content of the configuration file of the daemon
+type daemonConfig struct {
+	Watchers map[string]*watcherConfig `yaml:"watchers" json:"watchers"`
+	Sinks    map[string]*sinkConfig    `yaml:"sinks" json:"sinks"`
+	HTTP     httpConfig                `yaml:"http" json:"http"`
+}
+
+// httpConfig enables the HTTP server streaming the events of the watchers
+type httpConfig struct {
+	Listen  string `yaml:"listen" json:"listen"`   // address of the server, if empty the server is disabled
+	History int    `yaml:"history" json:"history"` // events kept for each watcher to resume the streams
+}
+
+// watcherConfig defines a watcher and the sinks receiving its events
+type watcherConfig struct {
+	Service  string            `yaml:"service" json:"service"`
+	Dir      string            `yaml:"dir" json:"dir"`
+	Interval string            `yaml:"interval" json:"interval"`
+	Config   map[string]string `yaml:"config" json:"config"`
+	Filter   *filterConfig     `yaml:"filter" json:"filter"`
+	Sinks    []string          `yaml:"sinks" json:"sinks"`
+
+	interval time.Duration
+	filter   *cloudwatcher.Filter
+}
+
+type filterConfig struct {
+	Ops     []string `yaml:"ops" json:"ops"`
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+}
+
+// sinkConfig defines a sink, the used fields depend on the type
+type sinkConfig struct {
+	Type string `yaml:"type" json:"type"` // stdout, file, webhook, exec
+
+	// file
+	Path string `yaml:"path" json:"path"`
+
+	// stdout, file, webhook
+	Format string `yaml:"format" json:"format"` // json, cloudevents, cloudevents-binary (webhook only)
+	Source string `yaml:"source" json:"source"` // CloudEvents source
+
+	// webhook
+	URLs           []string          `yaml:"urls" json:"urls"`
+	Secret         string            `yaml:"secret" json:"secret"`
+	Headers        map[string]string `yaml:"headers" json:"headers"`
+	Timeout        string            `yaml:"timeout" json:"timeout"`
+	MaxRetries     int               `yaml:"max_retries" json:"max_retries"`
+	DeadLetterFile string            `yaml:"dead_letter_file" json:"dead_letter_file"`
+	BatchSize      int               `yaml:"batch_size" json:"batch_size"`
+	FlushInterval  string            `yaml:"flush_interval" json:"flush_interval"`
+
+	// exec
+	Command     []string      `yaml:"command" json:"command"`
+	Concurrency int           `yaml:"concurrency" json:"concurrency"`
+	Overlap     string        `yaml:"overlap" json:"overlap"` // queue, drop
+	WorkDir     string        `yaml:"dir" json:"dir"`
+	Env         []string      `yaml:"env" json:"env"`
+	Filter      *filterConfig `yaml:"filter" json:"filter"`
+
+	timeout       time.Duration
+	flushInterval time.Duration
+	filter        *cloudwatcher.Filter
+}
+
+// loadConfig reads, resolves and validates the configuration file
+func loadConfig(path string) (*daemonConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %s", err)
+	}
+
+	cfg := &daemonConfig{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config '%s': %s", path, err)
+	}
+
+	if err := cfg.resolve(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolve replaces the secret references (e.g. "env:NAME" or "file:/path") of the sinks. The references in the
+// config of the watchers are resolved by the watchers, only in their secret fields.
+func (c *daemonConfig) resolve() error {
+	ctx := context.Background()
+	for name, s := range c.Sinks {
+		if s == nil {
+			continue
+		}
+		secret, err := cloudwatcher.ResolveSecret(ctx, s.Secret)
+		if err != nil {
+			return fmt.Errorf("sink '%s': secret: %s", name, err)
+		}
+		s.Secret = secret
+		for k, v := range s.Headers {
+			r, err := cloudwatcher.ResolveSecret(ctx, v)
+			if err != nil {
+				return fmt.Errorf("sink '%s': header %s: %s", name, k, err)
+			}
+			s.Headers[k] = r
+		}
+	}
+	return nil
+}
+
+func (c *daemonConfig) validate() error {
+	if len(c.Watchers) == 0 {
+		return fmt.Errorf("no watchers defined")
+	}
+	if c.HTTP.History < 0 {
+		return fmt.Errorf("http: wrong history %d", c.HTTP.History)
+	}
+
+	for _, name := range sortedKeys(c.Sinks) {
+		s := c.Sinks[name]
+		if s == nil {
+			return fmt.Errorf("sink '%s': empty definition", name)
+		}
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("sink '%s': %s", name, err)
+		}
+	}
+
+	for _, name := range sortedKeys(c.Watchers) {
+		w := c.Watchers[name]
+		if w == nil {
+			return fmt.Errorf("watcher '%s': empty definition", name)
+		}
+		if !inList(w.Service, cloudwatcher.Services()) {
+			return fmt.Errorf("watcher '%s': service '%s' is not supported", name, w.Service)
+		}
+		w.interval = 30 * time.Second
+		if w.Interval != "" {
+			d, err := time.ParseDuration(w.Interval)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("watcher '%s': wrong interval '%s'", name, w.Interval)
+			}
+			w.interval = d
+		}
+		f, err := w.Filter.build()
+		if err != nil {
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		w.filter = f
+		if len(w.Sinks) == 0 {
+			return fmt.Errorf("watcher '%s': no sinks defined", name)
+		}
+		for _, sink := range w.Sinks {
+			if _, ok := c.Sinks[sink]; !ok {
+				return fmt.Errorf("watcher '%s': unknown sink '%s'", name, sink)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sinkConfig) validate() error {
+	var err error
+	if s.timeout, err = parseOptionalDuration(s.Timeout); err != nil {
+		return fmt.Errorf("wrong timeout: %s", err)
+	}
+	if s.flushInterval, err = parseOptionalDuration(s.FlushInterval); err != nil {
+		return fmt.Errorf("wrong flush_interval: %s", err)
+	}
+	if s.filter, err = s.Filter.build(); err != nil {
+		return err
+	}
+
+	switch s.Type {
+	case "stdout":
+		if !inList(s.Format, []string{"", "json", "cloudevents"}) {
+			return fmt.Errorf("unknown format '%s'", s.Format)
+		}
+	case "file":
+		if s.Path == "" {
+			return fmt.Errorf("path required")
+		}
+		if !inList(s.Format, []string{"", "json", "cloudevents"}) {
+			return fmt.Errorf("unknown format '%s'", s.Format)
+		}
+	case "webhook":
+		if len(s.URLs) == 0 {
+			return fmt.Errorf("urls required")
+		}
+		if !inList(s.Format, []string{"", "json", "cloudevents", "cloudevents-binary"}) {
+			return fmt.Errorf("unknown format '%s'", s.Format)
+		}
+	case "exec":
+		if len(s.Command) == 0 {
+			return fmt.Errorf("command required")
+		}
+		if !inList(s.Overlap, []string{"", "queue", "drop"}) {
+			return fmt.Errorf("unknown overlap '%s'", s.Overlap)
+		}
+	default:
+		return fmt.Errorf("unknown type '%s'", s.Type)
+	}
+	return nil
+}
+
+func (f *filterConfig) build() (*cloudwatcher.Filter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	filter := &cloudwatcher.Filter{
+		Include: f.Include,
+		Exclude: f.Exclude,
+	}
+	for _, name := range f.Ops {
+		var op cloudwatcher.Op
+		if err := op.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+		filter.Ops = append(filter.Ops, op)
+	}
+	return filter, nil
+}
+
+func parseOptionalDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func inList(needle string, haystack []string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
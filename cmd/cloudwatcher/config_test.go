@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("s3cr3t\n"), 0600)
+	t.Setenv("CW_TEST_TOKEN", "Bearer token-value")
+
+	path := writeConfig(t, "config.yaml", `
+watchers:
+  docs:
+    service: local
+    dir: /tmp
+    interval: 10s
+    config:
+      disable_fsnotify: "true"
+      token: env:CW_TEST_TOKEN
+      password: file:`+secret+`
+    filter:
+      ops: [FileCreated, filechanged]
+      include: ["**/*.md"]
+    sinks: [out, hook]
+sinks:
+  out:
+    type: stdout
+  hook:
+    type: webhook
+    urls: [http://localhost/hook]
+    secret: file:`+secret+`
+    headers:
+      Authorization: env:CW_TEST_TOKEN
+    batch_size: 10
+    flush_interval: 2s
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w := cfg.Watchers["docs"]
+	if w.Config["token"] != "env:CW_TEST_TOKEN" || w.Config["password"] != "file:"+secret {
+		t.Errorf("the references are resolved by the watchers: %v", w.Config)
+	}
+	if w.interval.Seconds() != 10 {
+		t.Errorf("wrong interval: %s", w.interval)
+	}
+	if len(w.filter.Ops) != 2 || w.filter.Ops[1] != cloudwatcher.FileChanged {
+		t.Errorf("wrong filter: %#v", w.filter)
+	}
+
+	s := cfg.Sinks["hook"]
+	if s.Secret != "s3cr3t" || s.Headers["Authorization"] != "Bearer token-value" {
+		t.Errorf("references not resolved: %s %v", s.Secret, s.Headers)
+	}
+	if s.flushInterval.Seconds() != 2 {
+		t.Errorf("wrong flush interval: %s", s.flushInterval)
+	}
+
+	// the same configuration in JSON
+	path = writeConfig(t, "config.json", `{
+		"watchers": {"docs": {"service": "local", "dir": "/tmp", "sinks": ["out"]}},
+		"sinks": {"out": {"type": "stdout"}}
+	}`)
+	if _, err := loadConfig(path); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestLoadConfig_Validation(t *testing.T) {
+	tests := map[string]string{
+		"no watchers defined": `sinks: {out: {type: stdout}}`,
+		"not supported":       `{watchers: {w: {service: wrong, dir: /, sinks: [out]}}, sinks: {out: {type: stdout}}}`,
+		"unknown sink":        `{watchers: {w: {service: local, dir: /, sinks: [missing]}}, sinks: {out: {type: stdout}}}`,
+		"wrong interval":      `{watchers: {w: {service: local, dir: /, interval: x, sinks: [out]}}, sinks: {out: {type: stdout}}}`,
+		"unknown type":        `{watchers: {w: {service: local, dir: /, sinks: [out]}}, sinks: {out: {type: kafka}}}`,
+		"unknown format":      `{watchers: {w: {service: local, dir: /, sinks: [out]}}, sinks: {out: {type: stdout, format: xml}}}`,
+		"urls required":       `{watchers: {w: {service: local, dir: /, sinks: [out]}}, sinks: {out: {type: webhook}}}`,
+		"unknown event type":  `{watchers: {w: {service: local, dir: /, sinks: [out], filter: {ops: [x]}}}, sinks: {out: {type: stdout}}}`,
+		"CW_TEST_MISSING":     `{watchers: {w: {service: local, dir: /, sinks: [out]}}, sinks: {out: {type: webhook, urls: [x], secret: "env:CW_TEST_MISSING"}}}`,
+	}
+
+	for expected, content := range tests {
+		_, err := loadConfig(writeConfig(t, "config.yaml", content))
+		if err == nil {
+			t.Errorf("an error containing '%s' should be returned", expected)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("wrong error returned: '%s' should contain '%s'", err, expected)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/Matrix86/cloudwatcher/httpstream"
+)
+
+// reconfigureTimeout is the maximum time waited for the end of the running synchronization of a watcher
+const reconfigureTimeout = time.Minute
+
+// daemon runs the watchers defined in the configuration file and routes their events to the sinks
+type daemon struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	stdoutMu sync.Mutex
+	stderrMu sync.Mutex
+
+	// http is the configuration of the HTTP server, nil if disabled
+	http *httpConfig
+
+	mu       sync.RWMutex
+	watchers map[string]*runningWatcher
+	sinks    map[string]*runningSink
+	routers  sync.WaitGroup
+	stopping sync.WaitGroup
+}
+
+type runningWatcher struct {
+	name    string
+	def     *watcherConfig
+	watcher cloudwatcher.Watcher
+	hub     *cloudwatcher.Hub
+	sub     *cloudwatcher.Subscription
+	server  *httpstream.Server
+}
+
+type runningSink struct {
+	name  string
+	def   *sinkConfig
+	sink  cloudwatcher.Sink
+	input *sinkInput
+	done  chan bool
+
+	// stop is closed when the sink is stopped, senders are the routers sending to input
+	stop    chan bool
+	senders sync.WaitGroup
+}
+
+// sinkInput is the EventSource forwarded to a sink
+type sinkInput struct {
+	events chan cloudwatcher.Event
+	errors chan error
+}
+
+func (s *sinkInput) GetEvents() chan cloudwatcher.Event {
+	return s.events
+}
+
+func (s *sinkInput) GetErrors() chan error {
+	return s.errors
+}
+
+func newDaemon(stdout, stderr io.Writer) *daemon {
+	return &daemon{
+		stdout:   stdout,
+		stderr:   stderr,
+		watchers: make(map[string]*runningWatcher),
+		sinks:    make(map[string]*runningSink),
+	}
+}
+
+func daemonCommand(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path := fs.String("config", "", "configuration file (YAML or JSON)")
+	check := fs.Bool("check", false, "validate the configuration file and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: cloudwatcher daemon --config <file> [--check]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	if *check {
+		fmt.Fprintf(stdout, "configuration ok: %d watchers, %d sinks\n", len(cfg.Watchers), len(cfg.Sinks))
+		return 0
+	}
+
+	d := newDaemon(stdout, stderr)
+	if cfg.HTTP.Listen != "" {
+		d.http = &cfg.HTTP
+	}
+	if err := d.apply(cfg); err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	defer d.shutdown()
+
+	if d.http != nil {
+		ln, err := net.Listen("tcp", d.http.Listen)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: http: %s\n", err)
+			return 1
+		}
+		srv := &http.Server{Handler: d}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			if err := d.reload(*path); err != nil {
+				d.printError("reload", fmt.Errorf("%s: keeping the current configuration", err))
+			}
+
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// reload loads the configuration file and applies it
+func (d *daemon) reload(path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	return d.apply(cfg)
+}
+
+// ServeHTTP serves the streams of the watchers on /watchers/<name>/ and their names on /watchers
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/watchers" {
+		d.mu.RLock()
+		names := sortedKeys(d.watchers)
+		d.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/watchers/"), "/")
+	d.mu.RLock()
+	rw, ok := d.watchers[name]
+	d.mu.RUnlock()
+	if !strings.HasPrefix(r.URL.Path, "/watchers/") || !ok || rw.server == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/watchers/"+name, rw.server).ServeHTTP(w, r)
+}
+
+// apply replaces the running configuration with cfg. The watchers whose source didn't change keep running
+// with their caches, only their routes are updated. If a new watcher or sink cannot be started, the
+// running configuration is kept. The watchers whose config changed are reconfigured without restarting them.
+func (d *daemon) apply(cfg *daemonConfig) error {
+	// the reconfiguration waits for the running synchronization, whose events are routed holding d.mu
+	var reconfiguring []*runningWatcher
+	defer func() {
+		d.reconfigure(reconfiguring, cfg)
+	}()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.http != nil && !reflect.DeepEqual(*d.http, cfg.HTTP) {
+		d.printError("http", fmt.Errorf("the changes of the http section require a restart"))
+	}
+
+	// creating the new watchers before starting anything
+	created := make(map[string]cloudwatcher.Watcher)
+	reconfigured := make(map[string]bool)
+	for _, name := range sortedKeys(cfg.Watchers) {
+		def := cfg.Watchers[name]
+		if old, ok := d.watchers[name]; ok && old.canKeep(def) {
+			if err := old.watcher.Capabilities().CheckConfig(def.Config); err != nil {
+				return fmt.Errorf("watcher '%s': %s", name, err)
+			}
+			if err := def.filter.Check(old.watcher.Capabilities()); err != nil {
+				return fmt.Errorf("watcher '%s': %s", name, err)
+			}
+			reconfigured[name] = !reflect.DeepEqual(old.def.Config, def.Config)
+			continue
+		}
+		w, err := cloudwatcher.New(def.Service, def.Dir, def.interval)
+		if err != nil {
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		if err := w.Capabilities().CheckConfig(def.Config); err != nil {
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		if err := w.SetConfig(def.Config); err != nil {
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		if err := def.filter.Check(w.Capabilities()); err != nil {
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		created[name] = w
+	}
+
+	sinks := make(map[string]*runningSink)
+	newSinks := make([]*runningSink, 0)
+	rollback := func() {
+		for _, s := range newSinks {
+			d.stopSink(s)
+		}
+	}
+	for _, name := range sortedKeys(cfg.Sinks) {
+		def := cfg.Sinks[name]
+		if old, ok := d.sinks[name]; ok && reflect.DeepEqual(old.def, def) {
+			sinks[name] = old
+			continue
+		}
+		s, err := newSink(def, d.stdout, &d.stdoutMu, func(err error) { d.printError(name, err) })
+		if err != nil {
+			rollback()
+			return fmt.Errorf("sink '%s': %s", name, err)
+		}
+		rs := d.startSink(name, def, s)
+		sinks[name] = rs
+		newSinks = append(newSinks, rs)
+	}
+
+	started := make([]*runningWatcher, 0, len(created))
+	for _, name := range sortedKeys(created) {
+		rw, err := d.startWatcher(name, cfg.Watchers[name], created[name])
+		if err != nil {
+			for _, rw := range started {
+				rw.hub.Close()
+			}
+			rollback()
+			return fmt.Errorf("watcher '%s': %s", name, err)
+		}
+		started = append(started, rw)
+	}
+
+	// everything started: replacing the running configuration
+	for name, rw := range d.watchers {
+		def, ok := cfg.Watchers[name]
+		if _, replaced := created[name]; !ok || replaced {
+			rw.hub.Close()
+			delete(d.watchers, name)
+			continue
+		}
+		if reconfigured[name] {
+			reconfiguring = append(reconfiguring, rw)
+			continue
+		}
+		rw.def = def
+	}
+	for _, rw := range started {
+		d.watchers[rw.name] = rw
+		d.routers.Add(1)
+		go d.route(rw)
+	}
+
+	for name, rs := range d.sinks {
+		if sinks[name] != rs {
+			d.stopping.Add(1)
+			go func(rs *runningSink) {
+				defer d.stopping.Done()
+				d.stopSink(rs)
+			}(rs)
+		}
+	}
+	d.sinks = sinks
+	return nil
+}
+
+// reconfigure applies the new config to the running watchers, the old definition is kept if it fails
+func (d *daemon) reconfigure(watchers []*runningWatcher, cfg *daemonConfig) {
+	for _, rw := range watchers {
+		def := cfg.Watchers[rw.name]
+		ctx, cancel := context.WithTimeout(context.Background(), reconfigureTimeout)
+		err := cloudwatcher.Reconfigure(ctx, rw.watcher, def.Config)
+		cancel()
+		if err != nil {
+			d.printError(rw.name, fmt.Errorf("%s: keeping the current configuration", err))
+			continue
+		}
+		d.mu.Lock()
+		rw.def = def
+		d.mu.Unlock()
+	}
+}
+
+// shutdown closes all the watchers and waits for the delivery of their events to the sinks
+func (d *daemon) shutdown() {
+	d.mu.Lock()
+	for name, rw := range d.watchers {
+		rw.hub.Close()
+		delete(d.watchers, name)
+	}
+	d.mu.Unlock()
+
+	d.routers.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, rs := range d.sinks {
+		d.stopSink(rs)
+		delete(d.sinks, name)
+	}
+	d.stopping.Wait()
+}
+
+// startWatcher starts the watcher with its hub, the subscription used to route its events and the HTTP streams
+func (d *daemon) startWatcher(name string, def *watcherConfig, w cloudwatcher.Watcher) (*runningWatcher, error) {
+	hub, err := cloudwatcher.NewHub(w)
+	if err != nil {
+		return nil, err
+	}
+	rw := &runningWatcher{
+		name:    name,
+		def:     def,
+		watcher: w,
+		hub:     hub,
+		sub:     hub.Subscribe(nil, nil),
+	}
+	if d.http != nil {
+		rw.server = httpstream.New(hub, &httpstream.Config{History: d.http.History})
+	}
+	if err := hub.Start(); err != nil {
+		hub.Close()
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (d *daemon) route(rw *runningWatcher) {
+	defer d.routers.Done()
+
+	events := rw.sub.Events()
+	errors := rw.sub.Errors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			// the sinks are copied under the lock, a slow sink must not block the reload of the configuration
+			d.mu.RLock()
+			targets := make([]*runningSink, 0, len(rw.def.Sinks))
+			if rw.def.filter.Match(e) {
+				for _, name := range rw.def.Sinks {
+					if rs, ok := d.sinks[name]; ok && rs.def.filter.Match(e) {
+						rs.senders.Add(1)
+						targets = append(targets, rs)
+					}
+				}
+			}
+			d.mu.RUnlock()
+
+			for _, rs := range targets {
+				select {
+				case rs.input.events <- e:
+				case <-rs.stop:
+				}
+				rs.senders.Done()
+			}
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			d.printError(rw.name, err)
+		}
+	}
+}
+
+func (d *daemon) startSink(name string, def *sinkConfig, s cloudwatcher.Sink) *runningSink {
+	rs := &runningSink{
+		name: name,
+		def:  def,
+		sink: s,
+		input: &sinkInput{
+			events: make(chan cloudwatcher.Event, 100),
+			errors: make(chan error),
+		},
+		done: make(chan bool),
+		stop: make(chan bool),
+	}
+	go func() {
+		defer close(rs.done)
+		cloudwatcher.Forward(context.Background(), rs.input, s, &cloudwatcher.ForwardOptions{
+			BatchSize:     def.BatchSize,
+			FlushInterval: def.flushInterval,
+			OnError:       func(err error) { d.printError(name, err) },
+		})
+	}()
+	return rs
+}
+
+// stopSink delivers the pending events and closes the sink. It is called once the sink has been removed from the
+// running ones, the routers still sending to it are stopped before closing its input.
+func (d *daemon) stopSink(rs *runningSink) {
+	close(rs.stop)
+	rs.senders.Wait()
+	close(rs.input.events)
+	<-rs.done
+	if err := rs.sink.Close(); err != nil {
+		d.printError(rs.name, err)
+	}
+}
+
+func (d *daemon) printError(name string, err error) {
+	d.stderrMu.Lock()
+	defer d.stderrMu.Unlock()
+	fmt.Fprintf(d.stderr, "error: [%s] %s\n", name, err)
+}
+
+// sameWatcher returns true if the two definitions watch the same directory with the same interval
+func sameWatcher(a, b *watcherConfig) bool {
+	return a.Service == b.Service &&
+		a.Dir == b.Dir &&
+		a.interval == b.interval
+}
+
+// canKeep returns true if the running watcher can be used with the new definition, changing its configuration if
+// it supports the reconfiguration
+func (rw *runningWatcher) canKeep(def *watcherConfig) bool {
+	if !sameWatcher(rw.def, def) {
+		return false
+	}
+	if _, ok := rw.watcher.(cloudwatcher.Reconfigurer); ok {
+		return true
+	}
+	return reflect.DeepEqual(rw.def.Config, def.Config)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForFile(t *testing.T, path string, content string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), content) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("'%s' not found in %s", content, path)
+}
+
+// writeFile creates the file atomically, so the polling doesn't see it half written
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), filepath.Base(path))
+	os.WriteFile(tmp, []byte("x"), 0644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	other := filepath.Join(dir, "other")
+	os.Mkdir(watched, 0755)
+	os.Mkdir(other, 0755)
+	outA := filepath.Join(dir, "a.jsonl")
+	outB := filepath.Join(dir, "b.jsonl")
+
+	config := `
+watchers:
+  main:
+    service: local
+    dir: ` + watched + `
+    interval: 20ms
+    config: {disable_fsnotify: "true"}
+    sinks: [%s]
+  other:
+    service: local
+    dir: %s
+    interval: 20ms
+    config: {disable_fsnotify: "true"}
+    sinks: [a]
+sinks:
+  a: {type: file, path: ` + outA + `}
+  b: {type: file, path: ` + outB + `}
+`
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(strings.NewReplacer("[%s]", "[a]", "%s", other).Replace(config)), 0600)
+
+	stderr := &bytes.Buffer{}
+	d := newDaemon(&bytes.Buffer{}, stderr)
+	if err := d.reload(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer d.shutdown()
+
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, filepath.Join(watched, "first.txt"))
+	waitForFile(t, outA, "first.txt")
+
+	main := d.watchers["main"].watcher
+	otherWatcher := d.watchers["other"].watcher
+
+	// routing changed for main, source changed for other
+	os.WriteFile(path, []byte(strings.NewReplacer("[%s]", "[b]", "%s", watched).Replace(config)), 0600)
+	if err := d.reload(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if d.watchers["main"].watcher != main {
+		t.Errorf("the unchanged watcher should keep running")
+	}
+	if d.watchers["other"].watcher == otherWatcher {
+		t.Errorf("the changed watcher should be restarted")
+	}
+
+	// the cache of main has been kept: only the new file generates an event
+	writeFile(t, filepath.Join(watched, "second.txt"))
+	waitForFile(t, outB, "second.txt")
+	data, _ := os.ReadFile(outB)
+	if strings.Contains(string(data), "first.txt") {
+		t.Errorf("the cache of the unchanged watcher has been lost")
+	}
+
+	// an invalid configuration keeps the current one
+	os.WriteFile(path, []byte("watchers: {}"), 0600)
+	if err := d.reload(path); err == nil {
+		t.Errorf("an error should be returned")
+	}
+	if d.watchers["main"].watcher != main {
+		t.Errorf("the running configuration should be kept")
+	}
+
+	// the config keys not supported by the service are rejected
+	unsupported := strings.NewReplacer("[%s]", "[b]", "%s", other, `{disable_fsnotify: "true"}`, `{bucket_name: "test"}`).Replace(config)
+	os.WriteFile(path, []byte(unsupported), 0600)
+	if err := d.reload(path); err == nil || !strings.Contains(err.Error(), "bucket_name") {
+		t.Errorf("wrong error returned: %v", err)
+	}
+
+	// a different config is applied to the running watcher
+	debug := strings.NewReplacer("[%s]", "[b]", "%s", watched, `{disable_fsnotify: "true"}`, `{disable_fsnotify: "true", debug: "true"}`).Replace(config)
+	os.WriteFile(path, []byte(debug), 0600)
+	if err := d.reload(path); err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.mu.RLock()
+	rw := d.watchers["main"]
+	d.mu.RUnlock()
+	if rw.watcher != main {
+		t.Errorf("the reconfigured watcher should keep running")
+	}
+	if rw.def.Config["debug"] != "true" {
+		t.Errorf("the new config has not been applied: %v", rw.def.Config)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("unexpected errors: %s", stderr.String())
+	}
+}
+
+func TestDaemon_HTTP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+watchers:
+  main:
+    service: local
+    dir: `+dir+`
+    interval: 20ms
+    config: {disable_fsnotify: "true"}
+    sinks: [out]
+sinks:
+  out: {type: stdout}
+http:
+  listen: 127.0.0.1:0
+`), 0600)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	d := newDaemon(&bytes.Buffer{}, &bytes.Buffer{})
+	d.http = &cfg.HTTP
+	if err := d.apply(cfg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer d.shutdown()
+
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/watchers")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	names := make([]string, 0)
+	json.NewDecoder(res.Body).Decode(&names)
+	res.Body.Close()
+	if len(names) != 1 || names[0] != "main" {
+		t.Errorf("wrong watchers: %v", names)
+	}
+
+	res, _ = http.Get(srv.URL + "/watchers/main/status")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code: %d", res.StatusCode)
+	}
+	res, _ = http.Get(srv.URL + "/watchers/unknown/status")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code: %d", res.StatusCode)
+	}
+}
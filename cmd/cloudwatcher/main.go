@@ -0,0 +1,61 @@
+// Command cloudwatcher watches a directory of any supported service and streams its events as JSON lines.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+const usage = `usage: cloudwatcher <command> [arguments]
+
+commands:
+  watch <service> <dir> [flags]   stream the events of the directory as JSON lines
+  daemon --config <file>          run the watchers defined in the file (reloaded on SIGHUP)
+  snapshot <service> <dir>        write a snapshot of all the objects of the directory
+  diff <old.snap> <new.snap>      print the events between two snapshots (--live compares with the directory)
+  version                         print the version
+
+supported services: %s
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, usage, strings.Join(cloudwatcher.Services(), ", "))
+		return 2
+	}
+
+	switch args[0] {
+	case "watch":
+		return watchCommand(ctx, args[1:], stdout, stderr)
+	case "daemon":
+		return daemonCommand(ctx, args[1:], stdout, stderr)
+	case "snapshot":
+		return snapshotCommand(args[1:], stdout, stderr)
+	case "diff":
+		return diffCommand(args[1:], stdout, stderr)
+	case "version":
+		fmt.Fprintln(stdout, cloudwatcher.Version)
+		return 0
+	case "help", "-h", "--help":
+		fmt.Fprintf(stdout, usage, strings.Join(cloudwatcher.Services(), ", "))
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command '%s'\n", args[0])
+		fmt.Fprintf(stderr, usage, strings.Join(cloudwatcher.Services(), ", "))
+		return 2
+	}
+}
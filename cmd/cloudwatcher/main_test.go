@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+func TestRun_Usage(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	if code := run(context.Background(), nil, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"wrong"}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"watch", "local"}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"watch", "unknown", "/"}, stdout, stderr); code != 1 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"watch", "local", "/", "--set", "novalue"}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"watch", "local", "/", "--format", "xml"}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+	if code := run(context.Background(), []string{"watch", "local", "/", "--set", "bucket_name=test"}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+}
+
+func TestRun_Watch(t *testing.T) {
+	dir := t.TempDir()
+	r, w := io.Pipe()
+	stderr := &bytes.Buffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	code := make(chan int)
+	go func() {
+		code <- run(ctx, []string{"watch", "local", dir, "--interval", "50ms", "--set", "disable_fsnotify=true"}, w, stderr)
+		w.Close()
+	}()
+
+	// waiting for the first sync
+	time.Sleep(200 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("test"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+
+	select {
+	case line := <-lines:
+		e := struct {
+			Key  string `json:"key"`
+			Type string `json:"type"`
+		}{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Errorf("wrong line: %s", line)
+		} else if !strings.HasSuffix(e.Key, "file.txt") || e.Type != "FileCreated" {
+			t.Errorf("wrong event: %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("event not received")
+	}
+
+	cancel()
+	go func() {
+		for range lines {
+		}
+	}()
+	if c := <-code; c != 0 {
+		t.Errorf("wrong exit code: %d (%s)", c, stderr.String())
+	}
+}
+
+func TestRun_SnapshotDiff(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	os.Mkdir(watched, 0755)
+	os.WriteFile(filepath.Join(watched, "old.txt"), []byte("test"), 0644)
+	snap := filepath.Join(dir, "old.snap")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if code := run(context.Background(), []string{"snapshot", "local", watched, "--output", snap}, stdout, stderr); code != 0 {
+		t.Fatalf("wrong exit code: %d (%s)", code, stderr.String())
+	}
+
+	os.Remove(filepath.Join(watched, "old.txt"))
+	os.WriteFile(filepath.Join(watched, "new.txt"), []byte("test"), 0644)
+
+	if code := run(context.Background(), []string{"diff", snap, "--live"}, stdout, stderr); code != 0 {
+		t.Fatalf("wrong exit code: %d (%s)", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"FileCreated"`) || !strings.Contains(lines[1], `"FileDeleted"`) {
+		t.Errorf("wrong diff: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run(context.Background(), []string{"diff", snap, "--live", "--format", "cloudevents"}, stdout, stderr); code != 0 {
+		t.Fatalf("wrong exit code: %d (%s)", code, stderr.String())
+	}
+	lines = strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"type":"io.cloudwatcher.file.created"`) ||
+		!strings.Contains(lines[0], `"source":"local://`+filepath.ToSlash(watched)+`"`) {
+		t.Errorf("wrong diff: %s", stdout.String())
+	}
+
+	if code := run(context.Background(), []string{"diff", snap}, stdout, stderr); code != 2 {
+		t.Errorf("wrong exit code: %d", code)
+	}
+}
+
+func TestStreamEvents_Fatal(t *testing.T) {
+	encode, _ := newEventEncoder("json", "")
+	tests := map[string]struct {
+		errors []error
+		code   int
+	}{
+		"not found":           {[]error{&cloudwatcher.NotFoundError{Resource: "bucket 'test'"}}, 1},
+		"credentials":         {[]error{credentialError, credentialError, credentialError}, 1},
+		"credentials retried": {[]error{credentialError, credentialError}, 0},
+		"other errors":        {[]error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}, 0},
+	}
+
+	for name, test := range tests {
+		src := &testSource{events: make(chan cloudwatcher.Event), errors: make(chan error, len(test.errors))}
+		for _, err := range test.errors {
+			src.errors <- err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if code := streamEvents(ctx, src, encode, io.Discard, io.Discard); code != test.code {
+			t.Errorf("%s: wrong exit code %d", name, code)
+		}
+		cancel()
+	}
+}
+
+var credentialError = &cloudwatcher.CredentialError{Err: errors.New("access denied")}
+
+type testSource struct {
+	events chan cloudwatcher.Event
+	errors chan error
+}
+
+func (s *testSource) GetEvents() chan cloudwatcher.Event {
+	return s.events
+}
+
+func (s *testSource) GetErrors() chan error {
+	return s.errors
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+// lineSink writes the events as JSON lines
+type lineSink struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	encode eventEncoder
+}
+
+func (s *lineSink) Send(ctx context.Context, events []cloudwatcher.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		j, err := s.encode(e)
+		if err != nil {
+			return fmt.Errorf("encoding event '%s': %s", e.Key, err)
+		}
+		if _, err := s.w.Write(append(j, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *lineSink) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+// newSink creates the sink defined by c, the errors generated asynchronously by the sink are sent to onError
+func newSink(c *sinkConfig, stdout io.Writer, stdoutMu *sync.Mutex, onError func(error)) (cloudwatcher.Sink, error) {
+	switch c.Type {
+	case "stdout":
+		encode, err := newEventEncoder(c.Format, c.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &lineSink{mu: stdoutMu, w: stdout, encode: encode}, nil
+
+	case "file":
+		encode, err := newEventEncoder(c.Format, c.Source)
+		if err != nil {
+			return nil, err
+		}
+		fd, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &lineSink{mu: &sync.Mutex{}, w: fd, closer: fd, encode: encode}, nil
+
+	case "webhook":
+		format := cloudwatcher.WebhookJSON
+		switch c.Format {
+		case "cloudevents":
+			format = cloudwatcher.WebhookCloudEvents
+		case "cloudevents-binary":
+			format = cloudwatcher.WebhookCloudEventsBinary
+		}
+		return cloudwatcher.NewWebhookSink(cloudwatcher.WebhookConfig{
+			URLs:           c.URLs,
+			Secret:         c.Secret,
+			Headers:        c.Headers,
+			Timeout:        c.timeout,
+			MaxRetries:     c.MaxRetries,
+			DeadLetterFile: c.DeadLetterFile,
+			Format:         format,
+			Source:         c.Source,
+		})
+
+	case "exec":
+		overlap := cloudwatcher.QueueOverlap
+		if c.Overlap == "drop" {
+			overlap = cloudwatcher.DropOverlap
+		}
+		s, err := cloudwatcher.NewExecSink(cloudwatcher.ExecConfig{
+			Command:     c.Command,
+			Concurrency: c.Concurrency,
+			Timeout:     c.timeout,
+			Overlap:     overlap,
+			Dir:         c.WorkDir,
+			Env:         c.Env,
+		})
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			for err := range s.GetErrors() {
+				onError(err)
+			}
+		}()
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", c.Type)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+func snapshotCommand(args []string, stdout, stderr io.Writer) int {
+	config := setFlag{}
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "", "file where the snapshot is written (default stdout)")
+	fs.Var(config, "set", "configuration of the service as key=value (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: cloudwatcher snapshot <service> <dir> [--output file] [--set key=value ...]\n")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	s, err := cloudwatcher.TakeSnapshot(positional[0], positional[1], config)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	j, err := json.Marshal(s)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: encoding snapshot: %s\n", err)
+		return 1
+	}
+	j = append(j, '\n')
+
+	if *output == "" {
+		_, err = stdout.Write(j)
+	} else {
+		err = os.WriteFile(*output, j, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: writing snapshot: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func diffCommand(args []string, stdout, stderr io.Writer) int {
+	config := setFlag{}
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	live := fs.Bool("live", false, "compare the snapshot with the current content of the directory")
+	format := fs.String("format", "json", "output format: json or cloudevents")
+	fs.Var(config, "set", "configuration of the service as key=value, used with --live (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: cloudwatcher diff <old.snap> <new.snap> [--format json]\n       cloudwatcher diff <old.snap> --live [--format json] [--set key=value ...]\n")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if (*live && len(positional) != 1) || (!*live && len(positional) != 2) {
+		fs.Usage()
+		return 2
+	}
+
+	old, err := readSnapshot(positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+
+	var current *cloudwatcher.Snapshot
+	if *live {
+		current, err = cloudwatcher.TakeSnapshot(old.Service, old.Dir, config)
+	} else {
+		current, err = readSnapshot(positional[1])
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+
+	encode, err := newEventEncoder(*format, current.Source)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 2
+	}
+	events, err := cloudwatcher.Diff(old, current)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	for _, e := range events {
+		j, err := encode(e)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: encoding event '%s': %s\n", e.Key, err)
+			return 1
+		}
+		if _, err := stdout.Write(append(j, '\n')); err != nil {
+			fmt.Fprintf(stderr, "error: writing event: %s\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func readSnapshot(path string) (*cloudwatcher.Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot: %s", err)
+	}
+	s := &cloudwatcher.Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("decoding snapshot '%s': %s", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+)
+
+// setFlag collects the repeated "--set key=value" flags
+type setFlag map[string]string
+
+func (s setFlag) String() string {
+	pairs := make([]string, 0, len(s))
+	for k, v := range s {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (s setFlag) Set(value string) error {
+	k, v, found := strings.Cut(value, "=")
+	if !found || k == "" {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+	s[k] = v
+	return nil
+}
+
+// parseInterspersed parses the flags also when they follow the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func watchCommand(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	config := setFlag{}
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	interval := fs.Duration("interval", 30*time.Second, "polling interval")
+	format := fs.String("format", "json", "output format: json or cloudevents")
+	fs.Var(config, "set", "configuration of the service as key=value (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: cloudwatcher watch <service> <dir> [--interval 30s] [--format json] [--set key=value ...]\n")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	w, err := cloudwatcher.New(positional[0], positional[1], *interval)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	if err := w.Capabilities().CheckConfig(config); err != nil {
+		fmt.Fprintf(stderr, "error: configuring %s: %s\n", positional[0], err)
+		return 2
+	}
+	if err := w.SetConfig(config); err != nil {
+		fmt.Fprintf(stderr, "error: configuring %s: %s\n", positional[0], err)
+		return 1
+	}
+	encode, err := newEventEncoder(*format, cloudwatcher.CloudEventSource(w))
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 2
+	}
+	if err := w.Start(); err != nil {
+		fmt.Fprintf(stderr, "error: starting %s: %s\n", positional[0], err)
+		return 1
+	}
+	defer w.Close()
+
+	return streamEvents(ctx, w, encode, stdout, stderr)
+}
+
+// eventEncoder encodes an event written as a line
+type eventEncoder func(e cloudwatcher.Event) ([]byte, error)
+
+// newEventEncoder returns the encoder of the format: json or cloudevents (structured mode with the given source)
+func newEventEncoder(format string, source string) (eventEncoder, error) {
+	switch format {
+	case "", "json":
+		return cloudwatcher.MarshalEvent, nil
+	case "cloudevents":
+		return func(e cloudwatcher.Event) ([]byte, error) {
+			return json.Marshal(cloudwatcher.NewCloudEvent(e, source))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+}
+
+// maxCredentialErrors is the number of credential errors in a row making the watch command fail
+const maxCredentialErrors = 3
+
+// streamEvents writes the encoded events as lines on stdout and the errors on stderr until ctx is cancelled. It
+// returns 1 on the fatal errors of the watcher: a missing resource or credentials rejected maxCredentialErrors times
+// in a row.
+func streamEvents(ctx context.Context, src cloudwatcher.EventSource, encode eventEncoder, stdout, stderr io.Writer) int {
+	events := src.GetEvents()
+	errs := src.GetErrors()
+	credentialErrors := 0
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return 0
+			}
+			credentialErrors = 0
+			j, err := encode(e)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: encoding event '%s': %s\n", e.Key, err)
+				continue
+			}
+			if _, err := stdout.Write(append(j, '\n')); err != nil {
+				// the reader of the pipe is gone
+				fmt.Fprintf(stderr, "error: writing event: %s\n", err)
+				return 1
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			fmt.Fprintf(stderr, "error: %s\n", err)
+
+			var notFound *cloudwatcher.NotFoundError
+			var credential *cloudwatcher.CredentialError
+			switch {
+			case errors.As(err, &notFound):
+				return 1
+			case errors.As(err, &credential):
+				credentialErrors++
+				if credentialErrors >= maxCredentialErrors {
+					fmt.Fprintf(stderr, "error: the credentials have been rejected %d times\n", credentialErrors)
+					return 1
+				}
+			}
+
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
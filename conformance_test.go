@@ -0,0 +1,532 @@
+package cloudwatcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/Matrix86/cloudwatcher/cloudwatchertest"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// modified is the LastModified of the objects created by the stand-ins, every change moves it forward
+var modified = time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func newWatcher(t *testing.T, service string, dir string, clock cloudwatcher.Clock, config map[string]string) cloudwatcher.Watcher {
+	t.Helper()
+	w, err := cloudwatcher.New(service, dir, cloudwatchertest.ConformanceInterval, cloudwatcher.WithClock(clock))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.SetConfig(config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return w
+}
+
+// memoryDriver keeps a copy of the objects to fill the new watchers, the storage of a MemoryWatcher is not shared
+type memoryDriver struct {
+	mu      sync.Mutex
+	w       *cloudwatcher.MemoryWatcher
+	objects map[string]*cloudwatcher.MemoryObject
+}
+
+func (d *memoryDriver) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.w = newWatcher(t, "memory", "conformance", clock, map[string]string{}).(*cloudwatcher.MemoryWatcher)
+	for k, o := range d.objects {
+		d.w.Put(k, o)
+	}
+	return d.w
+}
+
+func (d *memoryDriver) update(t *testing.T, name string, f func(o *cloudwatcher.MemoryObject)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o, ok := d.objects[name]
+	if !ok {
+		o = &cloudwatcher.MemoryObject{Size: 1, Hash: "1", LastModified: modified}
+		d.objects[name] = o
+	}
+	f(o)
+	d.w.Put(name, o)
+}
+
+func (d *memoryDriver) Create(t *testing.T, name string) {
+	d.update(t, name, func(o *cloudwatcher.MemoryObject) {})
+}
+
+func (d *memoryDriver) Change(t *testing.T, name string) {
+	d.update(t, name, func(o *cloudwatcher.MemoryObject) {
+		o.Size++
+		o.Hash = fmt.Sprintf("%d", o.Size)
+	})
+}
+
+func (d *memoryDriver) Delete(t *testing.T, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.objects, name)
+	if err := d.w.Delete(name); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func (d *memoryDriver) SetTags(t *testing.T, name string) bool {
+	d.update(t, name, func(o *cloudwatcher.MemoryObject) {
+		o.Tags = map[string]string{"team": "conformance"}
+	})
+	return true
+}
+
+func (d *memoryDriver) Key(name string) string {
+	return name
+}
+
+func TestMemoryWatcher_Conformance(t *testing.T) {
+	d := &memoryDriver{objects: make(map[string]*cloudwatcher.MemoryObject)}
+	cloudwatchertest.RunConformance(t, d.factory, d)
+}
+
+// localDriver changes the files of a temp dir, the file modes are the tags
+type localDriver struct {
+	dir string
+}
+
+func (d *localDriver) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	return newWatcher(t, "local", d.dir, clock, map[string]string{"disable_fsnotify": "true"})
+}
+
+func (d *localDriver) Create(t *testing.T, name string) {
+	if err := os.WriteFile(d.Key(name), []byte("created"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func (d *localDriver) Change(t *testing.T, name string) {
+	if err := os.WriteFile(d.Key(name), []byte("changed content"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the resolution of the modification time can be too low to notice the write
+	if err := os.Chtimes(d.Key(name), modified, modified); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func (d *localDriver) Delete(t *testing.T, name string) {
+	if err := os.Remove(d.Key(name)); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func (d *localDriver) SetTags(t *testing.T, name string) bool {
+	if err := os.Chmod(d.Key(name), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return true
+}
+
+func (d *localDriver) Key(name string) string {
+	return filepath.Join(d.dir, name)
+}
+
+func TestLocalWatcher_Conformance(t *testing.T) {
+	d := &localDriver{dir: t.TempDir()}
+	cloudwatchertest.RunConformance(t, d.factory, d)
+}
+
+// s3StandIn is a bucket kept in memory
+type s3StandIn struct {
+	cloudwatcher.IMinio
+
+	mu      sync.Mutex
+	objects map[string]minio.ObjectInfo
+	tags    map[string]map[string]string
+}
+
+func (s *s3StandIn) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	w := newWatcher(t, "s3", "", clock, map[string]string{"bucket_name": "conformance", "endpoint": "localhost:9000"})
+	cloudwatcher.SetS3Client(w, s)
+	return w
+}
+
+func (s *s3StandIn) BucketExists(ctx context.Context, bucketName string) (bool, error) {
+	return bucketName == "conformance", nil
+}
+
+func (s *s3StandIn) GetObjectTagging(ctx context.Context, bucketName, objectName string, opts minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return tags.NewTags(s.tags[objectName], true)
+}
+
+func (s *s3StandIn) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(chan minio.ObjectInfo, len(s.objects))
+	for k, o := range s.objects {
+		if strings.HasPrefix(k, opts.Prefix) {
+			out <- o
+		}
+	}
+	close(out)
+	return out
+}
+
+func (s *s3StandIn) Create(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = minio.ObjectInfo{Key: name, ETag: "\"1\"", Size: 1, LastModified: modified}
+}
+
+func (s *s3StandIn) Change(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.objects[name]
+	o.Size++
+	o.ETag = fmt.Sprintf("\"%d\"", o.Size)
+	o.LastModified = o.LastModified.Add(time.Minute)
+	s.objects[name] = o
+}
+
+func (s *s3StandIn) Delete(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	delete(s.tags, name)
+}
+
+func (s *s3StandIn) SetTags(t *testing.T, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[name] = map[string]string{"team": "conformance"}
+	return true
+}
+
+func (s *s3StandIn) Key(name string) string {
+	return name
+}
+
+func TestS3Watcher_Conformance(t *testing.T) {
+	s := &s3StandIn{objects: make(map[string]minio.ObjectInfo), tags: make(map[string]map[string]string)}
+	cloudwatchertest.RunConformance(t, s.factory, s)
+}
+
+// dropboxStandIn is a Dropbox folder kept in memory, Dropbox has no tags
+type dropboxStandIn struct {
+	mu      sync.Mutex
+	entries map[string]*files.FileMetadata
+}
+
+// dropboxClient lists the folder of the stand-in
+type dropboxClient struct {
+	files.Client
+	s *dropboxStandIn
+}
+
+func (s *dropboxStandIn) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	w := newWatcher(t, "dropbox", "/", clock, map[string]string{"token": "{\"access_token\": \"conformance\"}"})
+	cloudwatcher.SetDropboxClient(w, &dropboxClient{s: s})
+	return w
+}
+
+func (c *dropboxClient) ListFolder(arg *files.ListFolderArg) (*files.ListFolderResult, error) {
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &files.ListFolderResult{}
+	for _, e := range s.entries {
+		res.Entries = append(res.Entries, e)
+	}
+	return res, nil
+}
+
+func (s *dropboxStandIn) Create(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := files.NewFileMetadata(name, "id:"+name, modified, modified, "1", 1)
+	e.PathDisplay = s.Key(name)
+	e.ContentHash = "1"
+	s.entries[name] = e
+}
+
+func (s *dropboxStandIn) Change(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := *s.entries[name]
+	e.Size++
+	e.ContentHash = fmt.Sprintf("%d", e.Size)
+	e.ServerModified = e.ServerModified.Add(time.Minute)
+	s.entries[name] = &e
+}
+
+func (s *dropboxStandIn) Delete(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, name)
+}
+
+func (s *dropboxStandIn) SetTags(t *testing.T, name string) bool {
+	return false
+}
+
+func (s *dropboxStandIn) Key(name string) string {
+	return "/" + name
+}
+
+func TestDropboxWatcher_Conformance(t *testing.T) {
+	s := &dropboxStandIn{entries: make(map[string]*files.FileMetadata)}
+	cloudwatchertest.RunConformance(t, s.factory, s)
+}
+
+// gdriveStandIn serves the files.list method of the Drive API, Google Drive has no tags
+type gdriveStandIn struct {
+	server *httptest.Server
+
+	mu    sync.Mutex
+	files map[string]*drive.File
+}
+
+func newGDriveStandIn(t *testing.T) *gdriveStandIn {
+	s := &gdriveStandIn{files: make(map[string]*drive.File)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/files") {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		list := &drive.FileList{}
+		for _, f := range s.files {
+			list.Files = append(list.Files, f)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *gdriveStandIn) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	w := newWatcher(t, "gdrive", "", clock, map[string]string{"token": "{\"access_token\": \"conformance\"}"})
+	client, err := drive.NewService(context.Background(), option.WithEndpoint(s.server.URL+"/"), option.WithHTTPClient(s.server.Client()))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cloudwatcher.SetGDriveClient(w, client)
+	return w
+}
+
+func (s *gdriveStandIn) Create(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = &drive.File{
+		Id:           "id-" + name,
+		Name:         name,
+		MimeType:     "text/plain",
+		Parents:      []string{"root"},
+		ModifiedTime: modified.Format(time.RFC3339),
+		Size:         1,
+		Md5Checksum:  "1",
+	}
+}
+
+func (s *gdriveStandIn) Change(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := *s.files[name]
+	mt, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+	f.Size++
+	f.Md5Checksum = fmt.Sprintf("%d", f.Size)
+	f.ModifiedTime = mt.Add(time.Minute).Format(time.RFC3339)
+	s.files[name] = &f
+}
+
+func (s *gdriveStandIn) Delete(t *testing.T, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, name)
+}
+
+func (s *gdriveStandIn) SetTags(t *testing.T, name string) bool {
+	return false
+}
+
+func (s *gdriveStandIn) Key(name string) string {
+	return name
+}
+
+func TestGDriveWatcher_Conformance(t *testing.T) {
+	s := newGDriveStandIn(t)
+	cloudwatchertest.RunConformance(t, s.factory, s)
+}
+
+// gitDriver commits the changes to a local repository cloned by the watchers, the file modes are the tags
+type gitDriver struct {
+	dir  string
+	repo *git.Repository
+}
+
+var installGitServer sync.Once
+
+func newGitDriver(t *testing.T) *gitDriver {
+	// the local repositories are served by go-git instead of the git binary
+	installGitServer.Do(func() {
+		client.InstallProtocol("file", server.DefaultServer)
+	})
+
+	d := &gitDriver{dir: t.TempDir()}
+	repo, err := git.PlainInit(d.dir, false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.repo = repo
+	// an empty repository can't be cloned
+	if err := os.WriteFile(filepath.Join(d.dir, "README"), []byte("conformance"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.commit(t, "README", false)
+	return d
+}
+
+func (d *gitDriver) factory(t *testing.T, clock cloudwatcher.Clock) cloudwatcher.Watcher {
+	return newWatcher(t, "git", "", clock, map[string]string{
+		"monitor_type": "file",
+		"repo_url":     filepath.Join(d.dir, ".git"),
+		"repo_branch":  "master",
+		"temp_dir":     t.TempDir(),
+	})
+}
+
+func (d *gitDriver) commit(t *testing.T, name string, remove bool) {
+	wt, err := d.repo.Worktree()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if remove {
+		_, err = wt.Remove(name)
+	} else {
+		_, err = wt.Add(name)
+	}
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = wt.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "conformance", Email: "conformance@example.com", When: modified},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func (d *gitDriver) Create(t *testing.T, name string) {
+	if err := os.WriteFile(filepath.Join(d.dir, name), []byte("created"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.commit(t, name, false)
+}
+
+func (d *gitDriver) Change(t *testing.T, name string) {
+	if err := os.WriteFile(filepath.Join(d.dir, name), []byte("changed"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.commit(t, name, false)
+}
+
+func (d *gitDriver) Delete(t *testing.T, name string) {
+	d.commit(t, name, true)
+}
+
+func (d *gitDriver) SetTags(t *testing.T, name string) bool {
+	if err := os.Chmod(filepath.Join(d.dir, name), 0755); err != nil {
+		t.Fatalf("%s", err)
+	}
+	d.commit(t, name, false)
+	return true
+}
+
+func (d *gitDriver) Key(name string) string {
+	return name
+}
+
+func TestGitWatcher_Conformance(t *testing.T) {
+	d := newGitDriver(t)
+	cloudwatchertest.RunConformance(t, d.factory, d)
+}
+
+func TestGitWatcher_TempDir(t *testing.T) {
+	d := newGitDriver(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	clones := func() int {
+		entries, _ := os.ReadDir(tmp)
+		return len(entries)
+	}
+
+	config := map[string]string{
+		"monitor_type": "file",
+		"repo_url":     filepath.Join(d.dir, ".git"),
+		"repo_branch":  "master",
+	}
+	w := newWatcher(t, "git", "", cloudwatchertest.NewFakeClock(modified), config)
+	if clones() != 0 {
+		t.Errorf("the clone is created by the first synchronization")
+	}
+
+	// the clone is kept by the reconfiguration of the same repository
+	for i := 0; i < 2; i++ {
+		if _, err := cloudwatcher.NewSnapshot(w); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if err := cloudwatcher.Reconfigure(context.Background(), w, config); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if n := clones(); n != 1 {
+		t.Errorf("wrong number of clones: %d", n)
+	}
+
+	w.Close()
+	if n := clones(); n != 0 {
+		t.Errorf("the clone has not been removed: %d", n)
+	}
+}
+
+func TestTakeSnapshot_Git(t *testing.T) {
+	d := newGitDriver(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	s, err := cloudwatcher.TakeSnapshot("git", "", map[string]string{
+		"monitor_type": "file",
+		"repo_url":     filepath.Join(d.dir, ".git"),
+		"repo_branch":  "master",
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("wrong number of objects: %d", s.Len())
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("the clone has not been removed: %v", entries)
+	}
+}
@@ -0,0 +1,580 @@
+package cloudwatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AckConfig contains the options of the at-least-once delivery mode
+type AckConfig struct {
+	VisibilityTimeout time.Duration  // time after which an unacknowledged event is delivered again (default 30s)
+	MaxAttempts       int            // deliveries before moving the event to the dead-letter sink (default 5)
+	Backoff           Backoff        // delay applied to the events rejected with Nack
+	StateFile         string         // if set, the delivery state is stored on this file and restored by Start
+	PersistInterval   time.Duration  // if set, minimum time between two writes of the StateFile instead of writing each change
+	DeadLetter        DeadLetterSink // receives the events failed MaxAttempts times (they are dropped if nil)
+}
+
+// DeadLetterSink receives the events that cannot be delivered
+type DeadLetterSink interface {
+	DeadLetter(e Event, attempts int) error
+}
+
+// maxErrorBacklog is the number of errors kept by the AckQueue while the errors chan is full
+const maxErrorBacklog = 1000
+
+// AckQueue wraps a Watcher and delivers each event until it is acknowledged
+type AckQueue struct {
+	watcher    Watcher
+	config     AckConfig
+	deliveries chan *Delivery
+	errors     chan error
+	stop       chan bool
+	wake       chan bool
+	dirtyWake  chan bool
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+
+	droppedErrors uint64
+
+	// errors waiting for the errors chan, see sendError
+	errMu      sync.Mutex
+	errBacklog []error
+	errWake    chan bool
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]*pendingEvent
+	dirty   bool
+
+	// writeMu serializes the writes of the StateFile
+	writeMu sync.Mutex
+}
+
+// Delivery is an event delivered by the AckQueue: it has to be confirmed with Ack or rejected with Nack
+type Delivery struct {
+	Event
+
+	ID      uint64 // identifier of the event inside the queue
+	Attempt int    // number of the delivery, starting from 1
+
+	queue *AckQueue
+}
+
+type pendingEvent struct {
+	ID       uint64    `json:"id"`
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	ReadyAt  time.Time `json:"ready_at"`
+	InFlight bool      `json:"in_flight"`
+}
+
+type ackState struct {
+	NextID  uint64          `json:"next_id"`
+	Pending []*pendingEvent `json:"pending"`
+}
+
+// NewAckQueue creates the at-least-once delivery mode for the watcher w
+func NewAckQueue(w Watcher, c AckConfig) (*AckQueue, error) {
+	if w == nil {
+		return nil, fmt.Errorf("watcher needed")
+	}
+	if c.VisibilityTimeout <= 0 {
+		c.VisibilityTimeout = 30 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+
+	return &AckQueue{
+		watcher:    w,
+		config:     c,
+		deliveries: make(chan *Delivery, 100),
+		errors:     make(chan error, 100),
+		stop:       make(chan bool),
+		wake:       make(chan bool, 1),
+		dirtyWake:  make(chan bool, 1),
+		errWake:    make(chan bool, 1),
+		nextID:     1,
+		pending:    make(map[uint64]*pendingEvent),
+	}, nil
+}
+
+// Start restores the persisted state and starts the wrapped watcher
+func (q *AckQueue) Start() error {
+	if err := q.load(); err != nil {
+		return err
+	}
+
+	if err := q.watcher.Start(); err != nil {
+		return err
+	}
+
+	q.wg.Add(3)
+	go q.ingest()
+	go q.dispatch()
+	go q.reportErrors()
+	if q.config.StateFile != "" && q.config.PersistInterval > 0 {
+		q.wg.Add(1)
+		go q.persister()
+	}
+	return nil
+}
+
+// Close stops the wrapped watcher and the delivery of the events.
+// The pending events are kept in the state file and delivered again by the next Start.
+func (q *AckQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.stop)
+		q.watcher.Close()
+		q.wg.Wait()
+		if err := q.flush(); err != nil {
+			q.sendError(err)
+		}
+		q.drainErrors()
+		close(q.deliveries)
+		close(q.errors)
+	})
+}
+
+// Deliveries returns a chan of Delivery
+func (q *AckQueue) Deliveries() chan *Delivery {
+	return q.deliveries
+}
+
+// GetErrors returns a chan of error
+func (q *AckQueue) GetErrors() chan error {
+	return q.errors
+}
+
+// DroppedErrors returns the number of errors discarded because they were not read from the errors chan in time
+func (q *AckQueue) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&q.droppedErrors)
+}
+
+// Pending returns the number of events not yet acknowledged
+func (q *AckQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Ack confirms the processing of the event, it will not be delivered anymore. An error is returned if the event has
+// been delivered again in the meantime (the visibility timeout expired), in which case the new delivery has to be
+// confirmed, or if the state can't be written on the StateFile.
+func (d *Delivery) Ack() error {
+	q := d.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.current(d); err != nil {
+		return err
+	}
+	delete(q.pending, d.ID)
+	return q.persist()
+}
+
+// Nack rejects the event: it will be delivered again after the backoff delay
+// or moved to the dead-letter sink if it reached the max number of attempts
+func (d *Delivery) Nack() error {
+	q := d.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, err := q.current(d)
+	if err != nil {
+		return err
+	}
+
+	if p.Attempts >= q.config.MaxAttempts {
+		q.deadLetter(p)
+	} else {
+		p.InFlight = false
+		p.ReadyAt = time.Now().Add(q.config.Backoff.Duration(p.Attempts))
+	}
+	q.notify()
+	return q.persist()
+}
+
+// current returns the pending event if d is its last delivery, it has to be called with the lock held
+func (q *AckQueue) current(d *Delivery) (*pendingEvent, error) {
+	p, ok := q.pending[d.ID]
+	if !ok {
+		return nil, fmt.Errorf("delivery %d is not pending", d.ID)
+	}
+	if !p.InFlight || p.Attempts != d.Attempt {
+		return nil, fmt.Errorf("delivery %d (attempt %d) has already been redelivered", d.ID, d.Attempt)
+	}
+	return p, nil
+}
+
+func (q *AckQueue) ingest() {
+	defer q.wg.Done()
+
+	events := q.watcher.GetEvents()
+	errors := q.watcher.GetErrors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			q.mu.Lock()
+			q.pending[q.nextID] = &pendingEvent{
+				ID:      q.nextID,
+				Event:   e,
+				ReadyAt: time.Now(),
+			}
+			q.nextID++
+			if err := q.persist(); err != nil {
+				q.sendError(err)
+			}
+			q.mu.Unlock()
+			q.notify()
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			q.sendError(err)
+
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+func (q *AckQueue) dispatch() {
+	defer q.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		d, wait := q.next()
+		if d != nil {
+			select {
+			case q.deliveries <- d:
+			case <-q.stop:
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// next returns the first event ready to be delivered, or the time to wait for the next one
+func (q *AckQueue) next() (*Delivery, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		var first *pendingEvent
+		for _, p := range q.pending {
+			if first == nil || p.ReadyAt.Before(first.ReadyAt) || (p.ReadyAt.Equal(first.ReadyAt) && p.ID < first.ID) {
+				first = p
+			}
+		}
+		if first == nil {
+			return nil, time.Hour
+		}
+
+		now := time.Now()
+		if first.ReadyAt.After(now) {
+			return nil, first.ReadyAt.Sub(now)
+		}
+
+		// the visibility timeout expired on the last attempt
+		if first.InFlight && first.Attempts >= q.config.MaxAttempts {
+			q.deadLetter(first)
+			if err := q.persist(); err != nil {
+				q.sendError(err)
+			}
+			continue
+		}
+
+		first.Attempts++
+		first.InFlight = true
+		first.ReadyAt = now.Add(q.config.VisibilityTimeout)
+		if err := q.persist(); err != nil {
+			q.sendError(err)
+		}
+		return &Delivery{
+			Event:   first.Event,
+			ID:      first.ID,
+			Attempt: first.Attempts,
+			queue:   q,
+		}, 0
+	}
+}
+
+// deadLetter removes the event from the queue, it has to be called with the lock held
+func (q *AckQueue) deadLetter(p *pendingEvent) {
+	delete(q.pending, p.ID)
+	if q.config.DeadLetter == nil {
+		q.sendError(fmt.Errorf("event '%s' dropped after %d attempts", p.Event.Key, p.Attempts))
+		return
+	}
+	if err := q.config.DeadLetter.DeadLetter(p.Event, p.Attempts); err != nil {
+		q.sendError(fmt.Errorf("dead-letter of event '%s': %s", p.Event.Key, err))
+	}
+}
+
+func (q *AckQueue) notify() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+// sendError doesn't block as it can be called with the lock held: the errors are queued and sent by reportErrors.
+// If maxErrorBacklog errors are already waiting the error is dropped, and the number of the dropped errors is
+// reported as soon as the errors are read again.
+func (q *AckQueue) sendError(err error) {
+	q.errMu.Lock()
+	if len(q.errBacklog) < maxErrorBacklog {
+		q.errBacklog = append(q.errBacklog, err)
+	} else {
+		atomic.AddUint64(&q.droppedErrors, 1)
+	}
+	q.errMu.Unlock()
+
+	select {
+	case q.errWake <- true:
+	default:
+	}
+}
+
+// nextError returns the first error to report, or nil if there are none
+func (q *AckQueue) nextError(reported *uint64) error {
+	if dropped := atomic.LoadUint64(&q.droppedErrors); dropped > *reported {
+		err := fmt.Errorf("%d errors dropped because they were not read in time", dropped-*reported)
+		*reported = dropped
+		return err
+	}
+	q.errMu.Lock()
+	defer q.errMu.Unlock()
+	if len(q.errBacklog) == 0 {
+		return nil
+	}
+	err := q.errBacklog[0]
+	q.errBacklog = q.errBacklog[1:]
+	return err
+}
+
+func (q *AckQueue) reportErrors() {
+	defer q.wg.Done()
+
+	var reported uint64
+	for {
+		err := q.nextError(&reported)
+		if err == nil {
+			select {
+			case <-q.errWake:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+		select {
+		case q.errors <- err:
+		case <-q.stop:
+			// sent by drainErrors
+			q.errMu.Lock()
+			q.errBacklog = append([]error{err}, q.errBacklog...)
+			q.errMu.Unlock()
+			return
+		}
+	}
+}
+
+// drainErrors sends the queued errors that fit in the errors chan before closing it, the other ones are dropped
+func (q *AckQueue) drainErrors() {
+	q.errMu.Lock()
+	defer q.errMu.Unlock()
+	for i, err := range q.errBacklog {
+		select {
+		case q.errors <- err:
+		default:
+			atomic.AddUint64(&q.droppedErrors, uint64(len(q.errBacklog)-i))
+			q.errBacklog = nil
+			return
+		}
+	}
+	q.errBacklog = nil
+}
+
+// persist saves the changed state, it has to be called with the lock held. The StateFile is written immediately,
+// or by the persister at most once per PersistInterval (and by Close) if it is set.
+func (q *AckQueue) persist() error {
+	if q.config.StateFile == "" {
+		return nil
+	}
+	if q.config.PersistInterval <= 0 {
+		return q.write(q.state())
+	}
+	q.dirty = true
+	select {
+	case q.dirtyWake <- true:
+	default:
+	}
+	return nil
+}
+
+func (q *AckQueue) persister() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.dirtyWake:
+		case <-q.stop:
+			return
+		}
+		if err := q.flush(); err != nil {
+			q.sendError(err)
+		}
+		select {
+		case <-time.After(q.config.PersistInterval):
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// flush writes the state on the StateFile if it has changed
+func (q *AckQueue) flush() error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	q.dirty = false
+	state := q.state()
+	q.mu.Unlock()
+	return q.write(state)
+}
+
+// state returns a copy of the delivery state, it has to be called with the lock held
+func (q *AckQueue) state() ackState {
+	state := ackState{
+		NextID:  q.nextID,
+		Pending: make([]*pendingEvent, 0, len(q.pending)),
+	}
+	for _, p := range q.pending {
+		c := *p
+		state.Pending = append(state.Pending, &c)
+	}
+	return state
+}
+
+// write replaces the StateFile with the state
+func (q *AckQueue) write(state ackState) error {
+	j, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding delivery state: %s", err)
+	}
+
+	tmp := q.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, j, 0600); err != nil {
+		return fmt.Errorf("writing delivery state: %s", err)
+	}
+	if err := os.Rename(tmp, q.config.StateFile); err != nil {
+		return fmt.Errorf("writing delivery state: %s", err)
+	}
+	return nil
+}
+
+func (q *AckQueue) load() error {
+	if q.config.StateFile == "" {
+		return nil
+	}
+
+	j, err := os.ReadFile(q.config.StateFile)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(filepath.Dir(q.config.StateFile), 0700)
+	} else if err != nil {
+		return fmt.Errorf("reading delivery state: %s", err)
+	}
+
+	state := ackState{}
+	if err := json.Unmarshal(j, &state); err != nil {
+		return fmt.Errorf("decoding delivery state: %s", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, p := range state.Pending {
+		// the consumer which was processing the event is gone
+		if p.InFlight {
+			p.InFlight = false
+			p.ReadyAt = now
+		}
+		q.pending[p.ID] = p
+	}
+	if state.NextID > q.nextID {
+		q.nextID = state.NextID
+	}
+	return nil
+}
+
+// FileDeadLetter is a DeadLetterSink that appends the events to a file, one JSON per line
+type FileDeadLetter struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileDeadLetter creates a DeadLetterSink writing on the file path
+func NewFileDeadLetter(path string) *FileDeadLetter {
+	return &FileDeadLetter{path: path}
+}
+
+// DeadLetter appends the event to the file
+func (f *FileDeadLetter) DeadLetter(e Event, attempts int) error {
+	return f.deadLetterURL(e, attempts, "")
+}
+
+// deadLetterURL appends the event that could not be sent to url, the WebhookSink has an entry for each failed url
+func (f *FileDeadLetter) deadLetterURL(e Event, attempts int, url string) error {
+	j, err := json.Marshal(struct {
+		Event    Event     `json:"event"`
+		URL      string    `json:"url,omitempty"`
+		Attempts int       `json:"attempts"`
+		Time     time.Time `json:"time"`
+	}{e, url, attempts, time.Now()})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fd, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = fd.Write(append(j, '\n'))
+	return err
+}
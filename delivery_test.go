@@ -0,0 +1,238 @@
+package cloudwatcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chanWatcher is a Watcher whose events are pushed directly by the tests
+type chanWatcher struct {
+	WatcherBase
+}
+
+func newChanWatcher() *chanWatcher {
+	return &chanWatcher{
+		WatcherBase: WatcherBase{
+			Events: make(chan Event, 100),
+			Errors: make(chan error, 100),
+		},
+	}
+}
+
+func (w *chanWatcher) Start() error                        { return nil }
+func (w *chanWatcher) SetConfig(c map[string]string) error { return nil }
+func (w *chanWatcher) Close()                              {}
+
+func receiveDelivery(t *testing.T, q *AckQueue) *Delivery {
+	t.Helper()
+	select {
+	case d := <-q.Deliveries():
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatalf("delivery not received")
+	}
+	return nil
+}
+
+func TestAckQueue_AckNack(t *testing.T) {
+	w := newChanWatcher()
+	q, err := NewAckQueue(w, AckConfig{
+		VisibilityTimeout: time.Minute,
+		MaxAttempts:       3,
+		Backoff:           Backoff{Initial: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+
+	w.Events <- Event{Key: "file.txt", Type: FileCreated}
+
+	d := receiveDelivery(t, q)
+	if d.Key != "file.txt" || d.Attempt != 1 {
+		t.Errorf("wrong delivery received: %s attempt %d", d.Key, d.Attempt)
+	}
+	if err := d.Nack(); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	d = receiveDelivery(t, q)
+	if d.Key != "file.txt" || d.Attempt != 2 {
+		t.Errorf("wrong delivery received: %s attempt %d", d.Key, d.Attempt)
+	}
+	if err := d.Ack(); err != nil {
+		t.Errorf("%s", err)
+	}
+	if err := d.Ack(); err == nil {
+		t.Errorf("a second Ack should return an error")
+	}
+	if q.Pending() != 0 {
+		t.Errorf("no event should be pending")
+	}
+}
+
+func TestAckQueue_VisibilityTimeout(t *testing.T) {
+	w := newChanWatcher()
+	q, _ := NewAckQueue(w, AckConfig{
+		VisibilityTimeout: 20 * time.Millisecond,
+	})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+
+	w.Events <- Event{Key: "file.txt", Type: FileChanged}
+
+	first := receiveDelivery(t, q)
+	second := receiveDelivery(t, q)
+	if second.ID != first.ID || second.Attempt != 2 {
+		t.Errorf("the event should be redelivered after the visibility timeout")
+	}
+	if err := first.Nack(); err == nil {
+		t.Errorf("Nack of a redelivered event should return an error")
+	}
+	// the late Ack of the first delivery doesn't confirm the second one
+	if err := first.Ack(); err == nil {
+		t.Errorf("Ack of a redelivered event should return an error")
+	}
+	if q.Pending() != 1 {
+		t.Errorf("the redelivered event should be pending")
+	}
+	if err := second.Ack(); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestAckQueue_DeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	dlq := filepath.Join(dir, "dead.jsonl")
+
+	w := newChanWatcher()
+	q, _ := NewAckQueue(w, AckConfig{
+		MaxAttempts: 2,
+		Backoff:     Backoff{Initial: time.Millisecond},
+		DeadLetter:  NewFileDeadLetter(dlq),
+	})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+
+	w.Events <- Event{Key: "file.txt", Type: FileDeleted}
+	for i := 0; i < 2; i++ {
+		if err := receiveDelivery(t, q).Nack(); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+
+	if q.Pending() != 0 {
+		t.Errorf("the event should be removed from the queue")
+	}
+
+	fd, err := os.Open(dlq)
+	if err != nil {
+		t.Fatalf("dead-letter file not created: %s", err)
+	}
+	defer fd.Close()
+	lines := 0
+	for s := bufio.NewScanner(fd); s.Scan(); {
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("dead-letter file should contain 1 event, found %d", lines)
+	}
+}
+
+func TestAckQueue_Persistence(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	w := newChanWatcher()
+	q, _ := NewAckQueue(w, AckConfig{StateFile: state})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	w.Events <- Event{Key: "a.txt", Type: FileCreated}
+	w.Events <- Event{Key: "b.txt", Type: FileCreated}
+
+	if err := receiveDelivery(t, q).Ack(); err != nil {
+		t.Errorf("%s", err)
+	}
+	// the second event is received but the consumer crashes before the Ack
+	receiveDelivery(t, q)
+	q.Close()
+
+	q, _ = NewAckQueue(newChanWatcher(), AckConfig{StateFile: state})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+
+	d := receiveDelivery(t, q)
+	if d.Key != "b.txt" || d.Attempt != 2 {
+		t.Errorf("wrong delivery restored: %s attempt %d", d.Key, d.Attempt)
+	}
+	if q.Pending() != 1 {
+		t.Errorf("only one event should be pending, found %d", q.Pending())
+	}
+}
+
+func TestAckQueue_PersistSync(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	w := newChanWatcher()
+	q, _ := NewAckQueue(w, AckConfig{StateFile: state})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+	w.Events <- Event{Key: "a.txt", Type: FileCreated}
+	w.Events <- Event{Key: "b.txt", Type: FileCreated}
+	if err := receiveDelivery(t, q).Ack(); err != nil {
+		t.Errorf("%s", err)
+	}
+	receiveDelivery(t, q)
+
+	// the state is written by Ack, without waiting for Close
+	restored, _ := NewAckQueue(newChanWatcher(), AckConfig{StateFile: state})
+	if err := restored.load(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p, ok := restored.pending[2]; len(restored.pending) != 1 || !ok || p.Event.Key != "b.txt" || p.Attempts != 1 {
+		t.Errorf("wrong state written: %v", restored.pending)
+	}
+}
+
+func TestAckQueue_Errors(t *testing.T) {
+	w := newChanWatcher()
+	q, _ := NewAckQueue(w, AckConfig{})
+	if err := q.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer q.Close()
+
+	// the errors not read in time are kept instead of being dropped
+	n := cap(q.GetErrors()) + 50
+	for i := 0; i < n; i++ {
+		w.Errors <- fmt.Errorf("error %d", i)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-q.GetErrors():
+			if err.Error() != fmt.Sprintf("error %d", i) {
+				t.Errorf("wrong error received: %s", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("error %d not received", i)
+		}
+	}
+	if q.DroppedErrors() != 0 {
+		t.Errorf("no error should be dropped")
+	}
+}
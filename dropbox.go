@@ -1,13 +1,16 @@
 package cloudwatcher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"path"
 	"sync/atomic"
 	"time"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/auth"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"golang.org/x/oauth2"
 )
@@ -18,7 +21,7 @@ type DropboxWatcher struct {
 
 	syncing uint32
 
-	ticker *time.Ticker
+	ticker Ticker
 	stop   chan bool
 	config *dropboxConfiguration
 	cache  map[string]*DropboxObject
@@ -27,17 +30,21 @@ type DropboxWatcher struct {
 
 // DropboxObject is the object that contains the info of the file
 type DropboxObject struct {
-	Key          string
-	Size         int64
-	LastModified time.Time
-	Hash         string
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Hash         string    `json:"hash"`
 }
 
 type dropboxConfiguration struct {
 	Debug        Bool   `json:"debug"`
-	JToken       string `json:"token"`
+	JToken       string `json:"token" secret:"true"`
 	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
+	ClientSecret string `json:"client_secret" secret:"true"`
+
+	guardConfiguration
+	keysConfiguration
+	rateConfiguration
 
 	token *oauth2.Token
 }
@@ -49,10 +56,11 @@ func newDropboxWatcher(dir string, interval time.Duration) (Watcher, error) {
 		client: nil,
 		stop:   make(chan bool, 1),
 		WatcherBase: WatcherBase{
-			Events:      make(chan Event, 100),
-			Errors:      make(chan error, 100),
-			watchDir:    dir,
-			pollingTime: interval,
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
 		},
 	}
 
@@ -61,27 +69,110 @@ func newDropboxWatcher(dir string, interval time.Duration) (Watcher, error) {
 
 // SetConfig is used to configure the DropboxWatcher
 func (w *DropboxWatcher) SetConfig(m map[string]string) error {
-	j, err := json.Marshal(m)
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, dropboxConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseDropboxConfig(resolved)
+	if err != nil {
+		return err
+	}
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*DropboxObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+// Reconfigure validates the new configuration and applies it between two synchronizations, the cache is always
+// kept. The client is created again if the credentials change.
+func (w *DropboxWatcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, dropboxConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseDropboxConfig(resolved)
 	if err != nil {
 		return err
 	}
 
-	config := dropboxConfiguration{}
-	if err := json.Unmarshal(j, &config); err != nil {
+	if err := w.lockCycle(ctx); err != nil {
+		return err
+	}
+	defer w.unlockCycle()
+	if w.config == nil || w.config.JToken != config.JToken || w.config.Debug != config.Debug ||
+		w.config.ClientID != config.ClientID || w.config.ClientSecret != config.ClientSecret {
+		w.client = nil
+	}
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*DropboxObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+// applySecrets uses the secrets resolved again, the client is created with them by the next synchronization
+func (w *DropboxWatcher) applySecrets(m map[string]string) error {
+	config, err := parseDropboxConfig(m)
+	if err != nil {
 		return err
 	}
+	w.config = config
+	w.client = nil
+	return nil
+}
+
+// isDropboxCredentialError returns true if the request has been rejected because of the credentials
+func isDropboxCredentialError(err error) bool {
+	switch err.(type) {
+	case auth.AuthAPIError, auth.AccessAPIError:
+		return true
+	}
+	return false
+}
+
+func parseDropboxConfig(m map[string]string) (*dropboxConfiguration, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &dropboxConfiguration{}
+	if err := json.Unmarshal(j, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.validateRate(); err != nil {
+		return nil, err
+	}
 
 	if config.JToken == "" {
-		return fmt.Errorf("token not specified")
+		return nil, fmt.Errorf("token not specified")
 	}
-	w.config = &config
 
 	tok := &oauth2.Token{}
 	if err := json.Unmarshal([]byte(config.JToken), tok); err != nil {
-		return err
+		return nil, err
 	}
-	w.config.token = tok
-	return nil
+	config.token = tok
+	return config, nil
 }
 
 // Start launches the polling process
@@ -90,13 +181,19 @@ func (w *DropboxWatcher) Start() error {
 		return fmt.Errorf("configuration for Dropbox needed")
 	}
 
-	w.ticker = time.NewTicker(w.pollingTime)
+	if w.scheduler != nil {
+		return w.startScheduled(w)
+	}
+	w.ticker = w.getClock().NewTicker(w.pollingTime)
 	go func() {
 		// launch synchronization also the first time
 		w.sync(true)
 		for {
 			select {
-			case <-w.ticker.C:
+			case <-w.ticker.C():
+				w.sync(false)
+
+			case <-w.syncRequests:
 				w.sync(false)
 
 			case <-w.stop:
@@ -111,11 +208,41 @@ func (w *DropboxWatcher) Start() error {
 
 // Close stop the polling process
 func (w *DropboxWatcher) Close() {
+	w.leaveRateGroup()
+	if w.closeScheduled(nil) {
+		return
+	}
 	if w.stop != nil {
 		w.stop <- true
 	}
 }
 
+func (w *DropboxWatcher) service() string {
+	return "dropbox"
+}
+
+// schedulingGroup returns the group of the watcher in a Scheduler: the client and the token
+func (w *DropboxWatcher) schedulingGroup() string {
+	return "dropbox:" + w.config.ClientID + "/" + credentialID(w.config.JToken)
+}
+
+func (w *DropboxWatcher) source() string {
+	return "dropbox://" + path.Join("/", w.watchDir)
+}
+
+// String returns the URI of the watcher, the secrets are redacted
+func (w *DropboxWatcher) String() string {
+	return formatURI(&url.URL{Scheme: "dropbox", Path: path.Join("/", w.watchDir)}, w.pollingTime, w.config, w.rawConfig)
+}
+
+// Capabilities returns the features of Dropbox: the files have a content hash
+func (w *DropboxWatcher) Capabilities() Capabilities {
+	return Capabilities{
+		ContentHash: true,
+		ConfigKeys:  configKeys(dropboxConfiguration{}),
+	}
+}
+
 func (w *DropboxWatcher) initDropboxClient() {
 	logLevel := dropbox.LogOff
 	if w.config.Debug {
@@ -135,10 +262,22 @@ func (w *DropboxWatcher) sync(firstSync bool) {
 		return
 	}
 	defer atomic.StoreUint32(&w.syncing, 0)
+	w.syncStarted()
+	defer w.syncDone()
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	firstSync = w.checkCacheReset(firstSync)
 
 	if w.client == nil {
 		w.initDropboxClient()
 	}
+	if w.keysMode() {
+		if err := w.syncKeys(firstSync, w.config.guardConfiguration, w.lookupKey); isDropboxCredentialError(err) {
+			w.refreshSecrets(w.applySecrets)
+		}
+		return
+	}
 
 	fileList := make(map[string]*DropboxObject, 0)
 	err := w.enumerateFiles(w.watchDir, func(obj *DropboxObject) bool {
@@ -150,11 +289,10 @@ func (w *DropboxWatcher) sync(firstSync bool) {
 			cached := w.getCachedObject(obj)
 			// Object has been cached previously by Key
 			if cached != nil {
-				// Check if the LastModified has been changed
-				if !cached.LastModified.Equal(obj.LastModified) || cached.Hash != obj.Hash || cached.Size != obj.Size {
+				for _, op := range obj.changes(cached) {
 					event := Event{
 						Key:    obj.Key,
-						Type:   FileChanged,
+						Type:   op,
 						Object: obj,
 					}
 					w.Events <- event
@@ -172,18 +310,34 @@ func (w *DropboxWatcher) sync(firstSync bool) {
 		return true
 	})
 	if err != nil {
+		if isDropboxCredentialError(err) {
+			w.Errors <- &CredentialError{Err: err}
+			w.refreshSecrets(w.applySecrets)
+			return
+		}
 		w.Errors <- err
 		return
 	}
 
-	for k, o := range w.cache {
-		if _, found := fileList[k]; !found {
+	if !firstSync {
+		deleted := make([]string, 0)
+		for k := range w.cache {
+			if _, found := fileList[k]; !found {
+				deleted = append(deleted, k)
+			}
+		}
+		deleted = w.confirmMissing(w.config.guardConfiguration, deleted, w.objectExists)
+		if !w.checkDeletes(w.config.guardConfiguration, deleted, len(w.cache)) {
+			return
+		}
+		for _, k := range deleted {
 			// file not found in the list...deleting it
+			o := w.cache[k]
 			delete(w.cache, k)
 			event := Event{
 				Key:    o.Key,
 				Type:   FileDeleted,
-				Object: nil,
+				Object: o,
 			}
 			w.Events <- event
 		}
@@ -195,6 +349,9 @@ func (w *DropboxWatcher) enumerateFiles(prefix string, callback func(object *Dro
 	arg.Recursive = true
 
 	var entries []files.IsMetadata
+	if err := w.throttle(context.Background()); err != nil {
+		return err
+	}
 	res, err := w.client.ListFolder(arg)
 	if err != nil {
 		listRevisionError, ok := err.(files.ListRevisionsAPIError)
@@ -219,6 +376,9 @@ func (w *DropboxWatcher) enumerateFiles(prefix string, callback func(object *Dro
 		for res.HasMore {
 			arg := files.NewListFolderContinueArg(res.Cursor)
 
+			if err := w.throttle(context.Background()); err != nil {
+				return err
+			}
 			res, err = w.client.ListFolderContinue(arg)
 			if err != nil {
 				return err
@@ -252,6 +412,9 @@ func (w *DropboxWatcher) getFileMetadata(c files.Client, path string) (files.IsM
 
 	arg.IncludeDeleted = true
 
+	if err := w.throttle(context.Background()); err != nil {
+		return nil, err
+	}
 	res, err := c.GetMetadata(arg)
 	if err != nil {
 		return nil, err
@@ -260,6 +423,110 @@ func (w *DropboxWatcher) getFileMetadata(c files.Client, path string) (files.IsM
 	return res, nil
 }
 
+func (o *DropboxObject) cacheKey() string {
+	return o.Key
+}
+
+func (o *DropboxObject) eventKey() string {
+	return o.Key
+}
+
+func (o *DropboxObject) backend() string {
+	return "dropbox"
+}
+
+func (o *DropboxObject) size() int64 {
+	return o.Size
+}
+
+func (o *DropboxObject) modTime() time.Time {
+	return o.LastModified
+}
+
+func (o *DropboxObject) hash() string {
+	return o.Hash
+}
+
+func (o *DropboxObject) changes(cached snapshotObject) []Op {
+	c := cached.(*DropboxObject)
+	// Check if the LastModified has been changed
+	if !c.LastModified.Equal(o.LastModified) || c.Hash != o.Hash || c.Size != o.Size {
+		return []Op{FileChanged}
+	}
+	return nil
+}
+
+func (w *DropboxWatcher) list() ([]snapshotObject, error) {
+	if w.config == nil {
+		return nil, fmt.Errorf("configuration for Dropbox needed")
+	}
+
+	// waiting for the running sync
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+
+	if w.client == nil {
+		w.initDropboxClient()
+	}
+	objects := make([]snapshotObject, 0)
+	err := w.enumerateFiles(w.watchDir, func(obj *DropboxObject) bool {
+		objects = append(objects, obj)
+		return true
+	})
+	return objects, err
+}
+
+// objectExists gets the metadata of the file missing from the listing
+func (w *DropboxWatcher) objectExists(key string) (bool, error) {
+	if err := w.throttle(context.Background()); err != nil {
+		return false, err
+	}
+	res, err := w.client.GetMetadata(files.NewGetMetadataArg(key))
+	if err != nil {
+		if isDropboxNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, deleted := res.(*files.DeletedMetadata)
+	return !deleted, nil
+}
+
+// lookupKey returns the file with the path for the keys mode
+func (w *DropboxWatcher) lookupKey(key string) (snapshotObject, error) {
+	if err := w.throttle(context.Background()); err != nil {
+		return nil, err
+	}
+	res, err := w.client.GetMetadata(files.NewGetMetadataArg(key))
+	if err != nil {
+		if isDropboxNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, ok := res.(*files.FileMetadata)
+	if !ok {
+		// deleted or folder
+		return nil, nil
+	}
+	o := &DropboxObject{
+		Key:          f.PathDisplay,
+		Size:         int64(f.Size),
+		LastModified: f.ServerModified,
+		Hash:         f.ContentHash,
+	}
+	if f.PathDisplay == "" {
+		o.Key = path.Join(f.PathLower, f.Name)
+	}
+	return o, nil
+}
+
+func isDropboxNotFound(err error) bool {
+	e, ok := err.(files.GetMetadataAPIError)
+	return ok && e.EndpointError != nil && e.EndpointError.Path != nil &&
+		e.EndpointError.Path.Tag == files.LookupErrorNotFound
+}
+
 func (w *DropboxWatcher) getCachedObject(o *DropboxObject) *DropboxObject {
 	if cachedObject, ok := w.cache[o.Key]; ok {
 		return cachedObject
@@ -269,4 +536,5 @@ func (w *DropboxWatcher) getCachedObject(o *DropboxObject) *DropboxObject {
 
 func init() {
 	supportedServices["dropbox"] = newDropboxWatcher
+	objectTypes["dropbox"] = func() snapshotObject { return &DropboxObject{} }
 }
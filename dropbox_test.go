@@ -1,6 +1,7 @@
 package cloudwatcher
 
 import (
+	"github.com/Matrix86/cloudwatcher/internal/clock"
 	"github.com/Matrix86/cloudwatcher/mocks"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"github.com/golang/mock/gomock"
@@ -84,7 +85,7 @@ func TestDropboxWatcher_Start(t *testing.T) {
 
 		var event Event
 
-		modtime := time.Now()
+		modtime := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
 		// File created
 		m.EXPECT().ListFolder(arg).Return(
 			&files.ListFolderResult{
@@ -132,7 +133,7 @@ func TestDropboxWatcher_Start(t *testing.T) {
 		// File modified: modtime changed
 		m.EXPECT().ListFolder(arg).Return(
 			&files.ListFolderResult{
-				Entries: []files.IsMetadata{files.NewFileMetadata("name", "Id", time.Now(), time.Now(), "1", 150)},
+				Entries: []files.IsMetadata{files.NewFileMetadata("name", "Id", modtime.Add(time.Minute), modtime.Add(time.Minute), "1", 150)},
 				Cursor:  "",
 				HasMore: false,
 			}, nil)
@@ -162,3 +163,50 @@ func TestDropboxWatcher_Start(t *testing.T) {
 		}
 	}
 }
+
+func TestDropboxWatcher_Polling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockDropbox(ctrl)
+
+	fake := clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	d, err := New("dropbox", "/", time.Minute, WithClock(fake))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := d.SetConfig(map[string]string{"token": "{\"access_token\": \"asd\"}"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	dw := d.(*DropboxWatcher)
+	dw.client = m
+
+	arg := files.NewListFolderArg("/")
+	arg.Recursive = true
+	modtime := fake.Now()
+	gomock.InOrder(
+		m.EXPECT().ListFolder(arg).Return(&files.ListFolderResult{
+			Entries: []files.IsMetadata{files.NewFileMetadata("name", "Id", modtime, modtime, "1", 120)},
+		}, nil),
+		m.EXPECT().ListFolder(arg).Return(&files.ListFolderResult{
+			Entries: []files.IsMetadata{files.NewFileMetadata("name", "Id", modtime, modtime, "1", 150)},
+		}, nil),
+	)
+
+	if err := d.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	fake.Advance(time.Minute)
+
+	select {
+	case event := <-d.GetEvents():
+		if event.Key != "name" || event.Type != FileChanged {
+			t.Errorf("wrong event received: %s %s", event.Key, event.TypeString())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("FileChanged event not received")
+	}
+
+	d.Close()
+	for range d.GetEvents() {
+	}
+}
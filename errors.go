@@ -0,0 +1,37 @@
+package cloudwatcher
+
+import "fmt"
+
+// CredentialError is sent on the errors channel when the service rejects the credentials of the watcher. The watcher
+// keeps polling, resolving the secret references again, so it's fatal only if it's repeated.
+type CredentialError struct {
+	Err error
+}
+
+func (e *CredentialError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the service
+func (e *CredentialError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is sent on the errors channel when the watched resource (e.g. the bucket or the repository) doesn't
+// exist
+type NotFoundError struct {
+	Resource string
+	Err      error
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s not found", e.Resource)
+	}
+	return fmt.Sprintf("%s not found: %s", e.Resource, e.Err)
+}
+
+// Unwrap returns the error of the service
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
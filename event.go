@@ -1,5 +1,13 @@
 package cloudwatcher
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Event is the struct that contains the info about the changed file
 type Event struct {
 	Key    string      // Path of file
@@ -16,11 +24,19 @@ const (
 	FileChanged
 	FileDeleted
 	TagsChanged
+	// CacheReset is sent when the cache has been cleared because the watched storage changed (see Reconfigure),
+	// it has no key and no object
+	CacheReset
 )
 
 // TypeString returns a text version of the event's type
 func (e *Event) TypeString() string {
-	switch e.Type {
+	return e.Type.String()
+}
+
+// String returns a text version of the event's type
+func (o Op) String() string {
+	switch o {
 	case FileCreated:
 		return "FileCreated"
 	case FileChanged:
@@ -29,7 +45,104 @@ func (e *Event) TypeString() string {
 		return "FileDeleted"
 	case TagsChanged:
 		return "TagsChanged"
+	case CacheReset:
+		return "CacheReset"
 	default:
 		return "unknown"
 	}
-}
\ No newline at end of file
+}
+
+// MarshalText encodes the event's type as its name
+func (o Op) MarshalText() ([]byte, error) {
+	if o > CacheReset {
+		return nil, fmt.Errorf("unknown event type %d", o)
+	}
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText decodes the name of the event's type, ignoring the case
+func (o *Op) UnmarshalText(text []byte) error {
+	for op := Op(FileCreated); op <= CacheReset; op++ {
+		if strings.EqualFold(op.String(), string(text)) {
+			*o = op
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type '%s'", text)
+}
+
+// jsonEvent is the JSON representation of an Event, see MarshalJSON
+type jsonEvent struct {
+	Key    string          `json:"key"`
+	Type   Op              `json:"type"`
+	Kind   string          `json:"kind,omitempty"`
+	Object json.RawMessage `json:"object"`
+}
+
+// MarshalJSON encodes the event as
+//
+//	{"key": "path/of/file", "type": "FileCreated", "kind": "s3", "object": {...}}
+//
+// where kind is the service that generated the object (s3, local, gdrive, dropbox or git) and it is omitted if the
+// object is not one of the objects of this package.
+func (e Event) MarshalJSON() ([]byte, error) {
+	obj, err := json.Marshal(e.Object)
+	if err != nil {
+		return nil, err
+	}
+	j := jsonEvent{
+		Key:    e.Key,
+		Type:   e.Type,
+		Object: obj,
+	}
+	if o, ok := e.Object.(snapshotObject); ok {
+		j.Kind = o.backend()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes an event encoded by MarshalJSON restoring the concrete type of the object. If the kind is
+// missing the object is decoded as a generic JSON value.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	j := jsonEvent{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	var obj interface{}
+	if j.Kind != "" {
+		newObject, ok := objectTypes[j.Kind]
+		if !ok {
+			return fmt.Errorf("unknown object kind '%s'", j.Kind)
+		}
+		o := newObject()
+		if err := json.Unmarshal(j.Object, o); err != nil {
+			return fmt.Errorf("decoding %s object: %s", j.Kind, err)
+		}
+		obj = o
+	} else if len(j.Object) > 0 {
+		if err := json.Unmarshal(j.Object, &obj); err != nil {
+			return err
+		}
+	}
+
+	e.Key = j.Key
+	e.Type = j.Type
+	e.Object = obj
+	return nil
+}
+
+// MarshalEvent returns the JSON representation of the event used by the sinks
+func MarshalEvent(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// EventID returns a stable identifier of the event: the same change of the same object always has the same ID,
+// so it can be used by the consumers to discard the duplicates
+func EventID(e Event) string {
+	obj, _ := json.Marshal(e.Object)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00", e.Key, e.Type)
+	h.Write(obj)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
@@ -1,6 +1,14 @@
 package cloudwatcher
 
-import "testing"
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestEvent_TypeString(t *testing.T) {
 	e := Event{
@@ -30,3 +38,118 @@ func TestEvent_TypeString(t *testing.T) {
 		t.Errorf("unknown event has been wrongly translated ")
 	}
 }
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestOp_MarshalText(t *testing.T) {
+	for _, op := range []Op{FileCreated, FileChanged, FileDeleted, TagsChanged} {
+		text, err := op.MarshalText()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		var decoded Op
+		if err := decoded.UnmarshalText(text); err != nil || decoded != op {
+			t.Errorf("%s has been wrongly decoded: %d %v", text, decoded, err)
+		}
+	}
+
+	var op Op
+	if err := op.UnmarshalText([]byte("filechanged")); err != nil || op != FileChanged {
+		t.Errorf("the case should be ignored: %d %v", op, err)
+	}
+	if err := op.UnmarshalText([]byte("wrong")); err == nil {
+		t.Errorf("an error should be returned with an unknown name")
+	}
+	if _, err := Op(999).MarshalText(); err == nil {
+		t.Errorf("an error should be returned with an unknown type")
+	}
+}
+
+func TestEvent_JSON(t *testing.T) {
+	modified := time.Date(2023, 11, 5, 10, 30, 0, 123000000, time.UTC)
+	events := map[string]Event{
+		"s3": {Key: "reports/a.csv", Type: TagsChanged, Object: &S3Object{
+			Key: "reports/a.csv", Etag: "9e107d9d372bb6826bd81d3542a419d6", Size: 1024,
+			Tags: map[string]string{"team": "data"}, LastModified: modified,
+		}},
+		"local": {Key: "/tmp/a.txt", Type: FileCreated, Object: &LocalObject{
+			Key: "/tmp/a.txt", Size: 12, LastModified: modified, FileMode: 0644,
+		}},
+		"gdrive": {Key: "docs/a.doc", Type: FileChanged, Object: &GDriveObject{
+			ID: "1a2b3c", Key: "docs/a.doc", Size: 2048, LastModified: modified, Hash: "d41d8cd98f00b204e9800998ecf8427e",
+		}},
+		"dropbox": {Key: "/photos/a.jpg", Type: FileDeleted, Object: &DropboxObject{
+			Key: "/photos/a.jpg", Size: 4096, LastModified: modified, Hash: "e3b0c44298fc1c149afbf4c8996fb924",
+		}},
+		"git": {Key: "main.go", Type: FileChanged, Object: &GitObject{
+			Key: "main.go", Size: 512, FileMode: 0644, Hash: "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+			Commits: []*GitCommit{{
+				Hash: "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", Message: "fix", Branch: "main",
+				AuthorName: "John", AuthorEmail: "john@example.com", Time: modified,
+			}},
+		}},
+		"memory": {Key: "a.txt", Type: FileCreated, Object: &MemoryObject{
+			Key: "a.txt", Size: 4, Hash: "098f6bcd4621d373cade4e832627b4f6", Tags: map[string]string{"env": "test"},
+			LastModified: modified,
+		}},
+		"nil": {Key: "/photos/b.jpg", Type: FileDeleted},
+	}
+
+	for name, e := range events {
+		j, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		golden := filepath.Join("testdata", "events", name+".json")
+		if *update {
+			os.WriteFile(golden, append(j, '\n'), 0644)
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(expected) != string(j)+"\n" {
+			t.Errorf("%s: wrong encoding:\n%s", name, j)
+		}
+
+		decoded := Event{}
+		if err := json.Unmarshal(expected, &decoded); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(decoded, e) {
+			t.Errorf("%s: wrong decoding: %#v", name, decoded)
+		}
+	}
+}
+
+func TestEvent_UnmarshalJSON(t *testing.T) {
+	e := Event{}
+	if err := json.Unmarshal([]byte(`{"key":"a","type":"FileCreated","kind":"wrong","object":{}}`), &e); err == nil {
+		t.Errorf("an error should be returned with an unknown kind")
+	}
+	if err := json.Unmarshal([]byte(`{"key":"a","type":"wrong","object":null}`), &e); err == nil {
+		t.Errorf("an error should be returned with an unknown type")
+	}
+
+	// objects without kind are decoded as generic values
+	if err := json.Unmarshal([]byte(`{"key":"a","type":"FileCreated","object":{"name":"a"}}`), &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if m, ok := e.Object.(map[string]interface{}); !ok || m["name"] != "a" {
+		t.Errorf("wrong object decoded: %#v", e.Object)
+	}
+}
+
+func TestSnapshotObject_Backend(t *testing.T) {
+	for kind, newObject := range objectTypes {
+		if b := newObject().backend(); b != kind {
+			t.Errorf("wrong backend of the %s objects: %s", kind, b)
+		}
+	}
+
+	modified := time.Date(2023, 11, 5, 10, 30, 0, 0, time.UTC)
+	git := &GitObject{Commits: []*GitCommit{{Time: modified}, {Time: modified.Add(-time.Hour)}}}
+	if !git.modTime().Equal(modified) {
+		t.Errorf("the last commit should be used: %s", git.modTime())
+	}
+}
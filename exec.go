@@ -0,0 +1,216 @@
+package cloudwatcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverlapPolicy defines what happens to an event when the command is still running for the same key
+type OverlapPolicy int
+
+// overlap policies
+const (
+	// QueueOverlap runs the command again when the previous execution for the same key ends
+	QueueOverlap OverlapPolicy = iota
+	// DropOverlap discards the event
+	DropOverlap
+)
+
+// ExecConfig contains the options of the ExecSink
+type ExecConfig struct {
+	Command     []string      // program to execute followed by its arguments
+	Filter      *Filter       // if set, the command runs only for the accepted events
+	Concurrency int           // max number of commands running at the same time (default 1)
+	Timeout     time.Duration // timeout of a single execution, 0 means no timeout
+	Overlap     OverlapPolicy // policy applied when the command is already running for the same key
+	Dir         string        // working directory of the command
+	Env         []string      // additional environment variables in the form "KEY=value"
+	Stdout      io.Writer     // if set, it receives the standard output of the commands
+}
+
+// ExecSink is a Sink that runs a command for each event.
+// The event is passed as JSON on stdin and its fields as CW_KEY, CW_OP, CW_SIZE, CW_HASH and CW_BACKEND
+// environment variables. Failures, exit codes and stderr are reported on the Errors channel.
+type ExecSink struct {
+	config  ExecConfig
+	errors  chan error
+	sem     chan bool
+	wg      sync.WaitGroup
+	dropped uint64
+
+	droppedErrors uint64
+
+	mu      sync.Mutex
+	running map[string][]Event // keys with a running command -> events waiting for it
+	closed  bool
+}
+
+// NewExecSink creates an ExecSink
+func NewExecSink(c ExecConfig) (*ExecSink, error) {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return nil, fmt.Errorf("command needed")
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+	return &ExecSink{
+		config:  c,
+		errors:  make(chan error, 100),
+		sem:     make(chan bool, c.Concurrency),
+		running: make(map[string][]Event),
+	}, nil
+}
+
+// Send schedules the command for each accepted event, it doesn't wait for the executions
+func (s *ExecSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("exec sink closed")
+	}
+
+	for _, e := range events {
+		if !s.config.Filter.Match(e) {
+			continue
+		}
+		if queue, ok := s.running[e.Key]; ok {
+			if s.config.Overlap == DropOverlap {
+				atomic.AddUint64(&s.dropped, 1)
+				continue
+			}
+			s.running[e.Key] = append(queue, e)
+			continue
+		}
+		s.running[e.Key] = nil
+		s.wg.Add(1)
+		go s.runKey(e)
+	}
+	return nil
+}
+
+// Close waits for the running commands and closes the Errors channel
+func (s *ExecSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	close(s.errors)
+	return nil
+}
+
+// GetErrors returns a chan of error
+func (s *ExecSink) GetErrors() chan error {
+	return s.errors
+}
+
+// Dropped returns the number of events discarded by the DropOverlap policy
+func (s *ExecSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// DroppedErrors returns the number of errors discarded because the errors chan was full
+func (s *ExecSink) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&s.droppedErrors)
+}
+
+// sendError doesn't block, a reader not draining the errors must not stop the commands of the other keys
+func (s *ExecSink) sendError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+		atomic.AddUint64(&s.droppedErrors, 1)
+	}
+}
+
+func (s *ExecSink) runKey(e Event) {
+	defer s.wg.Done()
+	for {
+		s.sem <- true
+		err := s.run(e)
+		<-s.sem
+		if err != nil {
+			s.sendError(err)
+		}
+
+		s.mu.Lock()
+		queue := s.running[e.Key]
+		if len(queue) == 0 {
+			delete(s.running, e.Key)
+			s.mu.Unlock()
+			return
+		}
+		e = queue[0]
+		s.running[e.Key] = queue[1:]
+		s.mu.Unlock()
+	}
+}
+
+func (s *ExecSink) run(e Event) error {
+	input, err := MarshalEvent(e)
+	if err != nil {
+		return fmt.Errorf("encoding event '%s': %s", e.Key, err)
+	}
+
+	ctx := context.Background()
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, s.config.Command[0], s.config.Command[1:]...)
+	cmd.Dir = s.config.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = s.config.Stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), s.config.Env...)
+	var size int64
+	var hash, backend string
+	if o, ok := e.Object.(snapshotObject); ok {
+		size, hash, backend = o.size(), o.hash(), o.backend()
+	}
+	cmd.Env = append(cmd.Env,
+		"CW_KEY="+e.Key,
+		"CW_OP="+e.TypeString(),
+		fmt.Sprintf("CW_SIZE=%d", size),
+		"CW_HASH="+hash,
+		"CW_BACKEND="+backend,
+	)
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	name := strings.Join(s.config.Command, " ")
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("exec '%s' for '%s': timed out after %s", name, e.Key, s.config.Timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("exec '%s' for '%s': exit code %d: %s", name, e.Key, exitErr.ExitCode(), strings.TrimSpace(lastBytes(stderr.String(), 4096)))
+	}
+	return fmt.Errorf("exec '%s' for '%s': %s", name, e.Key, err)
+}
+
+func lastBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
@@ -0,0 +1,123 @@
+package cloudwatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecSink_Send(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test needs a POSIX shell")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+
+	s, err := NewExecSink(ExecConfig{
+		Command: []string{"sh", "-c", `echo "$CW_KEY $CW_OP $CW_SIZE $CW_HASH $CW_BACKEND" >> ` + out + `; cat >> ` + out + `.json`},
+		Filter:  &Filter{Ops: []Op{FileCreated}},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = s.Send(context.Background(), []Event{
+		{Key: "a.txt", Type: FileCreated, Object: &S3Object{Key: "a.txt", Size: 10, Etag: "abc"}},
+		{Key: "b.txt", Type: FileDeleted, Object: &S3Object{Key: "b.txt"}},
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	s.Close()
+
+	j, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("command not executed: %s", err)
+	}
+	if string(j) != "a.txt FileCreated 10 abc s3\n" {
+		t.Errorf("wrong environment: %s", j)
+	}
+
+	e := Event{}
+	j, _ = os.ReadFile(out + ".json")
+	if err := json.Unmarshal(j, &e); err != nil {
+		t.Errorf("wrong event on stdin: %s", err)
+	} else if e.Key != "a.txt" || e.Type != FileCreated {
+		t.Errorf("wrong event on stdin: %#v", e)
+	}
+}
+
+func TestExecSink_Errors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test needs a POSIX shell")
+	}
+
+	s, _ := NewExecSink(ExecConfig{
+		Command:     []string{"sh", "-c", `if [ "$CW_KEY" = "slow" ]; then exec sleep 5; fi; echo failure >&2; exit 3`},
+		Concurrency: 2,
+		Timeout:     100 * time.Millisecond,
+	})
+	s.Send(context.Background(), []Event{{Key: "slow"}, {Key: "fail"}})
+
+	errs := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-s.GetErrors():
+			errs = append(errs, err.Error())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("error not received")
+		}
+	}
+	s.Close()
+
+	joined := strings.Join(errs, "\n")
+	if !strings.Contains(joined, "exit code 3: failure") {
+		t.Errorf("exit code and stderr not reported: %s", joined)
+	}
+	if !strings.Contains(joined, "timed out") {
+		t.Errorf("timeout not reported: %s", joined)
+	}
+
+	// the errors not read don't block the commands
+	s, _ = NewExecSink(ExecConfig{Command: []string{"sh", "-c", "exit 1"}, Concurrency: 1})
+	for i := 0; i < cap(s.errors)+10; i++ {
+		s.Send(context.Background(), []Event{{Key: fmt.Sprintf("key%d", i)}})
+	}
+	s.Close()
+	if s.DroppedErrors() != 10 {
+		t.Errorf("wrong number of dropped errors: %d", s.DroppedErrors())
+	}
+}
+
+func TestExecSink_Overlap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test needs a POSIX shell")
+	}
+	dir := t.TempDir()
+
+	for _, policy := range []OverlapPolicy{QueueOverlap, DropOverlap} {
+		out := filepath.Join(dir, "out")
+		os.Remove(out)
+
+		s, _ := NewExecSink(ExecConfig{
+			Command:     []string{"sh", "-c", `sleep 0.2; echo "$CW_KEY" >> ` + out},
+			Concurrency: 4,
+			Overlap:     policy,
+		})
+		s.Send(context.Background(), []Event{{Key: "a"}, {Key: "a"}, {Key: "a"}})
+		s.Close()
+
+		j, _ := os.ReadFile(out)
+		lines := strings.Count(string(j), "\n")
+		if policy == QueueOverlap && lines != 3 {
+			t.Errorf("queued events should be executed: %d runs", lines)
+		} else if policy == DropOverlap && (lines != 1 || s.Dropped() != 2) {
+			t.Errorf("overlapping events should be dropped: %d runs, %d dropped", lines, s.Dropped())
+		}
+	}
+}
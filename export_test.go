@@ -0,0 +1,20 @@
+package cloudwatcher
+
+import (
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"google.golang.org/api/drive/v3"
+)
+
+// the clients of the watchers are replaced by the stand-ins of the conformance tests (package cloudwatcher_test)
+
+func SetS3Client(w Watcher, client IMinio) {
+	w.(*S3Watcher).client = client
+}
+
+func SetDropboxClient(w Watcher, client files.Client) {
+	w.(*DropboxWatcher).client = client
+}
+
+func SetGDriveClient(w Watcher, client *drive.Service) {
+	w.(*GDriveWatcher).client = client
+}
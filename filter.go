@@ -0,0 +1,107 @@
+package cloudwatcher
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Filter selects the events by type and key.
+// Key patterns are globs where '*' and '?' don't match the '/' and '**' matches any sequence of characters.
+type Filter struct {
+	Ops     []Op     // if not empty, only the events with one of these types are accepted
+	Include []string // if not empty, the key has to match at least one of these patterns
+	Exclude []string // the events with a key matching one of these patterns are discarded
+}
+
+var globCache sync.Map
+
+// Match returns true if the event is accepted by the filter, a nil filter accepts all the events
+func (f *Filter) Match(e Event) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Ops) != 0 {
+		found := false
+		for _, op := range f.Ops {
+			if op == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Include) != 0 {
+		found := false
+		for _, pattern := range f.Include {
+			if MatchGlob(pattern, e.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, pattern := range f.Exclude {
+		if MatchGlob(pattern, e.Key) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchGlob returns true if key matches the glob pattern
+func MatchGlob(pattern string, key string) bool {
+	if v, ok := globCache.Load(pattern); ok {
+		return v.(*regexp.Regexp).MatchString(key)
+	}
+
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return false
+	}
+	globCache.Store(pattern, re)
+	return re.MatchString(key)
+}
+
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end == -1 {
+				b.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -3,15 +3,20 @@ package cloudwatcher
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 )
 
 // GDriveWatcher is the specialized watcher for Google Drive service
@@ -20,7 +25,7 @@ type GDriveWatcher struct {
 
 	syncing uint32
 
-	ticker *time.Ticker
+	ticker Ticker
 	stop   chan bool
 	config *gDriveConfiguration
 	cache  map[string]*GDriveObject
@@ -29,19 +34,23 @@ type GDriveWatcher struct {
 
 // GDriveObject is the object that contains the info of the file
 type GDriveObject struct {
-	ID           string
-	Key          string
-	Size         int64
-	LastModified time.Time
-	Hash         string
+	ID           string    `json:"id"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Hash         string    `json:"hash"`
 }
 
 type gDriveConfiguration struct {
 	Debug        Bool   `json:"debug"`
-	JToken       string `json:"token"`
+	JToken       string `json:"token" secret:"true"`
 	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	APIKey       string `json:"api_key"`
+	ClientSecret string `json:"client_secret" secret:"true"`
+	APIKey       string `json:"api_key" secret:"true"`
+
+	guardConfiguration
+	keysConfiguration
+	rateConfiguration
 
 	token *oauth2.Token
 }
@@ -52,10 +61,11 @@ func newGDriveWatcher(dir string, interval time.Duration) (Watcher, error) {
 		config: nil,
 		stop:   make(chan bool, 1),
 		WatcherBase: WatcherBase{
-			Events:      make(chan Event, 100),
-			Errors:      make(chan error, 100),
-			watchDir:    dir,
-			pollingTime: interval,
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
 		},
 	}
 	return w, nil
@@ -63,42 +73,134 @@ func newGDriveWatcher(dir string, interval time.Duration) (Watcher, error) {
 
 // SetConfig is used to configure the GDriveWatcher
 func (w *GDriveWatcher) SetConfig(m map[string]string) error {
-	j, err := json.Marshal(m)
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, gDriveConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseGDriveConfig(resolved)
 	if err != nil {
 		return err
 	}
 
-	config := gDriveConfiguration{}
-	if err := json.Unmarshal(j, &config); err != nil {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*GDriveObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+// Reconfigure validates the new configuration and applies it between two synchronizations, the cache is always
+// kept. The client is created again if the credentials change.
+func (w *GDriveWatcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, gDriveConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseGDriveConfig(resolved)
+	if err != nil {
 		return err
 	}
 
-	if config.JToken == "" && config.APIKey == "" {
-		return fmt.Errorf("token or api_key have to be set")
+	if err := w.lockCycle(ctx); err != nil {
+		return err
 	}
-	w.config = &config
+	defer w.unlockCycle()
+	if w.config == nil || w.config.JToken != config.JToken || w.config.APIKey != config.APIKey ||
+		w.config.ClientID != config.ClientID || w.config.ClientSecret != config.ClientSecret {
+		w.client = nil
+	}
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*GDriveObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	return nil
+}
 
-	tok := &oauth2.Token{}
-	if err := json.Unmarshal([]byte(config.JToken), tok); err != nil {
+// applySecrets uses the secrets resolved again, the client is created with them by the next synchronization
+func (w *GDriveWatcher) applySecrets(m map[string]string) error {
+	config, err := parseGDriveConfig(m)
+	if err != nil {
 		return err
 	}
-	w.config.token = tok
+	w.config = config
+	w.client = nil
 	return nil
 }
 
+// isGDriveCredentialError returns true if the request has been rejected because of the credentials
+func isGDriveCredentialError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden
+	}
+	var tokenErr *oauth2.RetrieveError
+	return errors.As(err, &tokenErr)
+}
+
+func parseGDriveConfig(m map[string]string) (*gDriveConfiguration, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &gDriveConfiguration{}
+	if err := json.Unmarshal(j, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.validateRate(); err != nil {
+		return nil, err
+	}
+
+	if config.JToken == "" && config.APIKey == "" {
+		return nil, fmt.Errorf("token or api_key have to be set")
+	}
+
+	if config.JToken != "" {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(config.JToken), tok); err != nil {
+			return nil, err
+		}
+		config.token = tok
+	}
+	return config, nil
+}
+
 // Start launches the polling process
 func (w *GDriveWatcher) Start() error {
 	if w.config == nil {
 		return fmt.Errorf("configuration for Dropbox needed")
 	}
 
-	w.ticker = time.NewTicker(w.pollingTime)
+	if w.scheduler != nil {
+		return w.startScheduled(w)
+	}
+	w.ticker = w.getClock().NewTicker(w.pollingTime)
 	go func() {
 		// launch synchronization also the first time
 		w.sync(true)
 		for {
 			select {
-			case <-w.ticker.C:
+			case <-w.ticker.C():
+				w.sync(false)
+
+			case <-w.syncRequests:
 				w.sync(false)
 
 			case <-w.stop:
@@ -113,17 +215,62 @@ func (w *GDriveWatcher) Start() error {
 
 // Close stop the polling process
 func (w *GDriveWatcher) Close() {
+	w.leaveRateGroup()
+	if w.closeScheduled(nil) {
+		return
+	}
 	if w.stop != nil {
 		w.stop <- true
 	}
 }
 
+func (w *GDriveWatcher) service() string {
+	return "gdrive"
+}
+
+// schedulingGroup returns the group of the watcher in a Scheduler: the client and the token or the API key
+func (w *GDriveWatcher) schedulingGroup() string {
+	return "gdrive:" + w.config.ClientID + "/" + credentialID(w.config.JToken+w.config.APIKey)
+}
+
+func (w *GDriveWatcher) source() string {
+	return "gdrive://" + path.Join("/", w.watchDir)
+}
+
+// String returns the URI of the watcher, the secrets are redacted
+func (w *GDriveWatcher) String() string {
+	return formatURI(&url.URL{Scheme: "gdrive", Path: path.Join("/", w.watchDir)}, w.pollingTime, w.config, w.rawConfig)
+}
+
+// Capabilities returns the features of Google Drive: the files have a md5 checksum
+func (w *GDriveWatcher) Capabilities() Capabilities {
+	return Capabilities{
+		ContentHash: true,
+		ConfigKeys:  configKeys(gDriveConfiguration{}),
+	}
+}
+
 func (w *GDriveWatcher) sync(firstSync bool) {
 	// allow only one sync at same time
 	if !atomic.CompareAndSwapUint32(&w.syncing, 0, 1) {
 		return
 	}
 	defer atomic.StoreUint32(&w.syncing, 0)
+	w.syncStarted()
+	defer w.syncDone()
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	firstSync = w.checkCacheReset(firstSync)
+	if w.keysMode() {
+		if w.client == nil {
+			w.initDriverClient()
+		}
+		if err := w.syncKeys(firstSync, w.config.guardConfiguration, w.lookupKey); isGDriveCredentialError(err) {
+			w.refreshSecrets(w.applySecrets)
+		}
+		return
+	}
 
 	fileList := make(map[string]*GDriveObject, 0)
 
@@ -136,11 +283,10 @@ func (w *GDriveWatcher) sync(firstSync bool) {
 			cached := w.getCachedObject(obj)
 			// Object has been cached previously by Key
 			if cached != nil {
-				// Check if the LastModified has been changed
-				if !cached.LastModified.Equal(obj.LastModified) || cached.Hash != obj.Hash {
+				for _, op := range obj.changes(cached) {
 					event := Event{
 						Key:    obj.Key,
-						Type:   FileChanged,
+						Type:   op,
 						Object: obj,
 					}
 					w.Events <- event
@@ -158,13 +304,29 @@ func (w *GDriveWatcher) sync(firstSync bool) {
 		return true
 	})
 	if err != nil {
+		if isGDriveCredentialError(err) {
+			w.Errors <- &CredentialError{Err: err}
+			w.refreshSecrets(w.applySecrets)
+			return
+		}
 		w.Errors <- err
 		return
 	}
 
-	for k, o := range w.cache {
-		if _, found := fileList[k]; !found {
+	if !firstSync {
+		deleted := make([]string, 0)
+		for k := range w.cache {
+			if _, found := fileList[k]; !found {
+				deleted = append(deleted, k)
+			}
+		}
+		deleted = w.confirmMissing(w.config.guardConfiguration, deleted, w.objectExists)
+		if !w.checkDeletes(w.config.guardConfiguration, deleted, len(w.cache)) {
+			return
+		}
+		for _, k := range deleted {
 			// file not found in the list...deleting it
+			o := w.cache[k]
 			delete(w.cache, k)
 			event := Event{
 				Key:    o.Key,
@@ -230,6 +392,10 @@ func (w *GDriveWatcher) enumerateFiles(prefix string, callback func(object *GDri
 		w.initDriverClient()
 	}
 
+	// Pages requests the next page after the callback
+	if err := w.throttle(context.Background()); err != nil {
+		return err
+	}
 	err := w.client.Files.List().Fields("nextPageToken, files(id, name, mimeType, modifiedTime, parents, size, md5Checksum, trashed)").Pages(context.Background(), func(files *drive.FileList) error {
 		fileList := make(map[string]*drive.File)
 
@@ -261,6 +427,9 @@ func (w *GDriveWatcher) enumerateFiles(prefix string, callback func(object *GDri
 				}
 			}
 		}
+		if files.NextPageToken != "" {
+			return w.throttle(context.Background())
+		}
 		return nil
 	})
 	if err != nil {
@@ -269,6 +438,122 @@ func (w *GDriveWatcher) enumerateFiles(prefix string, callback func(object *GDri
 	return nil
 }
 
+func (o *GDriveObject) cacheKey() string {
+	return o.ID
+}
+
+func (o *GDriveObject) eventKey() string {
+	return o.Key
+}
+
+func (o *GDriveObject) backend() string {
+	return "gdrive"
+}
+
+func (o *GDriveObject) size() int64 {
+	return o.Size
+}
+
+func (o *GDriveObject) modTime() time.Time {
+	return o.LastModified
+}
+
+func (o *GDriveObject) hash() string {
+	return o.Hash
+}
+
+func (o *GDriveObject) changes(cached snapshotObject) []Op {
+	c := cached.(*GDriveObject)
+	// Check if the LastModified has been changed
+	if !c.LastModified.Equal(o.LastModified) || c.Hash != o.Hash {
+		return []Op{FileChanged}
+	}
+	return nil
+}
+
+func (w *GDriveWatcher) list() ([]snapshotObject, error) {
+	if w.config == nil {
+		return nil, fmt.Errorf("configuration for GDrive needed")
+	}
+
+	// waiting for the running sync
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+
+	objects := make([]snapshotObject, 0)
+	err := w.enumerateFiles(w.watchDir, func(obj *GDriveObject) bool {
+		objects = append(objects, obj)
+		return true
+	})
+	return objects, err
+}
+
+// objectExists gets the file missing from the listing by its ID, the trashed files don't exist
+func (w *GDriveWatcher) objectExists(id string) (bool, error) {
+	if err := w.throttle(context.Background()); err != nil {
+		return false, err
+	}
+	f, err := w.client.Files.Get(id).Fields("id, trashed").Do()
+	if err != nil {
+		if isGDriveNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !f.Trashed, nil
+}
+
+// lookupKey gets the file with the ID for the keys mode, the path is resolved getting its first parents up to the
+// root folder, which isn't part of the paths as in the listing
+func (w *GDriveWatcher) lookupKey(id string) (snapshotObject, error) {
+	if err := w.throttle(context.Background()); err != nil {
+		return nil, err
+	}
+	file, err := w.client.Files.Get(id).Fields("id, name, mimeType, modifiedTime, parents, size, md5Checksum, trashed").Do()
+	if err != nil {
+		if isGDriveNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if file.MimeType == "application/vnd.google-apps.folder" || file.Trashed {
+		return nil, nil
+	}
+	mt, err := time.Parse(time.RFC3339, file.ModifiedTime)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{file.Name}
+	for parents := file.Parents; len(parents) > 0; {
+		if err := w.throttle(context.Background()); err != nil {
+			return nil, err
+		}
+		parent, err := w.client.Files.Get(parents[0]).Fields("id, name, parents").Do()
+		if err != nil {
+			return nil, fmt.Errorf("getting parent '%s': %s", parents[0], err)
+		}
+		if len(parent.Parents) == 0 {
+			break
+		}
+		names = append([]string{parent.Name}, names...)
+		parents = parent.Parents
+	}
+
+	return &GDriveObject{
+		ID:           file.Id,
+		Key:          strings.Join(names, "/"),
+		Size:         file.Size,
+		LastModified: mt,
+		Hash:         file.Md5Checksum,
+	}, nil
+}
+
+func isGDriveNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
 func (w *GDriveWatcher) getCachedObject(o *GDriveObject) *GDriveObject {
 	if cachedObject, ok := w.cache[o.ID]; ok {
 		return cachedObject
@@ -278,4 +563,5 @@ func (w *GDriveWatcher) getCachedObject(o *GDriveObject) *GDriveObject {
 
 func init() {
 	supportedServices["gdrive"] = newGDriveWatcher
+	objectTypes["gdrive"] = func() snapshotObject { return &GDriveObject{} }
 }
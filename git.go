@@ -1,11 +1,13 @@
 package cloudwatcher
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"strings"
 	"sync/atomic"
@@ -28,8 +30,9 @@ type GitWatcher struct {
 
 	repository *git.Repository
 	auth       transport.AuthMethod
+	tempDir    string // directory of the clone created by the watcher when temp_dir is not set
 
-	ticker      *time.Ticker
+	ticker      Ticker
 	stop        chan bool
 	config      *gitConfiguration
 	fileCache   map[string]*GitObject
@@ -39,21 +42,21 @@ type GitWatcher struct {
 
 // GitCommit is the object that contains the info about the commit
 type GitCommit struct {
-	Hash        string
-	Message     string
-	Branch      string
-	AuthorName  string
-	AuthorEmail string
-	Time        time.Time
+	Hash        string    `json:"hash"`
+	Message     string    `json:"message"`
+	Branch      string    `json:"branch"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	Time        time.Time `json:"time"`
 }
 
 // GitObject is the object that contains the info of the file
 type GitObject struct {
-	Key      string
-	Size     int64
-	FileMode os.FileMode
-	Hash     string
-	Commits  []*GitCommit
+	Key      string       `json:"key"`
+	Size     int64        `json:"size"`
+	FileMode os.FileMode  `json:"file_mode"`
+	Hash     string       `json:"hash"`
+	Commits  []*GitCommit `json:"commits"`
 }
 
 type gitConfiguration struct {
@@ -61,14 +64,18 @@ type gitConfiguration struct {
 	MonitorType     string `json:"monitor_type"` // file, repo
 	AuthType        string `json:"auth_type"`    // ssh, http_token, http_user_pass
 	SSHPrivateKey   string `json:"ssh_pkey"`
-	SSHPKeyPassword string `json:"ssh_pkey_password"`
-	HTTPToken       string `json:"http_token"`
+	SSHPKeyPassword string `json:"ssh_pkey_password" secret:"true"`
+	HTTPToken       string `json:"http_token" secret:"true"`
 	HTTPUsername    string `json:"http_username"`
-	HTTPPassword    string `json:"http_password"`
+	HTTPPassword    string `json:"http_password" secret:"true"`
 	RepoURL         string `json:"repo_url"`
 	RepoBranch      string `json:"repo_branch"`
 	AssembleEvents  Bool   `json:"assemble_events"`
 	TempDir         string `json:"temp_dir"`
+
+	guardConfiguration
+	keysConfiguration
+	rateConfiguration
 }
 
 func newGitWatcher(dir string, interval time.Duration) (Watcher, error) {
@@ -78,61 +85,187 @@ func newGitWatcher(dir string, interval time.Duration) (Watcher, error) {
 		branchCache: make(map[string]string),
 		stop:        make(chan bool, 1),
 		WatcherBase: WatcherBase{
-			Events:      make(chan Event, 100),
-			Errors:      make(chan error, 100),
-			watchDir:    dir,
-			pollingTime: interval,
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
 		},
 	}, nil
 }
 
 // SetConfig is used to configure the GitWatcher
 func (w *GitWatcher) SetConfig(m map[string]string) error {
-	j, err := json.Marshal(m)
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, gitConfiguration{})
 	if err != nil {
 		return err
 	}
+	config, err := parseGitConfig(resolved)
+	if err != nil {
+		return err
+	}
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.dropTempDir(config)
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.fileCache = make(map[string]*GitObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	w.repository = nil
+	return nil
+}
+
+// Reconfigure validates the new configuration and applies it between two synchronizations. The caches are cleared
+// if the repository, the branch or the monitor_type change, and the repository is opened again with the new
+// credentials and temp_dir.
+func (w *GitWatcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, gitConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseGitConfig(resolved)
+	if err != nil {
+		return err
+	}
+
+	if err := w.lockCycle(ctx); err != nil {
+		return err
+	}
+	defer w.unlockCycle()
+	if w.config != nil && (w.config.RepoURL != config.RepoURL || w.config.RepoBranch != config.RepoBranch ||
+		w.config.MonitorType != config.MonitorType) {
+		if config.TempDir != "" && w.config.TempDir == config.TempDir {
+			return fmt.Errorf("a different repository requires a different temp_dir")
+		}
+		w.fileCache = make(map[string]*GitObject)
+		w.branchCache = make(map[string]string)
+		w.tagCache = make(map[string]string)
+		w.markCacheReset()
+	}
+	w.dropTempDir(config)
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.fileCache = make(map[string]*GitObject)
+		w.markCacheReset()
+	}
+	w.setRateLimit(config.rateConfiguration)
+	w.setRawConfig(m, resolved)
+	w.repository = nil
+	return nil
+}
+
+// applySecrets updates the credentials with the secrets resolved again, the repository is opened with them by the
+// next synchronization. It's called holding the cycle.
+func (w *GitWatcher) applySecrets(m map[string]string) error {
+	config, err := parseGitConfig(m)
+	if err != nil {
+		return err
+	}
+	updated := *w.config
+	updated.SSHPKeyPassword = config.SSHPKeyPassword
+	updated.HTTPToken = config.HTTPToken
+	updated.HTTPPassword = config.HTTPPassword
+	w.config = &updated
+	w.repository = nil
+	return nil
+}
+
+// repoDir returns the directory of the clone: temp_dir, or a temporary directory created once by the watcher
+func (w *GitWatcher) repoDir() (string, error) {
+	if w.config.TempDir != "" {
+		return w.config.TempDir, nil
+	}
+	if w.tempDir == "" {
+		dir, err := ioutil.TempDir("", "tmp_git")
+		if err != nil {
+			return "", fmt.Errorf("creating temp dir: %s", err)
+		}
+		w.tempDir = dir
+	}
+	return w.tempDir, nil
+}
+
+// dropTempDir removes the clone created by the watcher if it's not used by the new configuration: temp_dir is set
+// or the repository is another one. It's called holding the cycle.
+func (w *GitWatcher) dropTempDir(config *gitConfiguration) {
+	if config != nil && config.TempDir == "" && w.config != nil && w.config.RepoURL == config.RepoURL {
+		return
+	}
+	if w.tempDir != "" {
+		os.RemoveAll(w.tempDir)
+		w.tempDir = ""
+		w.repository = nil
+	}
+}
+
+// cleanup removes the clone created by the watcher once closed
+func (w *GitWatcher) cleanup() {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.dropTempDir(nil)
+}
+
+// isGitCredentialError returns true if the remote has rejected the credentials
+func isGitCredentialError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+func parseGitConfig(m map[string]string) (*gitConfiguration, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
 
 	config := gitConfiguration{}
 	if err := json.Unmarshal(j, &config); err != nil {
-		return err
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.validateRate(); err != nil {
+		return nil, err
 	}
 
 	if config.MonitorType == "" {
 		config.MonitorType = "repo" // setting default behaviour
 	} else if !inArray(config.MonitorType, []string{"repo", "file"}) {
-		return fmt.Errorf("unknown monitor_type '%s'", config.MonitorType)
+		return nil, fmt.Errorf("unknown monitor_type '%s'", config.MonitorType)
+	}
+	if len(config.list()) > 0 && config.MonitorType != "file" {
+		return nil, fmt.Errorf("keys requires monitor_type 'file'")
 	}
 
 	if config.AuthType == "ssh" {
 		_, err := os.Stat(config.SSHPrivateKey)
 		if err != nil {
-			return fmt.Errorf("cannot read file '%s': %s", config.SSHPrivateKey, err)
+			return nil, fmt.Errorf("cannot read file '%s': %s", config.SSHPrivateKey, err)
 		}
 	}
 
 	if !inArray(config.AuthType, []string{"", "none", "ssh", "http_token", "http_user_pass"}) {
-		return fmt.Errorf("unknown auth_type '%s'", config.AuthType)
+		return nil, fmt.Errorf("unknown auth_type '%s'", config.AuthType)
 	}
 
 	if config.RepoURL == "" {
-		return fmt.Errorf("url repository required")
+		return nil, fmt.Errorf("url repository required")
 	}
 
 	if config.AuthType == "file" && config.RepoBranch == "" {
-		return fmt.Errorf("branch repository required")
+		return nil, fmt.Errorf("branch repository required")
 	}
 
-	if config.TempDir == "" {
-		dir, err := ioutil.TempDir("", "tmp_git")
-		if err != nil {
-			return fmt.Errorf("creating temp dir: %s", err)
-		}
-		config.TempDir = dir
-	}
-
-	w.config = &config
-	return nil
+	return &config, nil
 }
 
 // Start launches the polling process
@@ -141,16 +274,23 @@ func (w *GitWatcher) Start() error {
 		return fmt.Errorf("configuration for Git needed")
 	}
 
-	w.ticker = time.NewTicker(w.pollingTime)
+	if w.scheduler != nil {
+		return w.startScheduled(w)
+	}
+	w.ticker = w.getClock().NewTicker(w.pollingTime)
 	go func() {
 		// launch synchronization also the first time
 		w.sync(true)
 		for {
 			select {
-			case <-w.ticker.C:
+			case <-w.ticker.C():
+				w.sync(false)
+
+			case <-w.syncRequests:
 				w.sync(false)
 
 			case <-w.stop:
+				w.cleanup()
 				close(w.Events)
 				close(w.Errors)
 				return
@@ -160,11 +300,100 @@ func (w *GitWatcher) Start() error {
 	return nil
 }
 
-// Close stop the polling process
+// Close stop the polling process, the clone created by the watcher is removed
 func (w *GitWatcher) Close() {
-	if w.stop != nil {
-		w.stop <- true
+	w.leaveRateGroup()
+	if w.closeScheduled(w.cleanup) {
+		return
+	}
+	if w.ticker == nil {
+		// not started
+		w.cleanup()
+		return
+	}
+	w.stop <- true
+}
+
+// addKeys is supported only with monitor_type "file"
+func (w *GitWatcher) addKeys(keys []string) error {
+	if w.config == nil || w.config.MonitorType != "file" {
+		return fmt.Errorf("keys requires monitor_type 'file'")
+	}
+	return w.WatcherBase.addKeys(keys)
+}
+
+func (w *GitWatcher) service() string {
+	return "git"
+}
+
+// schedulingGroup returns the group of the watcher in a Scheduler: the host of the repository, also with the
+// scp-like urls (user@host:path)
+func (w *GitWatcher) schedulingGroup() string {
+	host := w.config.RepoURL
+	if u, err := url.Parse(host); err == nil && u.Scheme != "" {
+		host = u.Host
+	} else {
+		if i := strings.Index(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		if i := strings.Index(host, ":"); i >= 0 {
+			host = host[:i]
+		}
 	}
+	return "git:" + host
+}
+
+func (w *GitWatcher) source() string {
+	repo := ""
+	if w.config != nil {
+		repo = w.config.RepoURL
+		// removing the credentials from the url
+		if u, err := url.Parse(repo); err == nil && u.User != nil {
+			u.User = nil
+			repo = u.String()
+		}
+	}
+	return "git+" + repo
+}
+
+// String returns the URI of the watcher, the secrets are redacted. The repositories that are not URLs (as the scp-like
+// ssh addresses) are in the repo_url parameter.
+func (w *GitWatcher) String() string {
+	uri := &url.URL{Scheme: "git"}
+	skip := []string{"repo_branch"}
+	if w.config != nil {
+		if u, err := url.Parse(w.config.RepoURL); err == nil && u.Scheme != "" {
+			uri = u
+			uri.Scheme = "git+" + u.Scheme
+			if _, ok := u.User.Password(); ok {
+				uri.User = url.UserPassword(u.User.Username(), redacted)
+			} else if u.User != nil && (uri.Scheme == "git+http" || uri.Scheme == "git+https") {
+				// the user of https://TOKEN@host/repo.git is a token
+				uri.User = url.User(redacted)
+			}
+			skip = append(skip, "repo_url")
+		}
+	}
+	query := uri.Query()
+	if w.config != nil && w.config.RepoBranch != "" {
+		query.Set("branch", w.config.RepoBranch)
+	}
+	if w.watchDir != "" {
+		query.Set("dir", w.watchDir)
+	}
+	uri.RawQuery = query.Encode()
+	return formatURI(uri, w.pollingTime, w.config, w.rawConfig, skip...)
+}
+
+// Capabilities returns the features of Git: with monitor_type "file" the file modes are the tags and the objects
+// contain the hash of the blob, the commits and tags of the "repo" mode are not objects
+func (w *GitWatcher) Capabilities() Capabilities {
+	c := Capabilities{ConfigKeys: configKeys(gitConfiguration{})}
+	if w.config != nil && w.config.MonitorType == "file" {
+		c.Tags = true
+		c.ContentHash = true
+	}
+	return c
 }
 
 func (w *GitWatcher) getCachedObject(o *GitObject) *GitObject {
@@ -174,12 +403,83 @@ func (w *GitWatcher) getCachedObject(o *GitObject) *GitObject {
 	return nil
 }
 
+func (o *GitObject) cacheKey() string {
+	return o.Key
+}
+
+func (o *GitObject) eventKey() string {
+	return o.Key
+}
+
+func (o *GitObject) backend() string {
+	return "git"
+}
+
+func (o *GitObject) size() int64 {
+	return o.Size
+}
+
+// modTime returns the time of the last commit of the object, zero if the commits are not known
+func (o *GitObject) modTime() time.Time {
+	var last time.Time
+	for _, c := range o.Commits {
+		if c.Time.After(last) {
+			last = c.Time
+		}
+	}
+	return last
+}
+
+func (o *GitObject) hash() string {
+	return o.Hash
+}
+
+func (o *GitObject) changes(cached snapshotObject) []Op {
+	c := cached.(*GitObject)
+	// Check if the Hash or the FileMode have been changed
+	if c.Hash != o.Hash {
+		return []Op{FileChanged}
+	} else if c.FileMode != o.FileMode {
+		return []Op{TagsChanged}
+	}
+	return nil
+}
+
+func (w *GitWatcher) list() ([]snapshotObject, error) {
+	if w.config == nil {
+		return nil, fmt.Errorf("configuration for Git needed")
+	}
+
+	// waiting for the running sync
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+
+	if w.config.MonitorType != "file" {
+		return nil, fmt.Errorf("snapshots are supported only with monitor_type 'file'")
+	}
+	if err := w.updateRepo(); err != nil {
+		return nil, err
+	}
+	objects := make([]snapshotObject, 0)
+	err := w.enumerateFiles(w.watchDir, func(obj *GitObject) bool {
+		objects = append(objects, obj)
+		return true
+	})
+	return objects, err
+}
+
 func (w *GitWatcher) sync(firstSync bool) {
 	// allow only one sync at same time
 	if !atomic.CompareAndSwapUint32(&w.syncing, 0, 1) {
 		return
 	}
 	defer atomic.StoreUint32(&w.syncing, 0)
+	w.syncStarted()
+	defer w.syncDone()
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	firstSync = w.checkCacheReset(firstSync)
 
 	err := w.updateRepo()
 	if err != nil {
@@ -187,6 +487,23 @@ func (w *GitWatcher) sync(firstSync bool) {
 		return
 	}
 
+	if w.keysMode() {
+		tree, err := w.headTree()
+		if err != nil {
+			w.Errors <- err
+			return
+		}
+		err = w.syncKeys(firstSync, w.config.guardConfiguration, func(key string) (snapshotObject, error) {
+			return lookupGitKey(tree, key)
+		})
+		if err != nil {
+			// the missing files are not errors, the clone can't be read: it is opened again (or cloned again if it
+			// has been removed) by the next synchronization
+			w.repository = nil
+		}
+		return
+	}
+
 	// default behaviour is file
 	if w.config.MonitorType == "repo" {
 		w.checkCommits(firstSync)
@@ -206,18 +523,10 @@ func (w *GitWatcher) sync(firstSync bool) {
 			cached := w.getCachedObject(obj)
 			// Object has been cached previously by Key
 			if cached != nil {
-				// Check if the Hash or the FileMode have been changed
-				if cached.Hash != obj.Hash {
+				for _, op := range obj.changes(cached) {
 					event := Event{
 						Key:    obj.Key,
-						Type:   FileChanged,
-						Object: obj,
-					}
-					w.Events <- event
-				} else if cached.FileMode != obj.FileMode {
-					event := Event{
-						Key:    obj.Key,
-						Type:   TagsChanged,
+						Type:   op,
 						Object: obj,
 					}
 					w.Events <- event
@@ -238,9 +547,20 @@ func (w *GitWatcher) sync(firstSync bool) {
 			return
 		}
 
-		for k, o := range w.fileCache {
-			if _, found := fileList[k]; !found {
+		if !firstSync {
+			deleted := make([]string, 0)
+			for k := range w.fileCache {
+				if _, found := fileList[k]; !found {
+					deleted = append(deleted, k)
+				}
+			}
+			deleted = w.confirmMissing(w.config.guardConfiguration, deleted, nil)
+			if !w.checkDeletes(w.config.guardConfiguration, deleted, len(w.fileCache)) {
+				return
+			}
+			for _, k := range deleted {
 				// file not found in the list...deleting it
+				o := w.fileCache[k]
 				delete(w.fileCache, k)
 				event := Event{
 					Key:    o.Key,
@@ -327,11 +647,12 @@ func (w *GitWatcher) checkCommits(disableNotification bool) {
 			// Caching last commit
 			w.branchCache[branch] = commits[0].Hash
 
+			// the new commits are a change of the branch
 			if disableNotification == false {
 				if w.config.AssembleEvents {
 					event := Event{
 						Key:  "commit",
-						Type: 0,
+						Type: FileChanged,
 						Object: &GitObject{
 							Commits: commits,
 						},
@@ -341,7 +662,7 @@ func (w *GitWatcher) checkCommits(disableNotification bool) {
 					for _, commit := range commits {
 						event := Event{
 							Key:  "commit",
-							Type: 0,
+							Type: FileChanged,
 							Object: &GitObject{
 								Commits: []*GitCommit{commit},
 							},
@@ -377,11 +698,12 @@ func (w *GitWatcher) checkTags(disableNotification bool) {
 		return
 	}
 
+	// the new tags are created, the deleted ones are not notified
 	if disableNotification == false && len(tags) != 0 {
 		if w.config.AssembleEvents {
 			event := Event{
 				Key:  "tag",
-				Type: 0,
+				Type: FileCreated,
 				Object: &GitObject{
 					Commits: tags,
 				},
@@ -391,7 +713,7 @@ func (w *GitWatcher) checkTags(disableNotification bool) {
 			for _, tag := range tags {
 				event := Event{
 					Key:  "tag",
-					Type: 0,
+					Type: FileCreated,
 					Object: &GitObject{
 						Commits: []*GitCommit{tag},
 					},
@@ -419,8 +741,12 @@ func (w *GitWatcher) moveToBranch(branch string) error {
 }
 
 func (w *GitWatcher) updateRepo() error {
+	dir, err := w.repoDir()
+	if err != nil {
+		return err
+	}
 	// tmp dir has been deleted?!?!
-	if _, err := os.Stat(w.config.TempDir); os.IsNotExist(err) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		w.repository = nil
 	}
 
@@ -463,11 +789,21 @@ func (w *GitWatcher) updateRepo() error {
 		}
 		w.auth = opts.Auth
 
-		r, err := git.PlainClone(w.config.TempDir, false, opts)
+		if err := w.throttle(context.Background()); err != nil {
+			return fmt.Errorf("cloning repo: %s", err)
+		}
+		r, err := git.PlainClone(dir, false, opts)
 		if err != nil && err == git.ErrRepositoryAlreadyExists {
-			r, err = git.PlainOpen(w.config.TempDir)
+			r, err = git.PlainOpen(dir)
 		}
 		if err != nil {
+			if isGitCredentialError(err) {
+				w.refreshSecrets(w.applySecrets)
+				return &CredentialError{Err: fmt.Errorf("cloning repo: %s", err)}
+			}
+			if errors.Is(err, transport.ErrRepositoryNotFound) {
+				return &NotFoundError{Resource: fmt.Sprintf("repository '%s'", w.config.RepoURL), Err: err}
+			}
 			return fmt.Errorf("cloning repo: %s", err)
 		}
 		w.repository = r
@@ -479,37 +815,69 @@ func (w *GitWatcher) updateRepo() error {
 	}
 
 	// Update the repository
+	if err := w.throttle(context.Background()); err != nil {
+		return fmt.Errorf("checkout of repo '%s': %s", w.config.RepoURL, err)
+	}
 	err = wt.Pull(&git.PullOptions{
 		Auth: w.auth,
 	})
 	if err != nil && err != git.NoErrAlreadyUpToDate {
+		if isGitCredentialError(err) {
+			w.refreshSecrets(w.applySecrets)
+			return &CredentialError{Err: fmt.Errorf("checkout of repo '%s': %s", w.config.RepoURL, err)}
+		}
 		return fmt.Errorf("checkout of repo '%s': %s", w.config.RepoURL, err)
 	}
 	return nil
 }
 
-func (w *GitWatcher) enumerateFiles(prefix string, callback func(object *GitObject) bool) error {
+// headTree returns the tree of the head of the branch
+func (w *GitWatcher) headTree() (*object.Tree, error) {
 	err := w.moveToBranch(w.config.RepoBranch)
 	if err != nil {
-		return fmt.Errorf("switching branch to '%s': %s", w.config.RepoBranch, err)
+		return nil, fmt.Errorf("switching branch to '%s': %s", w.config.RepoBranch, err)
 	}
 
 	// getting head reference
 	ref, err := w.repository.Head()
 	if err != nil {
-		return fmt.Errorf("getting head reference: %s", err)
+		return nil, fmt.Errorf("getting head reference: %s", err)
 	}
 
 	// retrieve the commit pointed from head
 	commit, err := w.repository.CommitObject(ref.Hash())
 	if err != nil {
-		return fmt.Errorf("retrieving commit '%s': %s", ref.Hash().String(), err)
+		return nil, fmt.Errorf("retrieving commit '%s': %s", ref.Hash().String(), err)
 	}
 
 	// retrieve the tree from the commit
 	tree, err := commit.Tree()
 	if err != nil {
-		return fmt.Errorf("retrieving tree of '%s': %s", ref.Hash().String(), err)
+		return nil, fmt.Errorf("retrieving tree of '%s': %s", ref.Hash().String(), err)
+	}
+	return tree, nil
+}
+
+// lookupGitKey returns the file with the path in the tree for the keys mode
+func lookupGitKey(tree *object.Tree, key string) (snapshotObject, error) {
+	f, err := tree.File(key)
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &GitObject{
+		Key:      f.Name,
+		Size:     f.Size,
+		Hash:     f.Hash.String(),
+		FileMode: os.FileMode(f.Mode),
+	}, nil
+}
+
+func (w *GitWatcher) enumerateFiles(prefix string, callback func(object *GitObject) bool) error {
+	tree, err := w.headTree()
+	if err != nil {
+		return err
 	}
 
 	// iterate files in the commit
@@ -538,4 +906,5 @@ func (w *GitWatcher) enumerateFiles(prefix string, callback func(object *GitObje
 
 func init() {
 	supportedServices["git"] = newGitWatcher
+	objectTypes["git"] = func() snapshotObject { return &GitObject{} }
 }
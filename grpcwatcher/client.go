@@ -0,0 +1,264 @@
+package grpcwatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	pb "github.com/Matrix86/cloudwatcher/grpcwatcher/cloudwatcherpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Client is a Watcher receiving the events of a watcher of a remote Server. When the stream is interrupted it
+// reconnects and resumes after the last received event, the events lost in the meantime are not delivered only if
+// they are no more kept by the server.
+type Client struct {
+	cloudwatcher.WatcherBase
+
+	client  pb.WatcherServiceClient
+	name    string
+	config  *clientConfiguration
+	timeout time.Duration
+	cancel  context.CancelFunc
+	seq     uint64
+
+	mu           sync.Mutex
+	capabilities *cloudwatcher.Capabilities // capabilities of the remote watcher, nil until they are received
+}
+
+type clientConfiguration struct {
+	Ops     string `json:"ops"`     // comma separated list of event types
+	Include string `json:"include"` // comma separated list of globs
+	Exclude string `json:"exclude"` // comma separated list of globs
+
+	// delay between the reconnections
+	RetryInitial string `json:"retry_initial"`
+	RetryMax     string `json:"retry_max"`
+
+	ops     []pb.Op
+	backoff cloudwatcher.Backoff
+}
+
+var clientConfigKeys = []string{"exclude", "include", "ops", "retry_initial", "retry_max"}
+
+// NewClient creates a Watcher for the watcher with the given name on the server reachable through conn
+func NewClient(conn grpc.ClientConnInterface, name string) *Client {
+	return &Client{
+		WatcherBase: cloudwatcher.WatcherBase{
+			Events: make(chan cloudwatcher.Event, 100),
+			Errors: make(chan error, 100),
+		},
+		client:  pb.NewWatcherServiceClient(conn),
+		name:    name,
+		config:  &clientConfiguration{},
+		timeout: 10 * time.Second,
+	}
+}
+
+// SetConfig is used to configure the Client
+func (c *Client) SetConfig(m map[string]string) error {
+	if err := (cloudwatcher.Capabilities{ConfigKeys: clientConfigKeys}).CheckConfig(m); err != nil {
+		return err
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	config := clientConfiguration{}
+	if err := json.Unmarshal(j, &config); err != nil {
+		return err
+	}
+	for _, name := range splitList(config.Ops) {
+		var op cloudwatcher.Op
+		if err := op.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		config.ops = append(config.ops, pb.Op(op))
+	}
+	if config.RetryInitial != "" {
+		if config.backoff.Initial, err = time.ParseDuration(config.RetryInitial); err != nil {
+			return fmt.Errorf("wrong retry_initial: %s", err)
+		}
+	}
+	if config.RetryMax != "" {
+		if config.backoff.Max, err = time.ParseDuration(config.RetryMax); err != nil {
+			return fmt.Errorf("wrong retry_max: %s", err)
+		}
+	}
+	c.config = &config
+	return nil
+}
+
+// Start checks that the remote watcher exists and starts receiving its events from now on
+func (c *Client) Start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	st, err := c.client.GetStatus(ctx, &pb.GetStatusRequest{Watcher: c.name})
+	if err != nil {
+		return fmt.Errorf("getting status of '%s': %s", c.name, err)
+	}
+	c.seq = st.GetSequence()
+	c.setCapabilities(st)
+
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.receive(ctx)
+	return nil
+}
+
+// Close stops receiving the events, the channels are closed
+func (c *Client) Close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Status returns the status of the remote watcher, or an empty Status if the server is not reachable
+func (c *Client) Status() cloudwatcher.Status {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	st, err := c.client.GetStatus(ctx, &pb.GetStatusRequest{Watcher: c.name})
+	if err != nil {
+		return cloudwatcher.Status{}
+	}
+	c.setCapabilities(st)
+	return cloudwatcher.Status{
+		Dir:      st.GetDir(),
+		Interval: st.GetInterval().AsDuration(),
+		Syncs:    st.GetSyncs(),
+		LastSync: fromTimestamp(st.GetLastSync()),
+
+		HeldDeletes:    int(st.GetHeldDeletes()),
+		PendingDeletes: int(st.GetPendingDeletes()),
+		RateLimit:      st.GetRateLimit(),
+		RateBurst:      int(st.GetRateBurst()),
+	}
+}
+
+// Capabilities returns the capabilities of the remote watcher, received by the last call of Start or Status (or by
+// this call if they have never been received). ConfigKeys are the keys accepted by the SetConfig of the Client.
+func (c *Client) Capabilities() cloudwatcher.Capabilities {
+	c.mu.Lock()
+	received := c.capabilities
+	c.mu.Unlock()
+
+	var capabilities cloudwatcher.Capabilities
+	if received != nil {
+		capabilities = *received
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		if st, err := c.client.GetStatus(ctx, &pb.GetStatusRequest{Watcher: c.name}); err == nil {
+			capabilities = c.setCapabilities(st)
+		}
+	}
+	capabilities.ConfigKeys = clientConfigKeys
+	return capabilities
+}
+
+func (c *Client) setCapabilities(st *pb.Status) cloudwatcher.Capabilities {
+	capabilities := CapabilitiesFromProto(st.GetCapabilities())
+	c.mu.Lock()
+	c.capabilities = &capabilities
+	c.mu.Unlock()
+	return capabilities
+}
+
+// SyncNow starts a synchronization of the remote watcher, it implements cloudwatcher.Syncer
+func (c *Client) SyncNow() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.client.SyncNow(ctx, &pb.SyncNowRequest{Watcher: c.name})
+	return err
+}
+
+func (c *Client) receive(ctx context.Context) {
+	defer close(c.Errors)
+	defer close(c.Events)
+
+	attempt := 0
+	for {
+		err := c.stream(ctx, &attempt)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == io.EOF {
+			// the watcher has been closed or removed from the server
+			return
+		}
+		if !c.sendError(ctx, fmt.Errorf("receiving events of '%s': %s", c.name, err)) {
+			return
+		}
+		if status.Code(err) == codes.NotFound || status.Code(err) == codes.InvalidArgument {
+			return
+		}
+
+		attempt++
+		select {
+		case <-time.After(c.config.backoff.Duration(attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stream receives the events following the last received one, attempt is reset when the stream works again
+func (c *Client) stream(ctx context.Context, attempt *int) error {
+	after := c.seq
+	stream, err := c.client.Watch(ctx, &pb.WatchRequest{
+		Watcher:       c.name,
+		Ops:           c.config.ops,
+		Include:       splitList(c.config.Include),
+		Exclude:       splitList(c.config.Exclude),
+		AfterSequence: &after,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		*attempt = 0
+		switch m := res.GetMessage().(type) {
+		case *pb.WatchResponse_Event:
+			c.seq = res.GetSequence()
+			select {
+			case c.Events <- EventFromProto(m.Event):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case *pb.WatchResponse_Error:
+			if !c.sendError(ctx, fmt.Errorf("%s: %s", c.name, m.Error)) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (c *Client) sendError(ctx context.Context, err error) bool {
+	select {
+	case c.Errors <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
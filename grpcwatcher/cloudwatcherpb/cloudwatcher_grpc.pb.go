@@ -0,0 +1,256 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: cloudwatcher.proto
+
+package cloudwatcherpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	WatcherService_Watch_FullMethodName        = "/cloudwatcher.v1.WatcherService/Watch"
+	WatcherService_ListWatchers_FullMethodName = "/cloudwatcher.v1.WatcherService/ListWatchers"
+	WatcherService_GetStatus_FullMethodName    = "/cloudwatcher.v1.WatcherService/GetStatus"
+	WatcherService_SyncNow_FullMethodName      = "/cloudwatcher.v1.WatcherService/SyncNow"
+)
+
+// WatcherServiceClient is the client API for WatcherService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WatcherServiceClient interface {
+	// Watch streams the events of a watcher until the client cancels the call or the watcher is closed
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (WatcherService_WatchClient, error)
+	// ListWatchers returns the watchers exposed by the server
+	ListWatchers(ctx context.Context, in *ListWatchersRequest, opts ...grpc.CallOption) (*ListWatchersResponse, error)
+	// GetStatus returns the state of a watcher
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// SyncNow starts a synchronization of a watcher without waiting for the polling interval
+	SyncNow(ctx context.Context, in *SyncNowRequest, opts ...grpc.CallOption) (*SyncNowResponse, error)
+}
+
+type watcherServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWatcherServiceClient(cc grpc.ClientConnInterface) WatcherServiceClient {
+	return &watcherServiceClient{cc}
+}
+
+func (c *watcherServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (WatcherService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WatcherService_ServiceDesc.Streams[0], WatcherService_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watcherServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WatcherService_WatchClient interface {
+	Recv() (*WatchResponse, error)
+	grpc.ClientStream
+}
+
+type watcherServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *watcherServiceWatchClient) Recv() (*WatchResponse, error) {
+	m := new(WatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *watcherServiceClient) ListWatchers(ctx context.Context, in *ListWatchersRequest, opts ...grpc.CallOption) (*ListWatchersResponse, error) {
+	out := new(ListWatchersResponse)
+	err := c.cc.Invoke(ctx, WatcherService_ListWatchers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherServiceClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, WatcherService_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherServiceClient) SyncNow(ctx context.Context, in *SyncNowRequest, opts ...grpc.CallOption) (*SyncNowResponse, error) {
+	out := new(SyncNowResponse)
+	err := c.cc.Invoke(ctx, WatcherService_SyncNow_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WatcherServiceServer is the server API for WatcherService service.
+// All implementations must embed UnimplementedWatcherServiceServer
+// for forward compatibility
+type WatcherServiceServer interface {
+	// Watch streams the events of a watcher until the client cancels the call or the watcher is closed
+	Watch(*WatchRequest, WatcherService_WatchServer) error
+	// ListWatchers returns the watchers exposed by the server
+	ListWatchers(context.Context, *ListWatchersRequest) (*ListWatchersResponse, error)
+	// GetStatus returns the state of a watcher
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// SyncNow starts a synchronization of a watcher without waiting for the polling interval
+	SyncNow(context.Context, *SyncNowRequest) (*SyncNowResponse, error)
+	mustEmbedUnimplementedWatcherServiceServer()
+}
+
+// UnimplementedWatcherServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedWatcherServiceServer struct {
+}
+
+func (UnimplementedWatcherServiceServer) Watch(*WatchRequest, WatcherService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedWatcherServiceServer) ListWatchers(context.Context, *ListWatchersRequest) (*ListWatchersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWatchers not implemented")
+}
+func (UnimplementedWatcherServiceServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedWatcherServiceServer) SyncNow(context.Context, *SyncNowRequest) (*SyncNowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SyncNow not implemented")
+}
+func (UnimplementedWatcherServiceServer) mustEmbedUnimplementedWatcherServiceServer() {}
+
+// UnsafeWatcherServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WatcherServiceServer will
+// result in compilation errors.
+type UnsafeWatcherServiceServer interface {
+	mustEmbedUnimplementedWatcherServiceServer()
+}
+
+func RegisterWatcherServiceServer(s grpc.ServiceRegistrar, srv WatcherServiceServer) {
+	s.RegisterService(&WatcherService_ServiceDesc, srv)
+}
+
+func _WatcherService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatcherServiceServer).Watch(m, &watcherServiceWatchServer{stream})
+}
+
+type WatcherService_WatchServer interface {
+	Send(*WatchResponse) error
+	grpc.ServerStream
+}
+
+type watcherServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *watcherServiceWatchServer) Send(m *WatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WatcherService_ListWatchers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWatchersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServiceServer).ListWatchers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WatcherService_ListWatchers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServiceServer).ListWatchers(ctx, req.(*ListWatchersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WatcherService_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServiceServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WatcherService_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServiceServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WatcherService_SyncNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncNowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServiceServer).SyncNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WatcherService_SyncNow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServiceServer).SyncNow(ctx, req.(*SyncNowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// WatcherService_ServiceDesc is the grpc.ServiceDesc for WatcherService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WatcherService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cloudwatcher.v1.WatcherService",
+	HandlerType: (*WatcherServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListWatchers",
+			Handler:    _WatcherService_ListWatchers_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _WatcherService_GetStatus_Handler,
+		},
+		{
+			MethodName: "SyncNow",
+			Handler:    _WatcherService_SyncNow_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _WatcherService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cloudwatcher.proto",
+}
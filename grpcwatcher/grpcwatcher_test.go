@@ -0,0 +1,299 @@
+package grpcwatcher
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	pb "github.com/Matrix86/cloudwatcher/grpcwatcher/cloudwatcherpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeWatcher sends the events written on its channel
+type fakeWatcher struct {
+	cloudwatcher.WatcherBase
+}
+
+func (w *fakeWatcher) Start() error                        { return nil }
+func (w *fakeWatcher) SetConfig(c map[string]string) error { return nil }
+func (w *fakeWatcher) Close()                              {}
+
+func newFakeHub(t *testing.T) (*fakeWatcher, *cloudwatcher.Hub) {
+	w := &fakeWatcher{
+		WatcherBase: cloudwatcher.WatcherBase{
+			Events: make(chan cloudwatcher.Event, 100),
+			Errors: make(chan error, 100),
+		},
+	}
+	hub, err := cloudwatcher.NewHub(w)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := hub.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(hub.Close)
+	return w, hub
+}
+
+// testServer serves the Server on an in-memory listener that can be restarted
+type testServer struct {
+	t      *testing.T
+	server *Server
+
+	mu       sync.Mutex
+	listener *bufconn.Listener
+	grpc     *grpc.Server
+}
+
+func newTestServer(t *testing.T, s *Server) *testServer {
+	ts := &testServer{t: t, server: s}
+	ts.start()
+	t.Cleanup(func() {
+		ts.stop()
+		s.Close()
+	})
+	return ts
+}
+
+func (ts *testServer) start() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.listener = bufconn.Listen(1024 * 1024)
+	ts.grpc = grpc.NewServer()
+	pb.RegisterWatcherServiceServer(ts.grpc, ts.server)
+	go ts.grpc.Serve(ts.listener)
+}
+
+func (ts *testServer) stop() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.grpc.Stop()
+}
+
+func (ts *testServer) dial() *grpc.ClientConn {
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			ts.mu.Lock()
+			l := ts.listener
+			ts.mu.Unlock()
+			return l.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		ts.t.Fatalf("%s", err)
+	}
+	ts.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitSequence(t *testing.T, s *Server, name string, seq uint64) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		w, err := s.get(name)
+		if err == nil && w.journal.Sequence() >= seq {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("sequence %d not reached", seq)
+}
+
+func receiveEvent(t *testing.T, events chan cloudwatcher.Event) cloudwatcher.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("event not received")
+	}
+	return cloudwatcher.Event{}
+}
+
+func TestEventToProto(t *testing.T) {
+	now := time.Date(2023, 12, 1, 10, 30, 0, 0, time.UTC)
+	events := []cloudwatcher.Event{
+		{Key: "a", Type: cloudwatcher.TagsChanged, Object: &cloudwatcher.S3Object{Key: "a", Etag: "e", Size: 1, Tags: map[string]string{"k": "v"}, LastModified: now}},
+		{Key: "b", Type: cloudwatcher.FileCreated, Object: &cloudwatcher.LocalObject{Key: "b", Size: 2, LastModified: now, FileMode: 0644}},
+		{Key: "c", Type: cloudwatcher.FileChanged, Object: &cloudwatcher.GDriveObject{ID: "id", Key: "c", Size: 3, LastModified: now, Hash: "h"}},
+		{Key: "d", Type: cloudwatcher.FileDeleted, Object: &cloudwatcher.DropboxObject{Key: "d", Size: 4, LastModified: now, Hash: "h"}},
+		{Key: "e", Type: cloudwatcher.FileChanged, Object: &cloudwatcher.GitObject{Key: "e", Size: 5, FileMode: 0755, Hash: "h", Commits: []*cloudwatcher.GitCommit{
+			{Hash: "c1", Message: "msg", Branch: "main", AuthorName: "name", AuthorEmail: "mail", Time: now},
+		}}},
+		{Key: "f", Type: cloudwatcher.TagsChanged, Object: &cloudwatcher.MemoryObject{Key: "f", Size: 6, Hash: "h", Tags: map[string]string{"k": "v"}, LastModified: now}},
+		{Key: "g", Type: cloudwatcher.FileDeleted},
+	}
+	for _, e := range events {
+		m, err := EventToProto(e)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if got := EventFromProto(m); !reflect.DeepEqual(got, e) {
+			t.Errorf("wrong event: %#v instead of %#v", got, e)
+		}
+	}
+
+	if _, err := EventToProto(cloudwatcher.Event{Key: "h", Object: "wrong"}); err == nil {
+		t.Errorf("error expected for unknown objects")
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	w, hub := newFakeHub(t)
+	s := NewServer(nil)
+	if err := s.Add("fake", hub); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := s.Add("fake", hub); err == nil {
+		t.Errorf("error expected for duplicated names")
+	}
+	client := pb.NewWatcherServiceClient(newTestServer(t, s).dial())
+
+	w.Events <- cloudwatcher.Event{Key: "a.txt", Type: cloudwatcher.FileCreated}
+	w.Events <- cloudwatcher.Event{Key: "b.txt", Type: cloudwatcher.FileChanged}
+	w.Events <- cloudwatcher.Event{Key: "c.txt", Type: cloudwatcher.FileCreated}
+	waitSequence(t, s, "fake", 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	after := uint64(1)
+	stream, err := client.Watch(ctx, &pb.WatchRequest{
+		Watcher:       "fake",
+		Ops:           []pb.Op{pb.Op_FILE_CREATED},
+		AfterSequence: &after,
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the stream resumes after the event 1 and continues with the new events and errors
+	expected := []*pb.WatchResponse{
+		{Sequence: 3, Message: &pb.WatchResponse_Event{Event: &pb.Event{Key: "c.txt"}}},
+		{Sequence: 4, Message: &pb.WatchResponse_Event{Event: &pb.Event{Key: "d.txt"}}},
+		{Message: &pb.WatchResponse_Error{Error: os.ErrPermission.Error()}},
+	}
+	for i, exp := range expected {
+		switch i {
+		case 1:
+			w.Events <- cloudwatcher.Event{Key: "d.txt", Type: cloudwatcher.FileCreated}
+		case 2:
+			w.Errors <- os.ErrPermission
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if res.GetSequence() != exp.GetSequence() || res.GetEvent().GetKey() != exp.GetEvent().GetKey() || res.GetError() != exp.GetError() {
+			t.Errorf("wrong response: %v instead of %v", res, exp)
+		}
+	}
+
+	stream, _ = client.Watch(ctx, &pb.WatchRequest{Watcher: "unknown"})
+	if _, err := stream.Recv(); err == nil {
+		t.Errorf("error expected for unknown watchers")
+	}
+}
+
+func TestClient(t *testing.T) {
+	dir := t.TempDir()
+	local, err := cloudwatcher.New("local", dir, time.Hour, cloudwatcher.WithRateLimiter(cloudwatcher.NewRateLimiter(5, 2)))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	local.SetConfig(map[string]string{"disable_fsnotify": "true"})
+	hub, _ := cloudwatcher.NewHub(local)
+	if err := hub.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer hub.Close()
+
+	s := NewServer(nil)
+	s.Add("local", hub)
+	conn := newTestServer(t, s).dial()
+
+	res, err := pb.NewWatcherServiceClient(conn).ListWatchers(context.Background(), &pb.ListWatchersRequest{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(res.Watchers) != 1 || res.Watchers[0].Name != "local" || res.Watchers[0].Dir != dir {
+		t.Errorf("wrong watchers: %v", res.Watchers)
+	}
+
+	if err := NewClient(conn, "unknown").Start(); err == nil {
+		t.Errorf("error expected for unknown watchers")
+	}
+
+	c := NewClient(conn, "local")
+	if err := c.SetConfig(map[string]string{"ops": "FileCreated"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 200 && local.Status().Syncs == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the polling interval is too long, the file is found only by the requested synchronization
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("test"), 0644)
+	if err := cloudwatcher.SyncNow(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	e := receiveEvent(t, c.Events)
+	if o, ok := e.Object.(*cloudwatcher.LocalObject); !ok || e.Type != cloudwatcher.FileCreated || o.Size != 4 {
+		t.Errorf("wrong event: %#v", e)
+	}
+
+	status := c.Status()
+	if status.Dir != dir || status.Interval != time.Hour || status.Syncs < 2 || status.RateLimit != 5 || status.RateBurst != 2 {
+		t.Errorf("wrong status: %#v", status)
+	}
+
+	// the capabilities are the ones of the remote watcher, except the config keys of the client
+	caps := c.Capabilities()
+	if !caps.Tags || caps.ContentHash || caps.PushNotifications || !caps.DirectoryEvents {
+		t.Errorf("wrong capabilities: %#v", caps)
+	}
+	if err := c.SetConfig(map[string]string{"disable_fsnotify": "true"}); err == nil {
+		t.Errorf("error expected for the config keys of the remote watcher")
+	}
+}
+
+func TestClient_Resume(t *testing.T) {
+	w, hub := newFakeHub(t)
+	s := NewServer(nil)
+	s.Add("fake", hub)
+	ts := newTestServer(t, s)
+
+	c := NewClient(ts.dial(), "fake")
+	c.SetConfig(map[string]string{"retry_initial": "10ms", "retry_max": "50ms"})
+	if err := c.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+
+	w.Events <- cloudwatcher.Event{Key: "a.txt"}
+	if e := receiveEvent(t, c.Events); e.Key != "a.txt" {
+		t.Errorf("wrong event: %#v", e)
+	}
+
+	// the events sent while the server is down are received after the reconnection
+	ts.stop()
+	w.Events <- cloudwatcher.Event{Key: "b.txt"}
+	waitSequence(t, s, "fake", 2)
+	ts.start()
+
+	if e := receiveEvent(t, c.Events); e.Key != "b.txt" {
+		t.Errorf("wrong event: %#v", e)
+	}
+}
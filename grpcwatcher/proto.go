@@ -0,0 +1,189 @@
+// Package grpcwatcher exposes watchers over gRPC: the Server streams the events of one or more watchers and the
+// Client implements cloudwatcher.Watcher on top of a remote watcher. The protobuf schema and the generated code are
+// in the cloudwatcherpb package.
+package grpcwatcher
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	pb "github.com/Matrix86/cloudwatcher/grpcwatcher/cloudwatcherpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EventToProto converts the event in its protobuf message
+func EventToProto(e cloudwatcher.Event) (*pb.Event, error) {
+	m := &pb.Event{
+		Key:  e.Key,
+		Type: pb.Op(e.Type),
+	}
+	switch o := e.Object.(type) {
+	case nil:
+	case *cloudwatcher.S3Object:
+		m.Object = &pb.Event_S3{S3: &pb.S3Object{
+			Key:          o.Key,
+			Etag:         o.Etag,
+			Size:         o.Size,
+			Tags:         o.Tags,
+			LastModified: timestamp(o.LastModified),
+		}}
+	case *cloudwatcher.LocalObject:
+		m.Object = &pb.Event_Local{Local: &pb.LocalObject{
+			Key:          o.Key,
+			Size:         o.Size,
+			LastModified: timestamp(o.LastModified),
+			FileMode:     uint32(o.FileMode),
+		}}
+	case *cloudwatcher.GDriveObject:
+		m.Object = &pb.Event_Gdrive{Gdrive: &pb.GDriveObject{
+			Id:           o.ID,
+			Key:          o.Key,
+			Size:         o.Size,
+			LastModified: timestamp(o.LastModified),
+			Hash:         o.Hash,
+		}}
+	case *cloudwatcher.DropboxObject:
+		m.Object = &pb.Event_Dropbox{Dropbox: &pb.DropboxObject{
+			Key:          o.Key,
+			Size:         o.Size,
+			LastModified: timestamp(o.LastModified),
+			Hash:         o.Hash,
+		}}
+	case *cloudwatcher.GitObject:
+		git := &pb.GitObject{
+			Key:      o.Key,
+			Size:     o.Size,
+			FileMode: uint32(o.FileMode),
+			Hash:     o.Hash,
+		}
+		for _, c := range o.Commits {
+			git.Commits = append(git.Commits, &pb.GitCommit{
+				Hash:        c.Hash,
+				Message:     c.Message,
+				Branch:      c.Branch,
+				AuthorName:  c.AuthorName,
+				AuthorEmail: c.AuthorEmail,
+				Time:        timestamp(c.Time),
+			})
+		}
+		m.Object = &pb.Event_Git{Git: git}
+	case *cloudwatcher.MemoryObject:
+		m.Object = &pb.Event_Memory{Memory: &pb.MemoryObject{
+			Key:          o.Key,
+			Size:         o.Size,
+			Hash:         o.Hash,
+			Tags:         o.Tags,
+			LastModified: timestamp(o.LastModified),
+		}}
+	default:
+		return nil, fmt.Errorf("unsupported object type %T", e.Object)
+	}
+	return m, nil
+}
+
+// EventFromProto converts the protobuf message in an Event, the object is one of the pointers used by the watchers
+// (i.e. *cloudwatcher.S3Object)
+func EventFromProto(m *pb.Event) cloudwatcher.Event {
+	e := cloudwatcher.Event{
+		Key:  m.GetKey(),
+		Type: cloudwatcher.Op(m.GetType()),
+	}
+	switch o := m.GetObject().(type) {
+	case *pb.Event_S3:
+		e.Object = &cloudwatcher.S3Object{
+			Key:          o.S3.GetKey(),
+			Etag:         o.S3.GetEtag(),
+			Size:         o.S3.GetSize(),
+			Tags:         o.S3.GetTags(),
+			LastModified: fromTimestamp(o.S3.GetLastModified()),
+		}
+	case *pb.Event_Local:
+		e.Object = &cloudwatcher.LocalObject{
+			Key:          o.Local.GetKey(),
+			Size:         o.Local.GetSize(),
+			LastModified: fromTimestamp(o.Local.GetLastModified()),
+			FileMode:     os.FileMode(o.Local.GetFileMode()),
+		}
+	case *pb.Event_Gdrive:
+		e.Object = &cloudwatcher.GDriveObject{
+			ID:           o.Gdrive.GetId(),
+			Key:          o.Gdrive.GetKey(),
+			Size:         o.Gdrive.GetSize(),
+			LastModified: fromTimestamp(o.Gdrive.GetLastModified()),
+			Hash:         o.Gdrive.GetHash(),
+		}
+	case *pb.Event_Dropbox:
+		e.Object = &cloudwatcher.DropboxObject{
+			Key:          o.Dropbox.GetKey(),
+			Size:         o.Dropbox.GetSize(),
+			LastModified: fromTimestamp(o.Dropbox.GetLastModified()),
+			Hash:         o.Dropbox.GetHash(),
+		}
+	case *pb.Event_Git:
+		git := &cloudwatcher.GitObject{
+			Key:      o.Git.GetKey(),
+			Size:     o.Git.GetSize(),
+			FileMode: os.FileMode(o.Git.GetFileMode()),
+			Hash:     o.Git.GetHash(),
+		}
+		for _, c := range o.Git.GetCommits() {
+			git.Commits = append(git.Commits, &cloudwatcher.GitCommit{
+				Hash:        c.GetHash(),
+				Message:     c.GetMessage(),
+				Branch:      c.GetBranch(),
+				AuthorName:  c.GetAuthorName(),
+				AuthorEmail: c.GetAuthorEmail(),
+				Time:        fromTimestamp(c.GetTime()),
+			})
+		}
+		e.Object = git
+	case *pb.Event_Memory:
+		e.Object = &cloudwatcher.MemoryObject{
+			Key:          o.Memory.GetKey(),
+			Size:         o.Memory.GetSize(),
+			Hash:         o.Memory.GetHash(),
+			Tags:         o.Memory.GetTags(),
+			LastModified: fromTimestamp(o.Memory.GetLastModified()),
+		}
+	}
+	return e
+}
+
+// CapabilitiesToProto converts the capabilities of a watcher in their protobuf message
+func CapabilitiesToProto(c cloudwatcher.Capabilities) *pb.Capabilities {
+	return &pb.Capabilities{
+		Tags:              c.Tags,
+		ContentHash:       c.ContentHash,
+		PushNotifications: c.PushNotifications,
+		DirectoryEvents:   c.DirectoryEvents,
+		ConfigKeys:        c.ConfigKeys,
+	}
+}
+
+// CapabilitiesFromProto converts the protobuf message in the capabilities of a watcher
+func CapabilitiesFromProto(m *pb.Capabilities) cloudwatcher.Capabilities {
+	return cloudwatcher.Capabilities{
+		Tags:              m.GetTags(),
+		ContentHash:       m.GetContentHash(),
+		PushNotifications: m.GetPushNotifications(),
+		DirectoryEvents:   m.GetDirectoryEvents(),
+		ConfigKeys:        m.GetConfigKeys(),
+	}
+}
+
+// timestamp returns nil for the zero time, so that it is not confused with the Unix epoch
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func fromTimestamp(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
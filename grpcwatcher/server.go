@@ -0,0 +1,219 @@
+package grpcwatcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Matrix86/cloudwatcher"
+	pb "github.com/Matrix86/cloudwatcher/grpcwatcher/cloudwatcherpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ServerConfig contains the options of the Server
+type ServerConfig struct {
+	// History is the number of events kept for each watcher to resume the streams (default 1000)
+	History int
+}
+
+// Server implements the WatcherService for the watchers added with Add, register it with
+// cloudwatcherpb.RegisterWatcherServiceServer
+type Server struct {
+	pb.UnimplementedWatcherServiceServer
+
+	config   ServerConfig
+	mu       sync.RWMutex
+	watchers map[string]*exposedWatcher
+}
+
+type exposedWatcher struct {
+	watcher cloudwatcher.Watcher
+	journal *cloudwatcher.Journal
+}
+
+// NewServer creates a Server without watchers
+func NewServer(c *ServerConfig) *Server {
+	config := ServerConfig{}
+	if c != nil {
+		config = *c
+	}
+	return &Server{
+		config:   config,
+		watchers: make(map[string]*exposedWatcher),
+	}
+}
+
+// Add exposes the watcher of the hub with the given name. The events are numbered and kept from now on, the
+// streams of the watcher end when the hub is closed or the watcher is removed.
+func (s *Server) Add(name string, hub *cloudwatcher.Hub) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.watchers[name]; ok {
+		return fmt.Errorf("watcher '%s' already exists", name)
+	}
+	s.watchers[name] = &exposedWatcher{
+		watcher: hub.Watcher(),
+		journal: cloudwatcher.NewJournal(hub, s.config.History),
+	}
+	return nil
+}
+
+// Remove stops exposing the watcher, it doesn't close the hub
+func (s *Server) Remove(name string) {
+	s.mu.Lock()
+	w, ok := s.watchers[name]
+	delete(s.watchers, name)
+	s.mu.Unlock()
+	if ok {
+		w.journal.Close()
+	}
+}
+
+// Close removes all the watchers
+func (s *Server) Close() {
+	s.mu.Lock()
+	watchers := s.watchers
+	s.watchers = make(map[string]*exposedWatcher)
+	s.mu.Unlock()
+	for _, w := range watchers {
+		w.journal.Close()
+	}
+}
+
+func (s *Server) get(name string) (*exposedWatcher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.watchers[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "watcher '%s' not found", name)
+	}
+	return w, nil
+}
+
+// Watch streams the events of a watcher accepted by the filters of the request
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.WatcherService_WatchServer) error {
+	w, err := s.get(req.GetWatcher())
+	if err != nil {
+		return err
+	}
+	filter := &cloudwatcher.Filter{
+		Include: req.GetInclude(),
+		Exclude: req.GetExclude(),
+	}
+	for _, op := range req.GetOps() {
+		if _, ok := pb.Op_name[int32(op)]; !ok {
+			return status.Errorf(codes.InvalidArgument, "unknown event type %d", op)
+		}
+		filter.Ops = append(filter.Ops, cloudwatcher.Op(op))
+	}
+	if err := filter.Check(w.watcher.Capabilities()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	after := w.journal.Sequence()
+	if req.AfterSequence != nil {
+		after = req.GetAfterSequence()
+	}
+	_, lastErrorTime := w.journal.LastError()
+	for {
+		entries, notify, done, gap := w.journal.Since(after)
+		if gap {
+			// the events following after are lost: a CacheReset not filtered asks the client to reload the objects
+			reset := &pb.WatchResponse{Message: &pb.WatchResponse_Event{Event: &pb.Event{Type: pb.Op_CACHE_RESET}}}
+			if len(entries) != 0 {
+				reset.Sequence = entries[0].Seq - 1
+			}
+			if err := stream.Send(reset); err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			after = e.Seq
+			if !filter.Match(e.Event) {
+				continue
+			}
+			m, err := EventToProto(e.Event)
+			if err != nil {
+				return status.Errorf(codes.Internal, "encoding event '%s': %s", e.Event.Key, err)
+			}
+			if err := stream.Send(&pb.WatchResponse{Sequence: e.Seq, Message: &pb.WatchResponse_Event{Event: m}}); err != nil {
+				return err
+			}
+		}
+
+		if lastError, t := w.journal.LastError(); t.After(lastErrorTime) {
+			lastErrorTime = t
+			if err := stream.Send(&pb.WatchResponse{Message: &pb.WatchResponse_Error{Error: lastError}}); err != nil {
+				return err
+			}
+		}
+		if done && len(entries) == 0 {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// ListWatchers returns the exposed watchers sorted by name
+func (s *Server) ListWatchers(ctx context.Context, req *pb.ListWatchersRequest) (*pb.ListWatchersResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := &pb.ListWatchersResponse{}
+	for name, w := range s.watchers {
+		res.Watchers = append(res.Watchers, &pb.WatcherInfo{
+			Name:   name,
+			Dir:    w.watcher.Status().Dir,
+			Source: cloudwatcher.CloudEventSource(w.watcher),
+		})
+	}
+	sort.Slice(res.Watchers, func(i, j int) bool {
+		return res.Watchers[i].Name < res.Watchers[j].Name
+	})
+	return res, nil
+}
+
+// GetStatus returns the status of a watcher
+func (s *Server) GetStatus(ctx context.Context, req *pb.GetStatusRequest) (*pb.Status, error) {
+	w, err := s.get(req.GetWatcher())
+	if err != nil {
+		return nil, err
+	}
+	st := w.watcher.Status()
+	res := &pb.Status{
+		Dir:      st.Dir,
+		Interval: durationpb.New(st.Interval),
+		Syncs:    st.Syncs,
+		LastSync: timestamp(st.LastSync),
+		Sequence: w.journal.Sequence(),
+
+		HeldDeletes:    int64(st.HeldDeletes),
+		PendingDeletes: int64(st.PendingDeletes),
+		RateLimit:      st.RateLimit,
+		RateBurst:      int64(st.RateBurst),
+		Capabilities:   CapabilitiesToProto(w.watcher.Capabilities()),
+	}
+	lastError, lastErrorTime := w.journal.LastError()
+	res.LastError = lastError
+	res.LastErrorTime = timestamp(lastErrorTime)
+	return res, nil
+}
+
+// SyncNow starts a synchronization of a watcher
+func (s *Server) SyncNow(ctx context.Context, req *pb.SyncNowRequest) (*pb.SyncNowResponse, error) {
+	w, err := s.get(req.GetWatcher())
+	if err != nil {
+		return nil, err
+	}
+	if err := cloudwatcher.SyncNow(w.watcher); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+	}
+	return &pb.SyncNowResponse{}, nil
+}
@@ -0,0 +1,155 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// guardConfiguration contains the options checking the deletions, shared by the configurations of all the
+// watchers. A synchronization deleting more than MaxDeletes objects, or more than MaxDeleteRatio of the cached ones,
+// is suspicious (e.g. a truncated listing); zero disables the limit. An object is deleted only if it's missing from
+// DeleteConfirmations consecutive synchronizations, or if the service confirms it doesn't exist.
+type guardConfiguration struct {
+	MaxDeletes          int     `json:"max_deletes,string"`
+	MaxDeleteRatio      float64 `json:"max_delete_ratio,string"`
+	DeleteConfirmations int     `json:"delete_confirmations,string"`
+}
+
+func (c guardConfiguration) validate() error {
+	if c.MaxDeletes < 0 {
+		return fmt.Errorf("max_deletes can't be negative")
+	}
+	if c.MaxDeleteRatio < 0 || c.MaxDeleteRatio > 1 {
+		return fmt.Errorf("max_delete_ratio has to be between 0 and 1")
+	}
+	if c.DeleteConfirmations < 0 {
+		return fmt.Errorf("delete_confirmations can't be negative")
+	}
+	return nil
+}
+
+// trips returns true if the deletions exceed the limits
+func (c guardConfiguration) trips(deletes, cached int) bool {
+	return (c.MaxDeletes > 0 && deletes > c.MaxDeletes) ||
+		(c.MaxDeleteRatio > 0 && float64(deletes) > c.MaxDeleteRatio*float64(cached))
+}
+
+// SuspiciousSyncError is sent on the errors channel when a synchronization would delete too many objects. The
+// deletions are held back, and the objects kept in the cache, until the next synchronization misses the same objects
+// or ConfirmDeletes is called.
+type SuspiciousSyncError struct {
+	Deletes int // objects not found
+	Cached  int // objects in the cache
+}
+
+func (e *SuspiciousSyncError) Error() string {
+	return fmt.Sprintf("suspicious sync: %d of %d objects not found, the deletions are held back", e.Deletes, e.Cached)
+}
+
+// deleteConfirmer is implemented by the watchers with the mass-deletion guard
+type deleteConfirmer interface {
+	confirmDeletes() error
+}
+
+// ConfirmDeletes sends the deletions held back by the mass-deletion guard with a new synchronization
+func ConfirmDeletes(w Watcher) error {
+	c, ok := w.(deleteConfirmer)
+	if !ok {
+		return fmt.Errorf("the watcher doesn't support the confirmation of the deletions")
+	}
+	if err := c.confirmDeletes(); err != nil {
+		return err
+	}
+	return SyncNow(w)
+}
+
+// confirmDeletes disables the guard for the next synchronization if it is holding back deletions
+func (w *WatcherBase) confirmDeletes() error {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	if len(w.heldDeletes) == 0 {
+		return fmt.Errorf("no deletions held back")
+	}
+	w.deletesConfirmed = true
+	return nil
+}
+
+// existsFunc checks directly if the object with the cache key exists
+type existsFunc func(key string) (bool, error)
+
+// confirmMissing is called holding the cycle with the keys of the cached objects not found by a synchronization, it
+// returns the ones confirmed as deleted. The other ones are pending tombstones and are kept in the cache: they are
+// confirmed when missing from delete_confirmations consecutive synchronizations, or if exists (when not nil) doesn't
+// find them.
+func (w *WatcherBase) confirmMissing(config guardConfiguration, missing []string, exists existsFunc) []string {
+	if config.DeleteConfirmations <= 1 {
+		w.tombstones = nil
+		w.setPendingDeletes(0)
+		return missing
+	}
+
+	tombstones := make(map[string]int, len(missing))
+	confirmed := make([]string, 0, len(missing))
+	for _, k := range missing {
+		n := w.tombstones[k] + 1
+		if n < config.DeleteConfirmations && exists != nil {
+			found, err := exists(k)
+			if err == nil && found {
+				// the listing missed an existing object
+				continue
+			}
+			if err == nil {
+				n = config.DeleteConfirmations
+			}
+		}
+		// the confirmed ones are kept until they are removed from the cache, in case the deletions are held back
+		tombstones[k] = n
+		if n >= config.DeleteConfirmations {
+			confirmed = append(confirmed, k)
+		}
+	}
+	w.tombstones = tombstones
+	w.setPendingDeletes(len(tombstones) - len(confirmed))
+	return confirmed
+}
+
+func (w *WatcherBase) setPendingDeletes(n int) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.pendingCount = n
+}
+
+// checkDeletes is called holding the cycle with the sorted keys of the cached objects not found by a
+// synchronization, it returns true if their deletions can be sent
+func (w *WatcherBase) checkDeletes(config guardConfiguration, deleted []string, cached int) bool {
+	sort.Strings(deleted)
+	held := w.heldDeletes
+	confirmed := w.deletesConfirmed
+	w.heldDeletes = nil
+	w.deletesConfirmed = false
+	defer func() {
+		w.statusMu.Lock()
+		w.heldCount = len(w.heldDeletes)
+		w.statusMu.Unlock()
+	}()
+
+	if confirmed || !config.trips(len(deleted), cached) || sameKeys(held, deleted) {
+		return true
+	}
+	w.heldDeletes = deleted
+	w.Errors <- &SuspiciousSyncError{Deletes: len(deleted), Cached: cached}
+	return false
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
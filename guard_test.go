@@ -0,0 +1,224 @@
+package cloudwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/minio/minio-go/v7"
+)
+
+var guardedObject = &MemoryObject{Size: 1, LastModified: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)}
+
+// newGuardedWatcher returns a running memory watcher with the objects a, b, c and d
+func newGuardedWatcher(t *testing.T, config map[string]string) *MemoryWatcher {
+	w := newTestMemoryWatcher(t, time.Minute, config)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		w.Put(k, guardedObject)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(w.Close)
+	waitSyncs(t, w, 1)
+	return w
+}
+
+func expectSuspiciousSync(t *testing.T, w Watcher, deletes, cached int) {
+	t.Helper()
+	select {
+	case err := <-w.GetErrors():
+		var suspicious *SuspiciousSyncError
+		if !errors.As(err, &suspicious) || suspicious.Deletes != deletes || suspicious.Cached != cached {
+			t.Errorf("wrong error returned: %v", err)
+		}
+	default:
+		t.Errorf("SuspiciousSyncError not received")
+	}
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("the deletions should be held back: %v", events)
+	}
+	if held := w.Status().HeldDeletes; held != deletes {
+		t.Errorf("wrong number of held deletions: %d", held)
+	}
+}
+
+func TestMemoryWatcher_DeleteGuard(t *testing.T) {
+	w := newGuardedWatcher(t, map[string]string{"max_delete_ratio": "0.5"})
+
+	// below the limit
+	w.Delete("a")
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Key != "a" || events[0].Type != FileDeleted {
+		t.Fatalf("wrong events: %v", events)
+	}
+
+	// a transient empty listing is ignored
+	w.Delete("b")
+	w.Delete("c")
+	w.Delete("d")
+	advance(t, w, time.Minute)
+	expectSuspiciousSync(t, w, 3, 3)
+	w.Put("b", guardedObject)
+	w.Put("c", guardedObject)
+	w.Put("d", guardedObject)
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("the objects are in the cache: %v", events)
+	}
+	if held := w.Status().HeldDeletes; held != 0 {
+		t.Errorf("wrong number of held deletions: %d", held)
+	}
+
+	// the deletions are sent if the next synchronization confirms them
+	w.Delete("b")
+	w.Delete("c")
+	w.Delete("d")
+	advance(t, w, time.Minute)
+	expectSuspiciousSync(t, w, 3, 3)
+	advance(t, w, time.Minute)
+	events := pendingEvents(w)
+	if len(events) != 3 || events[0].Key != "b" || events[1].Key != "c" || events[2].Key != "d" {
+		t.Fatalf("wrong events: %v", events)
+	}
+}
+
+func TestConfirmDeletes(t *testing.T) {
+	w := newGuardedWatcher(t, map[string]string{"max_deletes": "1"})
+	if err := ConfirmDeletes(w); err == nil {
+		t.Errorf("no deletions are held back")
+	}
+
+	w.Delete("a")
+	w.Delete("b")
+	advance(t, w, time.Minute)
+	expectSuspiciousSync(t, w, 2, 4)
+	if err := ConfirmDeletes(w); err != nil {
+		t.Fatalf("%s", err)
+	}
+	waitSyncs(t, w, 3)
+	if events := pendingEvents(w); len(events) != 2 || events[0].Type != FileDeleted || events[1].Type != FileDeleted {
+		t.Fatalf("wrong events: %v", events)
+	}
+	if held := w.Status().HeldDeletes; held != 0 {
+		t.Errorf("wrong number of held deletions: %d", held)
+	}
+
+	for _, config := range []map[string]string{
+		{"max_delete_ratio": "2"},
+		{"max_delete_ratio": "x"},
+		{"max_deletes": "-1"},
+	} {
+		if err := w.SetConfig(config); err == nil {
+			t.Errorf("the config %v is not valid", config)
+		}
+	}
+}
+
+func TestS3Watcher_ListingError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			out := make(chan minio.ObjectInfo, 1)
+			out <- minio.ObjectInfo{Err: fmt.Errorf("page not available")}
+			close(out)
+			return out
+		},
+	)
+
+	w, _ := newS3Watcher("/", time.Minute)
+	sw := w.(*S3Watcher)
+	if err := sw.SetConfig(map[string]string{"bucket_name": "test", "endpoint": "localhost:9000"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.client = m
+	sw.cache["filename.test"] = &S3Object{Key: "filename.test"}
+
+	// a partial listing doesn't delete the cached objects
+	sw.sync(false)
+	if err := <-sw.GetErrors(); !strings.Contains(err.Error(), "page not available") {
+		t.Errorf("wrong error returned: %s", err)
+	}
+	if events := pendingEvents(sw); len(events) != 0 {
+		t.Errorf("unexpected events: %v", events)
+	}
+	if _, ok := sw.cache["filename.test"]; !ok {
+		t.Errorf("the cache should be kept")
+	}
+}
+
+func TestMemoryWatcher_DeleteConfirmations(t *testing.T) {
+	w := newGuardedWatcher(t, map[string]string{"delete_confirmations": "3"})
+
+	// an object missing from a single listing is not deleted
+	w.Delete("a")
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("the deletion should be pending: %v", events)
+	}
+	if pending := w.Status().PendingDeletes; pending != 1 {
+		t.Errorf("wrong number of pending deletions: %d", pending)
+	}
+	w.Put("a", guardedObject)
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("the object is in the cache: %v", events)
+	}
+	if pending := w.Status().PendingDeletes; pending != 0 {
+		t.Errorf("wrong number of pending deletions: %d", pending)
+	}
+
+	// deleted after 3 consecutive synchronizations
+	w.Delete("a")
+	advance(t, w, time.Minute)
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("the deletion should be pending: %v", events)
+	}
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Key != "a" || events[0].Type != FileDeleted {
+		t.Errorf("wrong events: %v", events)
+	}
+}
+
+func TestS3Watcher_DeleteConfirmations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			out := make(chan minio.ObjectInfo)
+			close(out)
+			return out
+		},
+	)
+	// the listing missed "exists", "deleted" has been deleted
+	m.EXPECT().StatObject(gomock.Any(), "test", "exists", gomock.Any()).Return(minio.ObjectInfo{Key: "exists"}, nil)
+	m.EXPECT().StatObject(gomock.Any(), "test", "deleted", gomock.Any()).Return(minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"})
+
+	w, _ := newS3Watcher("/", time.Minute)
+	sw := w.(*S3Watcher)
+	if err := sw.SetConfig(map[string]string{"bucket_name": "test", "endpoint": "localhost:9000", "delete_confirmations": "5"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.client = m
+	sw.cache["exists"] = &S3Object{Key: "exists"}
+	sw.cache["deleted"] = &S3Object{Key: "deleted"}
+
+	sw.sync(false)
+	if events := pendingEvents(sw); len(events) != 1 || events[0].Key != "deleted" || events[0].Type != FileDeleted {
+		t.Errorf("wrong events: %v", events)
+	}
+	if _, ok := sw.cache["exists"]; !ok {
+		t.Errorf("the existing object should be kept in the cache")
+	}
+}
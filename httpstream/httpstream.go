@@ -0,0 +1,309 @@
+// Package httpstream implements an http.Handler streaming the events of a watcher as Server-Sent Events and over
+// WebSocket, with the endpoints for the status of the watcher and the listing of its objects.
+package httpstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/gorilla/websocket"
+)
+
+// Config contains the options of the Server
+type Config struct {
+	// History is the number of events kept to resume the streams with Last-Event-ID (default 1000)
+	History int
+	// KeepAlive is the interval of the keep-alive messages sent to idle streams (default 15s)
+	KeepAlive time.Duration
+	// CheckOrigin returns true if the WebSocket connection can be accepted (default: same origin only)
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Server is an http.Handler exposing the events of a Hub. It serves:
+//
+//	GET /events    Server-Sent Events stream
+//	GET /ws        WebSocket stream
+//	GET /status    status of the watcher
+//	GET /snapshot  snapshot of all the objects of the watched directory
+//
+// The streams accept the filters op (event's type) and key (glob of the key), both repeatable. They start with the
+// next event, or they resume after the sequence number in the Last-Event-ID header or the last_event_id parameter.
+type Server struct {
+	config   Config
+	watcher  cloudwatcher.Watcher
+	journal  *cloudwatcher.Journal
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients int
+}
+
+// wsMessage is the message sent for each event on the WebSocket streams
+type wsMessage struct {
+	ID    uint64             `json:"id"`
+	Event cloudwatcher.Event `json:"event"`
+}
+
+type statusResponse struct {
+	Dir           string     `json:"dir"`
+	Interval      string     `json:"interval"`
+	Syncs         uint64     `json:"syncs"`
+	LastSync      *time.Time `json:"last_sync,omitempty"`
+	Sequence      uint64     `json:"sequence"`
+	Clients       int        `json:"clients"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// New creates a Server streaming the events of the hub. The events are numbered and kept from now on, the
+// Server stops when the hub is closed or when Close is called.
+func New(hub *cloudwatcher.Hub, c *Config) *Server {
+	config := Config{}
+	if c != nil {
+		config = *c
+	}
+	if config.KeepAlive <= 0 {
+		config.KeepAlive = 15 * time.Second
+	}
+
+	s := &Server{
+		config:  config,
+		watcher: hub.Watcher(),
+		journal: cloudwatcher.NewJournal(hub, config.History),
+		mux:     http.NewServeMux(),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: config.CheckOrigin,
+		},
+	}
+	s.mux.HandleFunc("/events", s.handleSSE)
+	s.mux.HandleFunc("/ws", s.handleWebSocket)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/snapshot", s.handleSnapshot)
+	return s
+}
+
+// ServeHTTP implements http.Handler, use http.StripPrefix to mount it on a path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close ends the subscription to the hub and all the streams
+func (s *Server) Close() {
+	s.journal.Close()
+}
+
+// stream sends the events following the sequence number after and accepted by the filter until the context is
+// cancelled or the Server is closed
+func (s *Server) stream(ctx context.Context, after uint64, filter *cloudwatcher.Filter, send func(cloudwatcher.JournalEntry) error, ping func() error) error {
+	s.mu.Lock()
+	s.clients++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.clients--
+		s.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(s.config.KeepAlive)
+	defer ticker.Stop()
+	for {
+		entries, notify, done, gap := s.journal.Since(after)
+		if gap {
+			// the events following after are lost: a CacheReset not filtered asks the client to reload the objects
+			if err := send(resetEntry(entries)); err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			after = e.Seq
+			if filter.Match(e.Event) {
+				if err := send(e); err != nil {
+					return err
+				}
+			}
+		}
+		if done && len(entries) == 0 {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// resetEntry returns the CacheReset sent when the stream can't be resumed, its sequence number precedes the entries
+func resetEntry(entries []cloudwatcher.JournalEntry) cloudwatcher.JournalEntry {
+	e := cloudwatcher.JournalEntry{Event: cloudwatcher.Event{Type: cloudwatcher.CacheReset}}
+	if len(entries) != 0 {
+		e.Seq = entries[0].Seq - 1
+	}
+	return e
+}
+
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	filter, after, err := s.parseStreamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.stream(r.Context(), after, filter, func(e cloudwatcher.JournalEntry) error {
+		j, err := json.Marshal(e.Event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.Seq, j); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, after, err := s.parseStreamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the error has been already sent to the client
+		return
+	}
+	defer conn.Close()
+
+	// the messages of the client are discarded, reading is needed to detect the closed connections
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = s.stream(ctx, after, filter, func(e cloudwatcher.JournalEntry) error {
+		return conn.WriteJSON(wsMessage{ID: e.Seq, Event: e.Event})
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+	})
+	if err == nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.watcher.Status()
+	res := statusResponse{
+		Dir:      status.Dir,
+		Interval: status.Interval.String(),
+		Syncs:    status.Syncs,
+	}
+	if !status.LastSync.IsZero() {
+		res.LastSync = &status.LastSync
+	}
+
+	res.Sequence = s.journal.Sequence()
+	s.mu.Lock()
+	res.Clients = s.clients
+	s.mu.Unlock()
+	lastError, lastErrorTime := s.journal.LastError()
+	if lastError != "" {
+		res.LastError = lastError
+		res.LastErrorTime = &lastErrorTime
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := cloudwatcher.NewSnapshot(s.watcher)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, snapshot)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(j, '\n'))
+}
+
+// parseStreamRequest returns the filter and the last received sequence number of the stream request
+func (s *Server) parseStreamRequest(r *http.Request) (*cloudwatcher.Filter, uint64, error) {
+	query := r.URL.Query()
+	filter := &cloudwatcher.Filter{
+		Include: query["key"],
+		Exclude: query["exclude"],
+	}
+	for _, value := range query["op"] {
+		for _, name := range strings.Split(value, ",") {
+			var op cloudwatcher.Op
+			if err := op.UnmarshalText([]byte(name)); err != nil {
+				return nil, 0, err
+			}
+			filter.Ops = append(filter.Ops, op)
+		}
+	}
+	if err := filter.Check(s.watcher.Capabilities()); err != nil {
+		return nil, 0, err
+	}
+
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = query.Get("last_event_id")
+	}
+	if id == "" {
+		return filter, s.journal.Sequence(), nil
+	}
+	after, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("wrong last event id '%s'", id)
+	}
+	return filter, after, nil
+}
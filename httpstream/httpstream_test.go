@@ -0,0 +1,186 @@
+package httpstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/gorilla/websocket"
+)
+
+// fakeWatcher sends the events written on its channel
+type fakeWatcher struct {
+	cloudwatcher.WatcherBase
+}
+
+func (w *fakeWatcher) Start() error                        { return nil }
+func (w *fakeWatcher) SetConfig(c map[string]string) error { return nil }
+func (w *fakeWatcher) Close()                              {}
+
+func newTestServer(t *testing.T) (*fakeWatcher, *Server, *httptest.Server) {
+	w := &fakeWatcher{
+		WatcherBase: cloudwatcher.WatcherBase{
+			Events: make(chan cloudwatcher.Event, 100),
+			Errors: make(chan error, 100),
+		},
+	}
+	hub, err := cloudwatcher.NewHub(w)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	hub.Start()
+	s := New(hub, nil)
+	srv := httptest.NewServer(s)
+	t.Cleanup(func() {
+		srv.Close()
+		hub.Close()
+	})
+	return w, s, srv
+}
+
+func waitSequence(t *testing.T, s *Server, seq uint64) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if s.journal.Sequence() >= seq {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("sequence %d not reached", seq)
+}
+
+func TestServer_SSE(t *testing.T) {
+	w, s, srv := newTestServer(t)
+	w.Events <- cloudwatcher.Event{Key: "a.txt", Type: cloudwatcher.FileCreated}
+	w.Events <- cloudwatcher.Event{Key: "b.txt", Type: cloudwatcher.FileChanged}
+	w.Events <- cloudwatcher.Event{Key: "c.txt", Type: cloudwatcher.FileCreated}
+	waitSequence(t, s, 3)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?op=FileCreated", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("wrong content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	// the stream resumes after the event 1 and continues with the new events
+	w.Events <- cloudwatcher.Event{Key: "d.txt", Type: cloudwatcher.FileCreated}
+
+	lines := bufio.NewScanner(res.Body)
+	expected := []string{"id: 3", `data: {"key":"c.txt","type":"FileCreated","object":null}`, "", "id: 4"}
+	for _, line := range expected {
+		if !lines.Scan() {
+			t.Fatalf("stream closed: %s", lines.Err())
+		}
+		if lines.Text() != line {
+			t.Errorf("wrong line: '%s' instead of '%s'", lines.Text(), line)
+		}
+	}
+
+	res, _ = http.Get(srv.URL + "/events?op=wrong")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong status: %d", res.StatusCode)
+	}
+}
+
+func TestServer_SSEGap(t *testing.T) {
+	w, s, srv := newTestServer(t)
+	w.Events <- cloudwatcher.Event{Key: "a.txt", Type: cloudwatcher.FileCreated}
+	waitSequence(t, s, 1)
+
+	// the server has been restarted: the events after 10 are lost
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?op=FileCreated", nil)
+	req.Header.Set("Last-Event-ID", "10")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer res.Body.Close()
+
+	lines := bufio.NewScanner(res.Body)
+	expected := []string{"id: 0", `data: {"key":"","type":"CacheReset","object":null}`, "", "id: 1"}
+	for _, line := range expected {
+		if !lines.Scan() {
+			t.Fatalf("stream closed: %s", lines.Err())
+		}
+		if lines.Text() != line {
+			t.Errorf("wrong line: '%s' instead of '%s'", lines.Text(), line)
+		}
+	}
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	w, _, srv := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?key=*.txt", nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer conn.Close()
+
+	w.Events <- cloudwatcher.Event{Key: "a.csv", Type: cloudwatcher.FileCreated}
+	w.Events <- cloudwatcher.Event{Key: "b.txt", Type: cloudwatcher.FileDeleted}
+
+	msg := wsMessage{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if msg.ID != 2 || msg.Event.Key != "b.txt" || msg.Event.Type != cloudwatcher.FileDeleted {
+		t.Errorf("wrong message: %#v", msg)
+	}
+}
+
+func TestServer_StatusSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("test"), 0644)
+
+	w, err := cloudwatcher.New("local", dir, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	w.SetConfig(map[string]string{"disable_fsnotify": "true"})
+	hub, _ := cloudwatcher.NewHub(w)
+	if err := hub.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer hub.Close()
+	srv := httptest.NewServer(New(hub, nil))
+	defer srv.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	status := statusResponse{}
+	res, err := http.Get(srv.URL + "/status")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	if status.Dir != dir || status.Interval != "20ms" || status.Syncs == 0 || status.LastSync == nil {
+		t.Errorf("wrong status: %#v", status)
+	}
+
+	snapshot := &cloudwatcher.Snapshot{}
+	res, err = http.Get(srv.URL + "/snapshot")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := json.NewDecoder(res.Body).Decode(snapshot); err != nil {
+		t.Fatalf("%s", err)
+	}
+	res.Body.Close()
+	if snapshot.Service != "local" || snapshot.Len() != 1 {
+		t.Errorf("wrong snapshot: %#v", snapshot)
+	}
+}
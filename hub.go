@@ -0,0 +1,282 @@
+package cloudwatcher
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Backpressure defines what happens when the buffer of a subscription is full
+type Backpressure int
+
+// backpressure policies
+const (
+	// Block waits until the subscriber reads the event (it slows down the delivery to all the subscribers)
+	Block Backpressure = iota
+	// DropNewest discards the event that doesn't fit in the buffer
+	DropNewest
+	// DropOldest discards the oldest event in the buffer to make room for the new one
+	DropOldest
+)
+
+// SubscribeOptions contains the options of a subscription
+type SubscribeOptions struct {
+	Buffer       int          // size of the events buffer (default 100)
+	Backpressure Backpressure // policy applied when the buffer is full (default Block)
+}
+
+// Hub delivers the events of a watcher to many independent subscribers
+type Hub struct {
+	watcher Watcher
+	stop    chan bool
+
+	mu          sync.RWMutex
+	nextID      uint64
+	subscribers map[uint64]*Subscription
+	closed      bool
+}
+
+// Subscription receives all the events of the Hub accepted by its filter
+type Subscription struct {
+	id     uint64
+	hub    *Hub
+	filter *Filter
+	policy Backpressure
+
+	events  chan Event
+	errors  chan error
+	dropped uint64
+
+	mu     sync.Mutex
+	done   chan bool
+	once   sync.Once
+	closed bool
+}
+
+// NewHub creates a Hub for the watcher w
+func NewHub(w Watcher) (*Hub, error) {
+	if w == nil {
+		return nil, fmt.Errorf("watcher needed")
+	}
+	return &Hub{
+		watcher:     w,
+		stop:        make(chan bool),
+		subscribers: make(map[uint64]*Subscription),
+	}, nil
+}
+
+// Start starts the wrapped watcher and the delivery of the events to the subscribers
+func (h *Hub) Start() error {
+	if err := h.watcher.Start(); err != nil {
+		return err
+	}
+	go h.dispatch()
+	return nil
+}
+
+// Close stops the wrapped watcher and closes all the subscriptions
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	h.mu.Unlock()
+
+	close(h.stop)
+	h.watcher.Close()
+	h.closeSubscriptions()
+}
+
+// Subscribe returns a new subscription receiving the events accepted by filter (nil accepts everything).
+// It can be called at any time, also while the watcher is running.
+func (h *Hub) Subscribe(filter *Filter, opts *SubscribeOptions) *Subscription {
+	o := SubscribeOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Buffer <= 0 {
+		o.Buffer = 100
+	}
+
+	s := &Subscription{
+		hub:    h,
+		filter: filter,
+		policy: o.Backpressure,
+		events: make(chan Event, o.Buffer),
+		errors: make(chan error, o.Buffer),
+		done:   make(chan bool),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.close()
+		return s
+	}
+	h.nextID++
+	s.id = h.nextID
+	h.subscribers[s.id] = s
+	return s
+}
+
+// Watcher returns the watcher wrapped by the Hub
+func (h *Hub) Watcher() Watcher {
+	return h.watcher
+}
+
+// Subscribers returns the number of active subscriptions
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) dispatch() {
+	events := h.watcher.GetEvents()
+	errors := h.watcher.GetErrors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			for _, s := range h.list() {
+				if s.filter.Match(e) {
+					s.send(e, h.stop)
+				}
+			}
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			for _, s := range h.list() {
+				s.sendError(err)
+			}
+
+		case <-h.stop:
+			return
+		}
+	}
+	// the watcher has been closed
+	h.closeSubscriptions()
+}
+
+func (h *Hub) list() []*Subscription {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]*Subscription, 0, len(h.subscribers))
+	for _, s := range h.subscribers {
+		list = append(list, s)
+	}
+	// the events are delivered in the order of the subscriptions
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+func (h *Hub) closeSubscriptions() {
+	h.mu.Lock()
+	h.closed = true
+	list := make([]*Subscription, 0, len(h.subscribers))
+	for id, s := range h.subscribers {
+		list = append(list, s)
+		delete(h.subscribers, id)
+	}
+	h.mu.Unlock()
+
+	for _, s := range list {
+		s.close()
+	}
+}
+
+// Events returns the chan of the events, it is closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Errors returns the chan of the errors generated by the watcher.
+// Errors not read in time are discarded.
+func (s *Subscription) Errors() <-chan error {
+	return s.errors
+}
+
+// Dropped returns the number of events discarded by the backpressure policy
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close removes the subscription from the Hub
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subscribers, s.id)
+	s.hub.mu.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.events)
+		close(s.errors)
+	})
+}
+
+func (s *Subscription) send(e Event, stop chan bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+
+	case DropOldest:
+		for {
+			select {
+			case s.events <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.events:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.events <- e:
+		case <-s.done:
+		case <-stop:
+		}
+	}
+}
+
+func (s *Subscription) sendError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
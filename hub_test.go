@@ -0,0 +1,144 @@
+package cloudwatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("event not received")
+	}
+	return Event{}
+}
+
+func TestFilter_Match(t *testing.T) {
+	f := &Filter{
+		Ops:     []Op{FileCreated, FileChanged},
+		Include: []string{"docs/**", "*.txt"},
+		Exclude: []string{"**/*.tmp"},
+	}
+
+	tests := []struct {
+		event Event
+		match bool
+	}{
+		{Event{Key: "file.txt", Type: FileCreated}, true},
+		{Event{Key: "dir/file.txt", Type: FileCreated}, false},
+		{Event{Key: "docs/a/b.pdf", Type: FileChanged}, true},
+		{Event{Key: "docs/a/b.tmp", Type: FileChanged}, false},
+		{Event{Key: "file.txt", Type: FileDeleted}, false},
+	}
+	for _, test := range tests {
+		if f.Match(test.event) != test.match {
+			t.Errorf("wrong match for '%s' %s: expected %v", test.event.Key, test.event.TypeString(), test.match)
+		}
+	}
+
+	var nilFilter *Filter
+	if !nilFilter.Match(Event{Key: "x"}) {
+		t.Errorf("nil filter should accept all the events")
+	}
+
+	if !MatchGlob("report-[0-9].csv", "report-1.csv") || MatchGlob("report-[!0-9].csv", "report-1.csv") {
+		t.Errorf("character classes wrongly matched")
+	}
+}
+
+func TestHub_Subscribe(t *testing.T) {
+	w := newChanWatcher()
+	h, err := NewHub(w)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := h.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer h.Close()
+
+	all := h.Subscribe(nil, nil)
+	txt := h.Subscribe(&Filter{Include: []string{"*.txt"}}, nil)
+
+	w.Events <- Event{Key: "a.txt", Type: FileCreated}
+	w.Events <- Event{Key: "b.bin", Type: FileCreated}
+
+	if e := receiveEvent(t, all.Events()); e.Key != "a.txt" {
+		t.Errorf("wrong event received: %s", e.Key)
+	}
+	if e := receiveEvent(t, all.Events()); e.Key != "b.bin" {
+		t.Errorf("wrong event received: %s", e.Key)
+	}
+	if e := receiveEvent(t, txt.Events()); e.Key != "a.txt" {
+		t.Errorf("wrong event received: %s", e.Key)
+	}
+
+	// a subscriber leaving doesn't affect the others
+	txt.Close()
+	if _, ok := <-txt.Events(); ok {
+		t.Errorf("the events chan should be closed")
+	}
+	late := h.Subscribe(nil, nil)
+	if h.Subscribers() != 2 {
+		t.Errorf("wrong number of subscribers: %d", h.Subscribers())
+	}
+
+	w.Events <- Event{Key: "c.txt", Type: FileDeleted}
+	w.Errors <- fmt.Errorf("error")
+	if e := receiveEvent(t, all.Events()); e.Key != "c.txt" {
+		t.Errorf("wrong event received: %s", e.Key)
+	}
+	if e := receiveEvent(t, late.Events()); e.Key != "c.txt" {
+		t.Errorf("wrong event received: %s", e.Key)
+	}
+	select {
+	case <-late.Errors():
+	case <-time.After(2 * time.Second):
+		t.Errorf("error not received")
+	}
+
+	h.Close()
+	if _, ok := <-all.Events(); ok {
+		t.Errorf("the events chan should be closed with the hub")
+	}
+}
+
+func TestHub_Backpressure(t *testing.T) {
+	w := newChanWatcher()
+	h, _ := NewHub(w)
+	if err := h.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer h.Close()
+
+	newest := h.Subscribe(nil, &SubscribeOptions{Buffer: 2, Backpressure: DropNewest})
+	oldest := h.Subscribe(nil, &SubscribeOptions{Buffer: 2, Backpressure: DropOldest})
+	blocking := h.Subscribe(nil, &SubscribeOptions{Buffer: 5})
+
+	for i := 0; i < 5; i++ {
+		w.Events <- Event{Key: fmt.Sprintf("%d", i)}
+	}
+	// the blocking subscriber receives everything
+	for i := 0; i < 5; i++ {
+		if e := receiveEvent(t, blocking.Events()); e.Key != fmt.Sprintf("%d", i) {
+			t.Errorf("wrong event received: %s", e.Key)
+		}
+	}
+
+	// the events are delivered in the order of the subscriptions, the other subscribers have already received the
+	// last one
+	if newest.Dropped() != 3 || oldest.Dropped() != 3 {
+		t.Errorf("wrong number of dropped events: %d %d", newest.Dropped(), oldest.Dropped())
+	}
+
+	if e := receiveEvent(t, newest.Events()); e.Key != "0" {
+		t.Errorf("DropNewest should keep the first events, received %s", e.Key)
+	}
+	if e := receiveEvent(t, oldest.Events()); e.Key != "3" {
+		t.Errorf("DropOldest should keep the last events, received %s", e.Key)
+	}
+}
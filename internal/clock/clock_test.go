@@ -0,0 +1,82 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func received(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-ch:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+
+	f.Advance(30 * time.Second)
+	if _, ok := received(ticker.C()); ok {
+		t.Errorf("tick received before the interval")
+	}
+	f.Advance(30 * time.Second)
+	if tick, ok := received(ticker.C()); !ok || !tick.Equal(start.Add(time.Minute)) {
+		t.Errorf("wrong tick: %s %v", tick, ok)
+	}
+
+	// the ticks not received are dropped
+	f.Advance(3 * time.Minute)
+	if tick, ok := received(ticker.C()); !ok || !tick.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("wrong tick: %s %v", tick, ok)
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Errorf("the ticks should be dropped")
+	}
+	if !f.Now().Equal(start.Add(4 * time.Minute)) {
+		t.Errorf("wrong time: %s", f.Now())
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	if _, ok := received(ticker.C()); ok {
+		t.Errorf("tick received after Stop")
+	}
+}
+
+func TestFake_After(t *testing.T) {
+	f := NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	if _, ok := received(f.After(0)); !ok {
+		t.Errorf("After(0) should fire immediately")
+	}
+
+	ch := f.After(time.Second)
+	f.Advance(999 * time.Millisecond)
+	if _, ok := received(ch); ok {
+		t.Errorf("fired too early")
+	}
+	f.Advance(time.Millisecond)
+	if _, ok := received(ch); !ok {
+		t.Errorf("not fired")
+	}
+}
+
+func TestFake_BlockUntil(t *testing.T) {
+	f := NewFake(time.Now())
+	done := make(chan bool)
+	go func() {
+		f.BlockUntil(2)
+		close(done)
+	}()
+
+	f.NewTicker(time.Second)
+	f.After(time.Second)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("BlockUntil has not returned")
+	}
+}
@@ -0,0 +1,120 @@
+package cloudwatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// JournalEntry is an event with its sequence number
+type JournalEntry struct {
+	Seq   uint64
+	Event Event
+}
+
+// Journal numbers the events of a Hub and keeps the most recent ones, so that the streams sent to remote clients
+// can be resumed from the last received sequence number
+type Journal struct {
+	size int
+	sub  *Subscription
+
+	mu            sync.Mutex
+	entries       []JournalEntry
+	seq           uint64
+	notify        chan struct{}
+	done          bool
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// NewJournal subscribes to the hub and keeps the last size events (default 1000). The journal is closed when the
+// hub is closed or when Close is called.
+func NewJournal(hub *Hub, size int) *Journal {
+	if size <= 0 {
+		size = 1000
+	}
+	j := &Journal{
+		size:   size,
+		sub:    hub.Subscribe(nil, nil),
+		notify: make(chan struct{}),
+	}
+	go j.collect()
+	return j
+}
+
+// Close ends the subscription to the hub
+func (j *Journal) Close() {
+	j.sub.Close()
+}
+
+func (j *Journal) collect() {
+	events := j.sub.Events()
+	errors := j.sub.Errors()
+	for events != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			j.mu.Lock()
+			j.seq++
+			j.entries = append(j.entries, JournalEntry{Seq: j.seq, Event: e})
+			if len(j.entries) > j.size {
+				j.entries = j.entries[len(j.entries)-j.size:]
+			}
+			close(j.notify)
+			j.notify = make(chan struct{})
+			j.mu.Unlock()
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			j.mu.Lock()
+			j.lastError = err.Error()
+			j.lastErrorTime = time.Now()
+			close(j.notify)
+			j.notify = make(chan struct{})
+			j.mu.Unlock()
+		}
+	}
+
+	j.mu.Lock()
+	j.done = true
+	close(j.notify)
+	j.mu.Unlock()
+}
+
+// Sequence returns the sequence number of the last event
+func (j *Journal) Sequence() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// LastError returns the last error of the watcher and when it has been received
+func (j *Journal) LastError() (string, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastError, j.lastErrorTime
+}
+
+// Since returns the events following the sequence number after, a chan closed by the next event or error, true
+// if no more events will be received and true if there is a gap. There is a gap when the events following after
+// are no more available, or after is greater than the current sequence number (i.e. the server has been
+// restarted): all the kept events are returned, and the client has to reload the objects as after a CacheReset.
+func (j *Journal) Since(after uint64) (entries []JournalEntry, notify <-chan struct{}, done bool, gap bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	first := j.seq + 1 - uint64(len(j.entries))
+	if after > j.seq || after < first-1 {
+		after = first - 1
+		gap = true
+	}
+	start := int(after - (first - 1))
+	entries = make([]JournalEntry, len(j.entries)-start)
+	copy(entries, j.entries[start:])
+	return entries, j.notify, j.done, gap
+}
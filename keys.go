@@ -0,0 +1,186 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// keysConfiguration contains the keys checked by the keys mode, shared by the configurations of all the watchers.
+// Keys is a comma separated list: when it's not empty the watcher doesn't list its directory, every synchronization
+// looks up only the listed keys.
+type keysConfiguration struct {
+	Keys string `json:"keys"`
+}
+
+func (c keysConfiguration) list() []string {
+	keys := make([]string, 0)
+	for _, k := range strings.Split(c.Keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// lookupFunc returns the object with the given key, or nil if it doesn't exist
+type lookupFunc func(key string) (snapshotObject, error)
+
+// keysWatcher is implemented by the watchers supporting the keys mode
+type keysWatcher interface {
+	addKeys(keys []string) error
+	removeKeys(keys []string) error
+}
+
+// AddKeys adds keys to the ones checked by the watcher, switching it to the keys mode if it is listing its
+// directory. The objects of the new keys are stored by the next synchronization without sending events, as the
+// first synchronization does: no FileCreated is sent for the objects that already exist, only their following
+// changes are reported.
+func AddKeys(w Watcher, keys ...string) error {
+	k, ok := w.(keysWatcher)
+	if !ok {
+		return fmt.Errorf("the watcher doesn't support the keys mode")
+	}
+	return k.addKeys(keys)
+}
+
+// RemoveKeys stops checking the keys, no events are sent for their objects. The watcher stays in the keys mode
+// also when all the keys are removed.
+func RemoveKeys(w Watcher, keys ...string) error {
+	k, ok := w.(keysWatcher)
+	if !ok {
+		return fmt.Errorf("the watcher doesn't support the keys mode")
+	}
+	return k.removeKeys(keys)
+}
+
+// setKeys is called holding the cycle with the keys of a new configuration, the keys already checked are kept. It
+// returns true if the watcher goes back to list its directory: the cache of the listing is stale, the watcher has to
+// clear it and call markCacheReset.
+func (w *WatcherBase) setKeys(c keysConfiguration) bool {
+	list := c.list()
+	if len(list) == 0 {
+		listing := w.keys != nil
+		w.keys = nil
+		w.keyCache = nil
+		return listing
+	}
+	keys := make(map[string]bool, len(list))
+	for _, k := range list {
+		keys[k] = w.keys[k]
+	}
+	for k := range w.keyCache {
+		if _, ok := keys[k]; !ok {
+			delete(w.keyCache, k)
+		}
+	}
+	w.keys = keys
+	return false
+}
+
+func (w *WatcherBase) addKeys(keys []string) error {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	if w.keys == nil {
+		w.keys = make(map[string]bool, len(keys))
+	}
+	for _, k := range keys {
+		if _, ok := w.keys[k]; !ok {
+			w.keys[k] = false
+		}
+	}
+	return nil
+}
+
+func (w *WatcherBase) removeKeys(keys []string) error {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	if w.keys == nil {
+		return fmt.Errorf("the watcher is not in keys mode")
+	}
+	for _, k := range keys {
+		delete(w.keys, k)
+		delete(w.keyCache, k)
+	}
+	return nil
+}
+
+// keysMode returns true if the synchronizations have to check only the keys, it's called holding the cycle
+func (w *WatcherBase) keysMode() bool {
+	return w.keys != nil
+}
+
+// syncKeys is the synchronization of the keys mode, called holding the cycle. The objects are compared with the
+// ones found by the previous lookups, and the deletions are checked by the mass-deletion guard; they don't need to
+// be confirmed as the lookups are not affected by the listings missing objects. A key is kept unchanged if its
+// lookup fails, the first error is returned after sending all of them on the errors channel.
+func (w *WatcherBase) syncKeys(firstSync bool, config guardConfiguration, lookup lookupFunc) error {
+	var lookupErr error
+	if w.keyCache == nil {
+		w.keyCache = make(map[string]snapshotObject, len(w.keys))
+	}
+	keys := make([]string, 0, len(w.keys))
+	for k := range w.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	missing := make([]string, 0)
+	for _, k := range keys {
+		obj, err := lookup(k)
+		if err != nil {
+			w.Errors <- fmt.Errorf("looking up key '%s': %s", k, err)
+			if lookupErr == nil {
+				lookupErr = err
+			}
+			continue
+		}
+		// the objects of the keys just added are stored without events
+		silent := firstSync || !w.keys[k]
+		w.keys[k] = true
+
+		cached, ok := w.keyCache[k]
+		if obj == nil {
+			if ok && !silent {
+				missing = append(missing, k)
+			} else {
+				delete(w.keyCache, k)
+			}
+			continue
+		}
+		w.keyCache[k] = obj
+		if silent {
+			continue
+		}
+		if !ok {
+			w.Events <- Event{
+				Key:    obj.eventKey(),
+				Type:   FileCreated,
+				Object: obj,
+			}
+			continue
+		}
+		for _, op := range obj.changes(cached) {
+			w.Events <- Event{
+				Key:    obj.eventKey(),
+				Type:   op,
+				Object: obj,
+			}
+		}
+	}
+
+	if !w.checkDeletes(config, missing, len(w.keyCache)) {
+		return lookupErr
+	}
+	for _, k := range missing {
+		o := w.keyCache[k]
+		delete(w.keyCache, k)
+		w.Events <- Event{
+			Key:    o.eventKey(),
+			Type:   FileDeleted,
+			Object: o,
+		}
+	}
+	return lookupErr
+}
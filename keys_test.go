@@ -0,0 +1,122 @@
+package cloudwatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+func TestMemoryWatcher_Keys(t *testing.T) {
+	w := newGuardedWatcher(t, map[string]string{"keys": "a, b,x"})
+
+	// the objects not listed are ignored
+	w.Put("a", &MemoryObject{Size: 2, LastModified: guardedObject.LastModified})
+	w.SetTags("b", map[string]string{"k": "v"})
+	w.Delete("c")
+	w.Put("x", guardedObject)
+	w.Put("y", guardedObject)
+	advance(t, w, time.Minute)
+	events := pendingEvents(w)
+	if len(events) != 3 ||
+		events[0].Key != "a" || events[0].Type != FileChanged ||
+		events[1].Key != "b" || events[1].Type != TagsChanged ||
+		events[2].Key != "x" || events[2].Type != FileCreated {
+		t.Fatalf("wrong events: %v", events)
+	}
+
+	w.Delete("x")
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Key != "x" || events[0].Type != FileDeleted {
+		t.Fatalf("wrong events: %v", events)
+	}
+
+	// the added keys are stored without events (d already exists, no FileCreated is sent), the removed ones are
+	// forgotten
+	if err := AddKeys(w, "d"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := RemoveKeys(w, "a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	w.Delete("a")
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("unexpected events: %v", events)
+	}
+	w.SetTags("d", map[string]string{"k": "v"})
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Key != "d" || events[0].Type != TagsChanged {
+		t.Errorf("wrong events: %v", events)
+	}
+
+	// back to the listing
+	if err := w.SetConfig(map[string]string{}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := RemoveKeys(w, "d"); err == nil {
+		t.Errorf("the watcher is not in keys mode")
+	}
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Type != CacheReset {
+		t.Errorf("wrong events: %v", events)
+	}
+	w.Delete("y")
+	advance(t, w, time.Minute)
+	if events := pendingEvents(w); len(events) != 1 || events[0].Key != "y" || events[0].Type != FileDeleted {
+		t.Errorf("wrong events: %v", events)
+	}
+}
+
+func TestKeys_Config(t *testing.T) {
+	if _, err := parseLocalConfig(map[string]string{"keys": "/tmp/a"}); err == nil {
+		t.Errorf("the keys mode requires the polling")
+	}
+	if _, err := parseGitConfig(map[string]string{"keys": "a", "repo_url": "https://example.com/repo.git"}); err == nil {
+		t.Errorf("the keys mode requires monitor_type file")
+	}
+}
+
+func TestS3Watcher_Keys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	tag, _ := tags.NewTags(map[string]string{}, true)
+	m.EXPECT().GetObjectTagging(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(tag, nil).AnyTimes()
+
+	// the bucket is never listed
+	first := minio.ObjectInfo{ETag: "\"aaa\"", Size: 1, LastModified: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)}
+	second := first
+	second.ETag = "\"bbb\""
+	second.Size = 2
+	gomock.InOrder(
+		m.EXPECT().StatObject(gomock.Any(), "test", "dir/file", gomock.Any()).Return(first, nil),
+		m.EXPECT().StatObject(gomock.Any(), "test", "dir/file", gomock.Any()).Return(second, nil),
+		m.EXPECT().StatObject(gomock.Any(), "test", "dir/file", gomock.Any()).Return(minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}),
+	)
+
+	w, _ := newS3Watcher("/", time.Minute)
+	sw := w.(*S3Watcher)
+	if err := sw.SetConfig(map[string]string{"bucket_name": "test", "endpoint": "localhost:9000", "keys": "dir/file"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.client = m
+
+	sw.sync(true)
+	sw.sync(false)
+	events := pendingEvents(sw)
+	if len(events) != 1 || events[0].Key != "dir/file" || events[0].Type != FileChanged {
+		t.Fatalf("wrong events: %v", events)
+	}
+	if o := events[0].Object.(*S3Object); o.Etag != "bbb" {
+		t.Errorf("wrong object: %v", o)
+	}
+	sw.sync(false)
+	if events := pendingEvents(sw); len(events) != 1 || events[0].Key != "dir/file" || events[0].Type != FileDeleted {
+		t.Errorf("wrong events: %v", events)
+	}
+}
@@ -1,9 +1,11 @@
 package cloudwatcher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -17,7 +19,7 @@ type LocalWatcher struct {
 	syncing uint32
 
 	watcher *fsnotify.Watcher
-	ticker  *time.Ticker
+	ticker  Ticker
 	stop    chan bool
 	config  *localConfiguration
 	cache   map[string]*LocalObject
@@ -25,15 +27,18 @@ type LocalWatcher struct {
 
 // LocalObject is the object that contains the info of the file
 type LocalObject struct {
-	Key          string
-	Size         int64
-	LastModified time.Time
-	FileMode     os.FileMode
+	Key          string      `json:"key"`
+	Size         int64       `json:"size"`
+	LastModified time.Time   `json:"last_modified"`
+	FileMode     os.FileMode `json:"file_mode"`
 }
 
 type localConfiguration struct {
 	Debug             Bool `json:"debug"`
 	DisableFsNotify   Bool `json:"disable_fsnotify"`
+
+	guardConfiguration
+	keysConfiguration
 }
 
 func newLocalWatcher(dir string, interval time.Duration) (Watcher, error) {
@@ -42,15 +47,16 @@ func newLocalWatcher(dir string, interval time.Duration) (Watcher, error) {
 		stop:   make(chan bool, 1),
 		cache:  make(map[string]*LocalObject),
 		WatcherBase: WatcherBase{
-			Events:      make(chan Event, 100),
-			Errors:      make(chan error, 100),
-			watchDir:    dir,
-			pollingTime: interval,
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
 		},
 	}
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory '%s' not found", dir)
+		return nil, &NotFoundError{Resource: fmt.Sprintf("directory '%s'", dir)}
 	}
 
 	return w, nil
@@ -58,34 +64,100 @@ func newLocalWatcher(dir string, interval time.Duration) (Watcher, error) {
 
 // SetConfig is used to configure the LocalWatcher
 func (w *LocalWatcher) SetConfig(m map[string]string) error {
-	j, err := json.Marshal(m)
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, localConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseLocalConfig(resolved)
 	if err != nil {
 		return err
 	}
 
-	config := localConfiguration{}
-	if err := json.Unmarshal(j, &config); err != nil {
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*LocalObject)
+		w.markCacheReset()
+	}
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+// Reconfigure validates the new configuration and applies it between two synchronizations, the cache is always
+// kept. The watching mode (disable_fsnotify) can't be changed once started.
+func (w *LocalWatcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, localConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseLocalConfig(resolved)
+	if err != nil {
 		return err
 	}
-	w.config = &config
 
+	if err := w.lockCycle(ctx); err != nil {
+		return err
+	}
+	defer w.unlockCycle()
+	started := w.ticker != nil || w.watcher != nil || w.getScheduled() != nil
+	if started && config.DisableFsNotify != w.config.DisableFsNotify {
+		return fmt.Errorf("disable_fsnotify can't be changed while the watcher is running")
+	}
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*LocalObject)
+		w.markCacheReset()
+	}
+	w.setRawConfig(m, resolved)
 	return nil
 }
 
+func parseLocalConfig(m map[string]string) (*localConfiguration, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &localConfiguration{}
+	if err := json.Unmarshal(j, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if len(config.list()) > 0 && !config.DisableFsNotify {
+		return nil, fmt.Errorf("keys requires disable_fsnotify")
+	}
+	return config, nil
+}
+
 // Start launches the polling process
 func (w *LocalWatcher) Start() error {
 	if _, err := os.Stat(w.watchDir); os.IsNotExist(err) {
-		return fmt.Errorf("directory '%s' not found", w.watchDir)
+		return &NotFoundError{Resource: fmt.Sprintf("directory '%s'", w.watchDir)}
 	}
 
 	if w.config.DisableFsNotify {
-		w.ticker = time.NewTicker(w.pollingTime)
+		if w.scheduler != nil {
+			return w.startScheduled(w)
+		}
+		w.ticker = w.getClock().NewTicker(w.pollingTime)
 		go func() {
 			// launch synchronization also the first time
 			w.sync(true)
 			for {
 				select {
-				case <-w.ticker.C:
+				case <-w.ticker.C():
+					w.sync(false)
+
+				case <-w.syncRequests:
 					w.sync(false)
 
 				case <-w.stop:
@@ -115,7 +187,7 @@ func (w *LocalWatcher) Start() error {
 					obj := &LocalObject{
 						Key:          event.Name,
 						Size:         0,
-						LastModified: time.Now(),
+						LastModified: w.getClock().Now(),
 						FileMode:     0,
 					}
 					e := Event{}
@@ -200,18 +272,85 @@ func (w *LocalWatcher) Start() error {
 
 // Close stop the polling process
 func (w *LocalWatcher) Close() {
+	if w.closeScheduled(nil) {
+		return
+	}
 	w.stop <- true
 }
 
+// requestSync is supported only by the polling mode, with fsnotify the events are already delivered immediately
+func (w *LocalWatcher) requestSync() error {
+	if !w.config.DisableFsNotify {
+		return fmt.Errorf("synchronization on demand requires disable_fsnotify")
+	}
+	return w.WatcherBase.requestSync()
+}
+
+// addKeys is supported only by the polling mode, fsnotify doesn't run the synchronizations
+func (w *LocalWatcher) addKeys(keys []string) error {
+	if !w.config.DisableFsNotify {
+		return fmt.Errorf("keys requires disable_fsnotify")
+	}
+	return w.WatcherBase.addKeys(keys)
+}
+
+func (w *LocalWatcher) service() string {
+	return "local"
+}
+
+// schedulingGroup returns the group of the local watchers in a Scheduler, they share the disk
+func (w *LocalWatcher) schedulingGroup() string {
+	return "local"
+}
+
+func (w *LocalWatcher) source() string {
+	dir, err := filepath.Abs(w.watchDir)
+	if err != nil {
+		dir = w.watchDir
+	}
+	return "local://" + filepath.ToSlash(dir)
+}
+
+// String returns the URI of the watcher
+func (w *LocalWatcher) String() string {
+	dir, err := filepath.Abs(w.watchDir)
+	if err != nil {
+		dir = w.watchDir
+	}
+	return formatURI(&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}, w.pollingTime, w.config, w.rawConfig)
+}
+
+// Capabilities returns the features of the local FS: the file modes are the tags, the directories are watched and
+// fsnotify pushes the changes
+func (w *LocalWatcher) Capabilities() Capabilities {
+	return Capabilities{
+		Tags:              true,
+		PushNotifications: !bool(w.config.DisableFsNotify),
+		DirectoryEvents:   true,
+		ConfigKeys:        configKeys(localConfiguration{}),
+	}
+}
+
 func (w *LocalWatcher) sync(firstSync bool) {
 	// allow only one sync at same time
 	if !atomic.CompareAndSwapUint32(&w.syncing, 0, 1) {
 		return
 	}
 	defer atomic.StoreUint32(&w.syncing, 0)
+	w.syncStarted()
+	defer w.syncDone()
 
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	firstSync = w.checkCacheReset(firstSync)
+	// the files of a removed directory are not reported as deleted
 	if _, err := os.Stat(w.watchDir); os.IsNotExist(err) {
-		w.Errors <- fmt.Errorf("directory '%s' not found", w.watchDir)
+		w.Errors <- &NotFoundError{Resource: fmt.Sprintf("directory '%s'", w.watchDir)}
+		return
+	}
+	if w.keysMode() {
+		w.syncKeys(firstSync, w.config.guardConfiguration, w.lookupKey)
+		return
 	}
 
 	fileList := make(map[string]*LocalObject, 0)
@@ -240,20 +379,10 @@ func (w *LocalWatcher) sync(firstSync bool) {
 			cached := w.getCachedObject(obj)
 			// Object has been cached previously by Key
 			if cached != nil {
-				// Check if the LastModified has been changed
-				if !cached.LastModified.Equal(obj.LastModified) || (cached.Size != obj.Size) {
-					event := Event{
-						Key:    obj.Key,
-						Type:   FileChanged,
-						Object: obj,
-					}
-					w.Events <- event
-				}
-				// Check if the file modes have been updated
-				if cached.FileMode != obj.FileMode {
+				for _, op := range obj.changes(cached) {
 					event := Event{
 						Key:    obj.Key,
-						Type:   TagsChanged,
+						Type:   op,
 						Object: obj,
 					}
 					w.Events <- event
@@ -276,9 +405,20 @@ func (w *LocalWatcher) sync(firstSync bool) {
 		return
 	}
 
-	for k, o := range w.cache {
-		if _, found := fileList[k]; !found {
+	if !firstSync {
+		deleted := make([]string, 0)
+		for k := range w.cache {
+			if _, found := fileList[k]; !found {
+				deleted = append(deleted, k)
+			}
+		}
+		deleted = w.confirmMissing(w.config.guardConfiguration, deleted, w.objectExists)
+		if !w.checkDeletes(w.config.guardConfiguration, deleted, len(w.cache)) {
+			return
+		}
+		for _, k := range deleted {
 			// file not found in the list...deleting it
+			o := w.cache[k]
 			delete(w.cache, k)
 			event := Event{
 				Key:    o.Key,
@@ -290,6 +430,93 @@ func (w *LocalWatcher) sync(firstSync bool) {
 	}
 }
 
+func (o *LocalObject) cacheKey() string {
+	return o.Key
+}
+
+func (o *LocalObject) eventKey() string {
+	return o.Key
+}
+
+func (o *LocalObject) backend() string {
+	return "local"
+}
+
+func (o *LocalObject) size() int64 {
+	return o.Size
+}
+
+func (o *LocalObject) modTime() time.Time {
+	return o.LastModified
+}
+
+func (o *LocalObject) hash() string {
+	return ""
+}
+
+func (o *LocalObject) changes(cached snapshotObject) []Op {
+	c := cached.(*LocalObject)
+	ops := make([]Op, 0)
+	// Check if the LastModified has been changed
+	if !c.LastModified.Equal(o.LastModified) || (c.Size != o.Size) {
+		ops = append(ops, FileChanged)
+	}
+	// Check if the file modes have been updated
+	if c.FileMode != o.FileMode {
+		ops = append(ops, TagsChanged)
+	}
+	return ops
+}
+
+func (w *LocalWatcher) list() ([]snapshotObject, error) {
+	// waiting for the running sync
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+
+	objects := make([]snapshotObject, 0)
+	err := filepath.Walk(w.watchDir, func(walkPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if walkPath == w.watchDir {
+			return nil
+		}
+		objects = append(objects, &LocalObject{
+			Key:          walkPath,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+			FileMode:     fi.Mode(),
+		})
+		return nil
+	})
+	return objects, err
+}
+
+// objectExists checks the file missing from the walk
+func (w *LocalWatcher) objectExists(key string) (bool, error) {
+	_, err := os.Lstat(key)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lookupKey returns the file with the path for the keys mode
+func (w *LocalWatcher) lookupKey(key string) (snapshotObject, error) {
+	fi, err := os.Stat(key)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &LocalObject{
+		Key:          key,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		FileMode:     fi.Mode(),
+	}, nil
+}
+
 func (w *LocalWatcher) getCachedObject(o *LocalObject) *LocalObject {
 	if cachedObject, ok := w.cache[o.Key]; ok {
 		return cachedObject
@@ -329,4 +556,5 @@ func (w *LocalWatcher) rmRecursive(dir string) error {
 
 func init() {
 	supportedServices["local"] = newLocalWatcher
+	objectTypes["local"] = func() snapshotObject { return &LocalObject{} }
 }
@@ -0,0 +1,387 @@
+package cloudwatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryWatcher watches an in-memory storage changed with Put, Delete and SetTags. It is meant for the tests of the
+// code consuming the events: the storage is compared with the cache as the other watchers do. Created WithClock
+// and a fake clock, the synchronizations are triggered by advancing the clock instead of waiting for the ticker.
+// The dir is only used as name of the storage.
+type MemoryWatcher struct {
+	WatcherBase
+
+	syncing uint32
+
+	ticker Ticker
+	stop   chan bool
+	config *memoryConfiguration
+	cache  map[string]*MemoryObject
+
+	// storage
+	mu      sync.Mutex
+	objects map[string]*MemoryObject
+}
+
+// MemoryObject is the object stored by the MemoryWatcher
+type MemoryObject struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	Hash         string            `json:"hash"`
+	Tags         map[string]string `json:"tags"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+type memoryConfiguration struct {
+	guardConfiguration
+	keysConfiguration
+}
+
+func newMemoryWatcher(dir string, interval time.Duration) (Watcher, error) {
+	w := &MemoryWatcher{
+		config:  &memoryConfiguration{},
+		stop:    make(chan bool, 1),
+		cache:   make(map[string]*MemoryObject),
+		objects: make(map[string]*MemoryObject),
+		WatcherBase: WatcherBase{
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
+		},
+	}
+	return w, nil
+}
+
+// SetConfig is used to configure the MemoryWatcher
+func (w *MemoryWatcher) SetConfig(m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, memoryConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseMemoryConfig(resolved)
+	if err != nil {
+		return err
+	}
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*MemoryObject)
+		w.markCacheReset()
+	}
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+// Reconfigure validates the new configuration and applies it between two synchronizations, the cache is always
+// kept.
+func (w *MemoryWatcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := w.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, memoryConfiguration{})
+	if err != nil {
+		return err
+	}
+	config, err := parseMemoryConfig(resolved)
+	if err != nil {
+		return err
+	}
+
+	if err := w.lockCycle(ctx); err != nil {
+		return err
+	}
+	defer w.unlockCycle()
+	w.config = config
+	if w.setKeys(config.keysConfiguration) {
+		w.cache = make(map[string]*MemoryObject)
+		w.markCacheReset()
+	}
+	w.setRawConfig(m, resolved)
+	return nil
+}
+
+func parseMemoryConfig(m map[string]string) (*memoryConfiguration, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &memoryConfiguration{}
+	if err := json.Unmarshal(j, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Start launches the polling process
+func (w *MemoryWatcher) Start() error {
+	if w.scheduler != nil {
+		return w.startScheduled(w)
+	}
+	if w.pollingTime <= 0 {
+		return fmt.Errorf("wrong polling interval %s", w.pollingTime)
+	}
+	w.ticker = w.getClock().NewTicker(w.pollingTime)
+	go func() {
+		// launch synchronization also the first time
+		w.sync(true)
+		for {
+			select {
+			case <-w.ticker.C():
+				w.sync(false)
+
+			case <-w.syncRequests:
+				w.sync(false)
+
+			case <-w.stop:
+				w.ticker.Stop()
+				close(w.Events)
+				close(w.Errors)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stop the polling process
+func (w *MemoryWatcher) Close() {
+	if w.closeScheduled(nil) {
+		return
+	}
+	w.stop <- true
+}
+
+// Now returns the current time of the storage, used for the LastModified of the objects
+func (w *MemoryWatcher) Now() time.Time {
+	return w.getClock().Now()
+}
+
+// Put creates or replaces the object with the given key, the LastModified is set to Now if it is zero
+func (w *MemoryWatcher) Put(key string, obj *MemoryObject) {
+	o := &MemoryObject{}
+	if obj != nil {
+		*o = *obj
+	}
+	o.Key = key
+	o.Tags = copyTags(o.Tags)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if o.LastModified.IsZero() {
+		o.LastModified = w.getClock().Now()
+	}
+	w.objects[key] = o
+}
+
+// Delete removes the object with the given key
+func (w *MemoryWatcher) Delete(key string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.objects[key]; !ok {
+		return fmt.Errorf("object '%s' not found", key)
+	}
+	delete(w.objects, key)
+	return nil
+}
+
+// SetTags replaces the tags of the object with the given key
+func (w *MemoryWatcher) SetTags(key string, tags map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	o, ok := w.objects[key]
+	if !ok {
+		return fmt.Errorf("object '%s' not found", key)
+	}
+	// the stored objects are never modified, they can be shared with the cache and the events
+	updated := *o
+	updated.Tags = copyTags(tags)
+	w.objects[key] = &updated
+	return nil
+}
+
+func (w *MemoryWatcher) service() string {
+	return "memory"
+}
+
+// schedulingGroup returns the group of the watcher in a Scheduler, the storages are independent
+func (w *MemoryWatcher) schedulingGroup() string {
+	return "memory:" + path.Join("/", w.watchDir)
+}
+
+func (w *MemoryWatcher) source() string {
+	return "memory://" + path.Join("/", w.watchDir)
+}
+
+// String returns the URI of the watcher
+func (w *MemoryWatcher) String() string {
+	return formatURI(&url.URL{Scheme: "memory", Path: path.Join("/", w.watchDir)}, w.pollingTime, w.config, w.rawConfig)
+}
+
+// Capabilities returns the features of the in-memory storage: the objects have tags and a hash
+func (w *MemoryWatcher) Capabilities() Capabilities {
+	return Capabilities{
+		Tags:        true,
+		ContentHash: true,
+		ConfigKeys:  configKeys(memoryConfiguration{}),
+	}
+}
+
+// enumerateFiles returns the stored objects sorted by key
+func (w *MemoryWatcher) enumerateFiles() []*MemoryObject {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	objects := make([]*MemoryObject, 0, len(w.objects))
+	for _, o := range w.objects {
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects
+}
+
+func (w *MemoryWatcher) sync(firstSync bool) {
+	// allow only one sync at same time
+	if !atomic.CompareAndSwapUint32(&w.syncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreUint32(&w.syncing, 0)
+	w.syncStarted()
+	defer w.syncDone()
+
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+	firstSync = w.checkCacheReset(firstSync)
+	if w.keysMode() {
+		w.syncKeys(firstSync, w.config.guardConfiguration, w.lookupKey)
+		return
+	}
+
+	fileList := make(map[string]*MemoryObject)
+	for _, upd := range w.enumerateFiles() {
+		fileList[upd.Key] = upd
+
+		if !firstSync {
+			if cached, ok := w.cache[upd.Key]; ok {
+				for _, op := range upd.changes(cached) {
+					w.Events <- Event{
+						Key:    upd.Key,
+						Type:   op,
+						Object: upd,
+					}
+				}
+			} else {
+				w.Events <- Event{
+					Key:    upd.Key,
+					Type:   FileCreated,
+					Object: upd,
+				}
+			}
+		}
+		w.cache[upd.Key] = upd
+	}
+
+	if !firstSync {
+		deleted := make([]string, 0)
+		for k := range w.cache {
+			if _, found := fileList[k]; !found {
+				deleted = append(deleted, k)
+			}
+		}
+		deleted = w.confirmMissing(w.config.guardConfiguration, deleted, nil)
+		if !w.checkDeletes(w.config.guardConfiguration, deleted, len(w.cache)) {
+			return
+		}
+		for _, k := range deleted {
+			o := w.cache[k]
+			delete(w.cache, k)
+			w.Events <- Event{
+				Key:    o.Key,
+				Type:   FileDeleted,
+				Object: o,
+			}
+		}
+	}
+}
+
+// lookupKey returns the stored object with the key for the keys mode
+func (w *MemoryWatcher) lookupKey(key string) (snapshotObject, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if o, ok := w.objects[key]; ok {
+		return o, nil
+	}
+	return nil, nil
+}
+
+func (w *MemoryWatcher) list() ([]snapshotObject, error) {
+	// waiting for the running sync
+	w.lockCycle(context.Background())
+	defer w.unlockCycle()
+
+	objects := make([]snapshotObject, 0)
+	for _, o := range w.enumerateFiles() {
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+func (o *MemoryObject) cacheKey() string {
+	return o.Key
+}
+
+func (o *MemoryObject) eventKey() string {
+	return o.Key
+}
+
+func (o *MemoryObject) backend() string {
+	return "memory"
+}
+
+func (o *MemoryObject) size() int64 {
+	return o.Size
+}
+
+func (o *MemoryObject) modTime() time.Time {
+	return o.LastModified
+}
+
+func (o *MemoryObject) hash() string {
+	return o.Hash
+}
+
+func (o *MemoryObject) changes(cached snapshotObject) []Op {
+	c := cached.(*MemoryObject)
+	ops := make([]Op, 0)
+	if !c.LastModified.Equal(o.LastModified) || c.Size != o.Size || c.Hash != o.Hash {
+		ops = append(ops, FileChanged)
+	}
+	if !equalTags(c.Tags, o.Tags) {
+		ops = append(ops, TagsChanged)
+	}
+	return ops
+}
+
+func init() {
+	supportedServices["memory"] = newMemoryWatcher
+	objectTypes["memory"] = func() snapshotObject { return &MemoryObject{} }
+}
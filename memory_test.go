@@ -0,0 +1,172 @@
+package cloudwatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+// newTestMemoryWatcher returns a memory watcher using a fake clock
+func newTestMemoryWatcher(t *testing.T, interval time.Duration, config map[string]string) *MemoryWatcher {
+	fake := clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	w, err := New("memory", "test", interval, WithClock(fake))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.SetConfig(config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return w.(*MemoryWatcher)
+}
+
+// syncWatcher is implemented by all the watchers of this package
+type syncWatcher interface {
+	syncState() (uint64, uint64, <-chan struct{})
+}
+
+// waitSyncState waits until done returns true with the number of started and completed synchronizations
+func waitSyncState(t *testing.T, w Watcher, done func(starts, syncs uint64) bool) bool {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		starts, syncs, changed := w.(syncWatcher).syncState()
+		if done(starts, syncs) {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// waitSyncs waits until the watcher has completed n synchronizations
+func waitSyncs(t *testing.T, w Watcher, n uint64) {
+	t.Helper()
+	if !waitSyncState(t, w, func(starts, syncs uint64) bool { return syncs >= n }) {
+		t.Fatalf("synchronization %d not completed", n)
+	}
+}
+
+// waitSyncStarts waits until the watcher has started n synchronizations
+func waitSyncStarts(t *testing.T, w Watcher, n uint64) {
+	t.Helper()
+	if !waitSyncState(t, w, func(starts, syncs uint64) bool { return starts >= n }) {
+		t.Fatalf("synchronization %d not started", n)
+	}
+}
+
+// advance moves the fake clock of the watcher forward by d, that must reach the next tick, and waits for the
+// synchronization
+func advance(t *testing.T, w *MemoryWatcher, d time.Duration) {
+	t.Helper()
+	syncs := w.Status().Syncs
+	w.getClock().(*clock.Fake).Advance(d)
+	waitSyncs(t, w, syncs+1)
+}
+
+// pendingEvents returns the events already sent by the watcher
+func pendingEvents(w Watcher) []Event {
+	events := make([]Event, 0)
+	for {
+		select {
+		case e := <-w.GetEvents():
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestMemoryWatcher_FakeClock(t *testing.T) {
+	w := newTestMemoryWatcher(t, time.Minute, nil)
+	w.Put("existing.txt", &MemoryObject{Size: 1})
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer w.Close()
+	waitSyncs(t, w, 1)
+
+	w.Put("a.txt", &MemoryObject{Size: 4, Tags: map[string]string{"env": "dev"}})
+	w.getClock().(*clock.Fake).Advance(30 * time.Second)
+	if events := pendingEvents(w); len(events) != 0 || w.Status().Syncs != 1 {
+		t.Fatalf("no events expected before the polling interval: %v", events)
+	}
+	advance(t, w, 30*time.Second)
+	events := pendingEvents(w)
+	if len(events) != 1 || events[0].Key != "a.txt" || events[0].Type != FileCreated {
+		t.Fatalf("wrong events: %v", events)
+	}
+	if o := events[0].Object.(*MemoryObject); !o.LastModified.Equal(w.Now().Add(-time.Minute)) {
+		t.Errorf("wrong last modified: %s", o.LastModified)
+	}
+
+	w.Put("a.txt", &MemoryObject{Size: 5, Tags: map[string]string{"env": "dev"}})
+	w.SetTags("existing.txt", map[string]string{"env": "prod"})
+	advance(t, w, time.Minute)
+	events = pendingEvents(w)
+	if len(events) != 2 || events[0].Key != "a.txt" || events[0].Type != FileChanged ||
+		events[1].Key != "existing.txt" || events[1].Type != TagsChanged {
+		t.Fatalf("wrong events: %v", events)
+	}
+
+	if err := w.Delete("a.txt"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Delete("a.txt"); err == nil {
+		t.Errorf("an error should be returned deleting a missing object")
+	}
+	if err := w.SetTags("a.txt", nil); err == nil {
+		t.Errorf("an error should be returned tagging a missing object")
+	}
+	if err := SyncNow(w); err != nil {
+		t.Fatalf("%s", err)
+	}
+	waitSyncs(t, w, 4)
+	events = pendingEvents(w)
+	if len(events) != 1 || events[0].Key != "a.txt" || events[0].Type != FileDeleted {
+		t.Fatalf("wrong events: %v", events)
+	}
+	if status := w.Status(); status.Syncs != 4 {
+		t.Errorf("wrong number of syncs: %d", status.Syncs)
+	}
+}
+
+func TestMemoryWatcher_Ticker(t *testing.T) {
+	w := newTestMemoryWatcher(t, 10*time.Millisecond, nil)
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	waitSyncs(t, w, 1)
+	w.Put("a.txt", nil)
+	advance(t, w, 10*time.Millisecond)
+
+	select {
+	case e := <-w.Events:
+		if e.Key != "a.txt" || e.Type != FileCreated {
+			t.Errorf("wrong event: %v", e)
+		}
+	default:
+		t.Fatalf("event not received")
+	}
+
+	w.Close()
+	for range w.Events {
+	}
+}
+
+func TestMemoryWatcher_Snapshot(t *testing.T) {
+	w := newTestMemoryWatcher(t, time.Minute, nil)
+	w.Put("a.txt", &MemoryObject{Size: 1})
+	w.Put("b.txt", &MemoryObject{Size: 2})
+
+	s, err := NewSnapshot(w)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s.Service != "memory" || s.Source != "memory:///test" || s.Len() != 2 {
+		t.Errorf("wrong snapshot: %#v", s)
+	}
+}
@@ -78,3 +78,18 @@ func (mr *MockMinioMockRecorder) ListObjects(ctx, bucketName, opts interface{})
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListObjects", reflect.TypeOf((*MockMinio)(nil).ListObjects), ctx, bucketName, opts)
 }
+
+// StatObject mocks base method
+func (m *MockMinio) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatObject", ctx, bucketName, objectName, opts)
+	ret0, _ := ret[0].(minio.ObjectInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatObject indicates an expected call of StatObject
+func (mr *MockMinioMockRecorder) StatObject(ctx, bucketName, objectName, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatObject", reflect.TypeOf((*MockMinio)(nil).StatObject), ctx, bucketName, objectName, opts)
+}
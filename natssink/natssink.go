@@ -0,0 +1,161 @@
+// Package natssink implements a cloudwatcher.Sink publishing the events on NATS, using core NATS or JetStream.
+package natssink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/nats-io/nats.go"
+)
+
+// Config contains the options of the Sink
+type Config struct {
+	// Subject is the template of the subject, it can contain the placeholders {backend}, {op} and {key}
+	// (default "cloudwatcher.{backend}.{op}"). The key is escaped to a single token, e.g. "dir/a.txt" is "dir/a%2Etxt".
+	Subject string
+	// JetStream enables the publication on JetStream: each message is confirmed by the stream.
+	// With core NATS the publication is confirmed by a flush of the connection.
+	JetStream bool
+	// Timeout is the max time waiting for the confirmation of a batch (default 10s)
+	Timeout time.Duration
+}
+
+// Sink publishes the events on NATS. Each message contains the JSON of the event and has the
+// Nats-Msg-Id header set to the stable ID of the event, used by JetStream to discard the duplicates.
+type Sink struct {
+	config Config
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+}
+
+// New creates a Sink that publishes on the connection nc
+func New(nc *nats.Conn, c Config) (*Sink, error) {
+	if nc == nil {
+		return nil, fmt.Errorf("nats connection needed")
+	}
+	if c.Subject == "" {
+		c.Subject = "cloudwatcher.{backend}.{op}"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if err := checkSubject(subject(c.Subject, cloudwatcher.Event{Key: "key"})); err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		config: c,
+		conn:   nc,
+	}
+	if c.JetStream {
+		js, err := nc.JetStream()
+		if err != nil {
+			return nil, fmt.Errorf("creating jetstream context: %s", err)
+		}
+		s.js = js
+	}
+	return s, nil
+}
+
+// Send publishes the events and waits for their confirmation
+func (s *Sink) Send(ctx context.Context, events []cloudwatcher.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	futures := make([]nats.PubAckFuture, 0, len(events))
+	for _, e := range events {
+		msg, err := s.message(e)
+		if err != nil {
+			return err
+		}
+
+		if s.js == nil {
+			if err := s.conn.PublishMsg(msg); err != nil {
+				return fmt.Errorf("publishing '%s' on '%s': %s", e.Key, msg.Subject, err)
+			}
+			continue
+		}
+
+		f, err := s.js.PublishMsgAsync(msg)
+		if err != nil {
+			return fmt.Errorf("publishing '%s' on '%s': %s", e.Key, msg.Subject, err)
+		}
+		futures = append(futures, f)
+	}
+
+	if s.js == nil {
+		if err := s.conn.FlushWithContext(ctx); err != nil {
+			return fmt.Errorf("confirming the publication: %s", err)
+		}
+		return nil
+	}
+
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return fmt.Errorf("publishing on '%s': %s", f.Msg().Subject, err)
+		case <-ctx.Done():
+			return fmt.Errorf("publishing on '%s': %s", f.Msg().Subject, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Close flushes the pending messages, the connection is not closed
+func (s *Sink) Close() error {
+	return s.conn.Flush()
+}
+
+func (s *Sink) message(e cloudwatcher.Event) (*nats.Msg, error) {
+	data, err := cloudwatcher.MarshalEvent(e)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event '%s': %s", e.Key, err)
+	}
+
+	sub := subject(s.config.Subject, e)
+	if err := checkSubject(sub); err != nil {
+		return nil, fmt.Errorf("event '%s': %s", e.Key, err)
+	}
+	msg := nats.NewMsg(sub)
+	msg.Data = data
+	msg.Header.Set(nats.MsgIdHdr, cloudwatcher.EventID(e))
+	return msg, nil
+}
+
+// subject expands the template with the escaped key of the event
+func subject(tpl string, e cloudwatcher.Event) string {
+	e.Key = escapeToken(e.Key)
+	return cloudwatcher.ExpandTemplate(tpl, e)
+}
+
+// escapeToken replaces the characters that can't be in a token of a subject (the separator, the wildcards and the
+// whitespaces) and the escape character with %XX
+func escapeToken(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == 0x7f || c == '.' || c == '*' || c == '>' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// checkSubject returns an error if the subject can't be used to publish: the wildcards match many subjects
+func checkSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" {
+			return fmt.Errorf("wrong subject '%s': empty token", subject)
+		}
+		if strings.ContainsAny(token, "*> \t\r\n") {
+			return fmt.Errorf("wrong subject '%s': wildcards and whitespaces are not allowed", subject)
+		}
+	}
+	return nil
+}
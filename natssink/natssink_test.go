@@ -0,0 +1,141 @@
+package natssink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func runServer(t *testing.T) *nats.Conn {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("creating nats server: %s", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("connecting to nats: %s", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestSink_Core(t *testing.T) {
+	nc := runServer(t)
+
+	sub, err := nc.SubscribeSync("cloudwatcher.>")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	s, err := New(nc, Config{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	e := cloudwatcher.Event{Key: "a.txt", Type: cloudwatcher.FileCreated, Object: &cloudwatcher.S3Object{Key: "a.txt"}}
+	if err := s.Send(context.Background(), []cloudwatcher.Event{e}); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("message not received: %s", err)
+	}
+	if msg.Subject != "cloudwatcher.s3.FileCreated" {
+		t.Errorf("wrong subject: %s", msg.Subject)
+	}
+	if msg.Header.Get(nats.MsgIdHdr) != cloudwatcher.EventID(e) {
+		t.Errorf("wrong message id: %s", msg.Header.Get(nats.MsgIdHdr))
+	}
+}
+
+func TestSink_JetStream(t *testing.T) {
+	nc := runServer(t)
+	js, _ := nc.JetStream()
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     "EVENTS",
+		Subjects: []string{"files.>"},
+	})
+	if err != nil {
+		t.Fatalf("creating stream: %s", err)
+	}
+
+	s, err := New(nc, Config{Subject: "files.{backend}", JetStream: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	events := []cloudwatcher.Event{
+		{Key: "a.txt", Type: cloudwatcher.FileCreated, Object: &cloudwatcher.LocalObject{Key: "a.txt"}},
+		{Key: "b.txt", Type: cloudwatcher.FileDeleted, Object: &cloudwatcher.LocalObject{Key: "b.txt"}},
+	}
+	if err := s.Send(context.Background(), events); err != nil {
+		t.Errorf("%s", err)
+	}
+	// the redelivery of the same events is discarded by the stream
+	if err := s.Send(context.Background(), events); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	info, err := js.StreamInfo("EVENTS")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if info.State.Msgs != 2 {
+		t.Errorf("wrong number of messages in the stream: %d", info.State.Msgs)
+	}
+
+	// no stream is bound to the subject: the publication is not confirmed
+	s, _ = New(nc, Config{Subject: "unknown.{backend}", JetStream: true, Timeout: time.Second})
+	if err := s.Send(context.Background(), events); err == nil {
+		t.Errorf("an error should be returned")
+	}
+}
+
+func TestSink_Subject(t *testing.T) {
+	nc := runServer(t)
+
+	sub, err := nc.SubscribeSync("files.>")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	s, err := New(nc, Config{Subject: "files.{key}"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the key is a single token
+	e := cloudwatcher.Event{Key: "dir/a.*.txt", Type: cloudwatcher.FileCreated}
+	if err := s.Send(context.Background(), []cloudwatcher.Event{e}); err != nil {
+		t.Errorf("%s", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("message not received: %s", err)
+	}
+	if msg.Subject != "files.dir/a%2E%2A%2Etxt" {
+		t.Errorf("wrong subject: %s", msg.Subject)
+	}
+
+	for _, subject := range []string{"files.>", "files.*.{op}", "files..{op}", "files {op}"} {
+		if _, err := New(nc, Config{Subject: subject}); err == nil {
+			t.Errorf("the subject '%s' should be rejected", subject)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+// RateLimiter limits the requests sent to a service with a token bucket: rate requests per second, up to burst at
+// once. A RateLimiter can be shared by the watchers using the same account, with WithRateLimiter or with the same
+// rate_group.
+type RateLimiter struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+	clk    Clock
+}
+
+// NewRateLimiter creates a RateLimiter, a rate not positive doesn't limit the requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := &RateLimiter{clk: clock.Real}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes the limit of the requests, the burst is at least 1
+func (l *RateLimiter) SetLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bucket = newTokenBucket(rate, burst, l.clk.Now())
+}
+
+// Limit returns the requests per second and the burst
+func (l *RateLimiter) Limit() (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket.rate, int(l.bucket.burst)
+}
+
+// Wait blocks until a request can be sent or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.bucket.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		d := l.bucket.take(l.clk.Now())
+		l.mu.Unlock()
+		if d == 0 {
+			return nil
+		}
+
+		select {
+		case <-l.clk.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rateConfiguration contains the client-side limit of the requests, shared by the configurations of the watchers of
+// remote services. RateLimit is in requests per second, zero disables it. The watchers with the same RateGroup share
+// the limiter, limited by the lowest rate and burst they configure.
+type rateConfiguration struct {
+	RateLimit float64 `json:"rate_limit,string"`
+	RateBurst int     `json:"rate_burst,string"`
+	RateGroup string  `json:"rate_group"`
+}
+
+func (c rateConfiguration) validateRate() error {
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate_limit can't be negative")
+	}
+	if c.RateBurst < 0 {
+		return fmt.Errorf("rate_burst can't be negative")
+	}
+	return nil
+}
+
+// rateGroups contains the limiters shared by the watchers of the same rate_group
+var rateGroups = struct {
+	sync.Mutex
+	groups map[string]*rateGroup
+}{groups: make(map[string]*rateGroup)}
+
+// rateGroup is a shared limiter and the limits configured by the watchers using it
+type rateGroup struct {
+	limiter *RateLimiter
+	members map[*WatcherBase]rateConfiguration
+}
+
+// joinRateGroup adds the watcher to the group of its configuration, leaving the previous one, and returns the limiter
+// of the group
+func joinRateGroup(w *WatcherBase, c rateConfiguration) *RateLimiter {
+	rateGroups.Lock()
+	defer rateGroups.Unlock()
+	leaveRateGroups(w, c.RateGroup)
+	g, ok := rateGroups.groups[c.RateGroup]
+	if !ok {
+		g = &rateGroup{limiter: NewRateLimiter(c.RateLimit, c.RateBurst), members: make(map[*WatcherBase]rateConfiguration)}
+		rateGroups.groups[c.RateGroup] = g
+	}
+	g.members[w] = c
+	g.update()
+	return g.limiter
+}
+
+// leaveRateGroups removes the watcher from the groups other than except, it's called holding the lock of rateGroups
+func leaveRateGroups(w *WatcherBase, except string) {
+	for name, g := range rateGroups.groups {
+		if _, ok := g.members[w]; !ok || name == except {
+			continue
+		}
+		delete(g.members, w)
+		if len(g.members) == 0 {
+			delete(rateGroups.groups, name)
+		} else {
+			g.update()
+		}
+	}
+}
+
+// update sets the lowest limit configured by the members, so a watcher can't loosen the limit of the other ones
+func (g *rateGroup) update() {
+	rate, burst := 0.0, 0
+	for _, c := range g.members {
+		b := c.RateBurst
+		if b < 1 {
+			b = 1
+		}
+		if rate == 0 || c.RateLimit < rate {
+			rate = c.RateLimit
+		}
+		if burst == 0 || b < burst {
+			burst = b
+		}
+	}
+	if r, b := g.limiter.Limit(); r != rate || b != burst {
+		g.limiter.SetLimit(rate, burst)
+	}
+}
+
+// leaveRateGroup removes the closed watcher from its rate_group
+func (w *WatcherBase) leaveRateGroup() {
+	rateGroups.Lock()
+	defer rateGroups.Unlock()
+	leaveRateGroups(w, "")
+}
+
+// WithRateLimiter limits the requests of the watcher with l, replacing the rate_limit of its configuration
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// rateLimiterSetter is implemented by all the watchers including WatcherBase
+type rateLimiterSetter interface {
+	setRateLimiter(l *RateLimiter)
+}
+
+func (w *WatcherBase) setRateLimiter(l *RateLimiter) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.limiter = l
+	w.fixedLimiter = true
+}
+
+// setRateLimit is called with a new configuration
+func (w *WatcherBase) setRateLimit(c rateConfiguration) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	if w.fixedLimiter {
+		return
+	}
+	if c.RateLimit > 0 && c.RateGroup != "" {
+		w.limiter = joinRateGroup(w, c)
+		return
+	}
+	w.leaveRateGroup()
+	if c.RateLimit <= 0 {
+		w.limiter = nil
+	} else {
+		w.limiter = NewRateLimiter(c.RateLimit, c.RateBurst)
+	}
+}
+
+// throttle waits for the rate limiter of the watcher before a request to the service
+func (w *WatcherBase) throttle(ctx context.Context) error {
+	w.statusMu.Lock()
+	l := w.limiter
+	w.statusMu.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.Wait(ctx)
+}
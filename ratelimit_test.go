@@ -0,0 +1,170 @@
+package cloudwatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+	"github.com/Matrix86/cloudwatcher/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/minio/minio-go/v7"
+)
+
+func newTestRateLimiter(rate float64, burst int) (*RateLimiter, *clock.Fake) {
+	clk := clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	l := &RateLimiter{clk: clk}
+	l.SetLimit(rate, burst)
+	return l, clk
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	l, clk := newTestRateLimiter(1, 2)
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(context.Background())
+	}()
+	clk.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatalf("the burst has been used")
+	default:
+	}
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("%s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("wrong error returned: %v", err)
+	}
+}
+
+func TestS3Watcher_RateLimit(t *testing.T) {
+	newWatcher := func(config map[string]string, opts ...Option) Watcher {
+		w, err := New("s3", "/", time.Minute, opts...)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		config["bucket_name"] = "test"
+		config["endpoint"] = "localhost:9000"
+		if err := w.SetConfig(config); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return w
+	}
+
+	w := newWatcher(map[string]string{"rate_limit": "5", "rate_burst": "10"})
+	if s := w.Status(); s.RateLimit != 5 || s.RateBurst != 10 {
+		t.Errorf("wrong limit: %v %v", s.RateLimit, s.RateBurst)
+	}
+
+	// the watchers of the same group share the limiter
+	a := newWatcher(map[string]string{"rate_limit": "2", "rate_group": "account"})
+	b := newWatcher(map[string]string{"rate_limit": "2", "rate_group": "account"})
+	if a.(*S3Watcher).limiter != b.(*S3Watcher).limiter {
+		t.Errorf("the limiter should be shared")
+	}
+
+	// the lowest limit of the group is used
+	c := newWatcher(map[string]string{"rate_limit": "10", "rate_burst": "5", "rate_group": "account"})
+	if s := c.Status(); s.RateLimit != 2 || s.RateBurst != 1 {
+		t.Errorf("a watcher can't loosen the limit of the group: %v %v", s.RateLimit, s.RateBurst)
+	}
+	a.Close()
+	b.Close()
+	if s := c.Status(); s.RateLimit != 10 || s.RateBurst != 5 {
+		t.Errorf("the limit of the closed watchers should be removed: %v %v", s.RateLimit, s.RateBurst)
+	}
+	if err := c.SetConfig(map[string]string{"bucket_name": "test", "endpoint": "localhost:9000", "rate_limit": "3", "rate_group": "account"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s := c.Status(); s.RateLimit != 3 {
+		t.Errorf("the limit of the group should be updated: %v", s.RateLimit)
+	}
+	c.Close()
+
+	l := NewRateLimiter(1, 1)
+	w = newWatcher(map[string]string{"rate_limit": "5"}, WithRateLimiter(l))
+	if s := w.Status(); s.RateLimit != 1 || s.RateBurst != 1 {
+		t.Errorf("the limiter of the option should be used: %v %v", s.RateLimit, s.RateBurst)
+	}
+
+	if err := w.SetConfig(map[string]string{"rate_limit": "-1"}); err == nil {
+		t.Errorf("the limit can't be negative")
+	}
+}
+
+func TestThrottledMinio_ListObjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+	w := &WatcherBase{}
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			if ctx.Value(throttleKey{}) != w {
+				t.Errorf("the watcher is not in the context of the requests")
+			}
+			out := make(chan minio.ObjectInfo)
+			close(out)
+			return out
+		},
+	)
+	c := &throttledMinio{IMinio: m, w: w}
+	for range c.ListObjects(context.Background(), "test", minio.ListObjectsOptions{}) {
+	}
+}
+
+func TestThrottledTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := &http.Client{Transport: &throttledTransport{RoundTripper: http.DefaultTransport}}
+	get := func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// a token for each request, as each page of a listing
+	l, clk := newTestRateLimiter(1, 1)
+	ctx := context.WithValue(context.Background(), throttleKey{}, &WatcherBase{limiter: l})
+	if err := get(ctx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := get(context.Background()); err != nil {
+		t.Errorf("the requests without a watcher are not throttled: %s", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- get(ctx)
+	}()
+	clk.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatalf("the request should wait")
+	default:
+	}
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("%s", err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := get(cctx); err == nil {
+		t.Errorf("the request should fail")
+	}
+}
@@ -0,0 +1,60 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+)
+
+// Reconfigurer is implemented by the watchers that can change their configuration while they are running
+type Reconfigurer interface {
+	Reconfigure(ctx context.Context, config map[string]string) error
+}
+
+// Reconfigure validates the new configuration of the watcher and applies it between two synchronizations. The cache
+// is kept if the watched storage (bucket, repository...) is the same, otherwise it is cleared and the next
+// synchronization sends a CacheReset event instead of the events of the objects already stored.
+func Reconfigure(ctx context.Context, w Watcher, config map[string]string) error {
+	r, ok := w.(Reconfigurer)
+	if !ok {
+		return fmt.Errorf("the watcher doesn't support the reconfiguration")
+	}
+	return r.Reconfigure(ctx, config)
+}
+
+// lockCycle waits for the end of the running synchronization: the configuration and the cache of a watcher are
+// changed only holding the cycle
+func (w *WatcherBase) lockCycle(ctx context.Context) error {
+	w.cycleOnce.Do(func() {
+		w.cycle = make(chan struct{}, 1)
+	})
+	select {
+	case w.cycle <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *WatcherBase) unlockCycle() {
+	<-w.cycle
+}
+
+// markCacheReset is called holding the cycle after clearing the cache, the objects of the keys mode are cleared too
+func (w *WatcherBase) markCacheReset() {
+	w.keyCache = nil
+	w.cacheReset = true
+}
+
+// checkCacheReset is called holding the cycle at the beginning of a synchronization: if the cache has been cleared
+// it sends the CacheReset event, and the synchronization has to fill the cache again without sending events as the
+// first one does. It returns true if the synchronization is the first one.
+func (w *WatcherBase) checkCacheReset(firstSync bool) bool {
+	if !w.cacheReset {
+		return firstSync
+	}
+	w.cacheReset = false
+	if !firstSync {
+		w.Events <- Event{Type: CacheReset}
+	}
+	return true
+}
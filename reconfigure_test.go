@@ -0,0 +1,123 @@
+package cloudwatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+func TestS3Watcher_Reconfigure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+
+	defer func(f func(*s3Configuration) (IMinio, error)) { newS3Client = f }(newS3Client)
+	newS3Client = func(*s3Configuration) (IMinio, error) { return m, nil }
+
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Not("missing")).Return(true, nil).AnyTimes()
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Eq("missing")).Return(false, nil).AnyTimes()
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			out := make(chan minio.ObjectInfo, 1)
+			out <- minio.ObjectInfo{
+				ETag:         "xxx",
+				Key:          "filename.test",
+				LastModified: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC),
+				Size:         100,
+			}
+			close(out)
+			return out
+		},
+	).AnyTimes()
+	tag, _ := tags.NewTags(map[string]string{}, true)
+	m.EXPECT().GetObjectTagging(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(tag, nil).AnyTimes()
+
+	w, _ := newS3Watcher("/", time.Minute)
+	sw := w.(*S3Watcher)
+	if err := sw.SetConfig(map[string]string{"bucket_name": "first"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.sync(true)
+
+	ctx := context.Background()
+	if err := sw.Reconfigure(ctx, map[string]string{"bucket_name": "missing"}); err == nil {
+		t.Errorf("the bucket doesn't exist")
+	}
+	if err := sw.Reconfigure(ctx, map[string]string{"bucket_name": "first", "token_file": "x"}); err == nil {
+		t.Errorf("the key token_file is not supported")
+	}
+	if sw.config.BucketName != "first" {
+		t.Errorf("the configuration has been changed by an invalid one")
+	}
+
+	// same bucket: the cache is kept
+	if err := sw.Reconfigure(ctx, map[string]string{"bucket_name": "first", "region": "eu"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.sync(false)
+	select {
+	case e := <-sw.GetEvents():
+		t.Errorf("unexpected event: %s %s", e.TypeString(), e.Key)
+	default:
+	}
+
+	// different bucket: the cache is cleared and only a CacheReset is sent
+	if err := sw.Reconfigure(ctx, map[string]string{"bucket_name": "second"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.sync(false)
+	select {
+	case e := <-sw.GetEvents():
+		if e.Type != CacheReset {
+			t.Errorf("wrong type event received: %s", e.TypeString())
+		}
+	default:
+		t.Errorf("CacheReset event not received")
+	}
+	select {
+	case e := <-sw.GetEvents():
+		t.Errorf("unexpected event: %s %s", e.TypeString(), e.Key)
+	default:
+	}
+	if _, ok := sw.cache["filename.test"]; !ok {
+		t.Errorf("the cache has not been filled again")
+	}
+}
+
+func TestMemoryWatcher_Reconfigure(t *testing.T) {
+	w := newTestMemoryWatcher(t, time.Minute, nil)
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer w.Close()
+
+	ctx := context.Background()
+	if err := Reconfigure(ctx, w, map[string]string{"max_deletes": "1"}); err != nil {
+		t.Errorf("%s", err)
+	}
+	if err := Reconfigure(ctx, w, map[string]string{"max_deletes": "x"}); err == nil {
+		t.Errorf("the wrong configuration should be rejected")
+	}
+
+	// the context is checked while waiting for the running synchronization
+	w.lockCycle(ctx)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := Reconfigure(cctx, w, map[string]string{"max_deletes": "1"}); err != context.DeadlineExceeded {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	w.unlockCycle()
+}
+
+func TestReconfigure(t *testing.T) {
+	s3, _ := New("s3", "/", time.Minute)
+	m, _ := NewMux(s3)
+	if err := Reconfigure(context.Background(), m, map[string]string{}); err == nil {
+		t.Errorf("the Mux doesn't support the reconfiguration")
+	}
+}
@@ -0,0 +1,90 @@
+// Package redissink implements a cloudwatcher.Sink appending the events to Redis Streams.
+package redissink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config contains the options of the Sink
+type Config struct {
+	// Stream is the template of the stream name, it can contain the placeholders {backend}, {op} and {key}
+	// (default "cloudwatcher:{backend}")
+	Stream string
+	// MaxLen, if greater than 0, trims the streams to about MaxLen entries
+	MaxLen int64
+	// Timeout is the max time waiting for the confirmation of a batch (default 10s)
+	Timeout time.Duration
+}
+
+// Sink appends the events to Redis Streams. Each entry contains the fields "id" (the stable ID of the event,
+// to discard the duplicates), "key", "type" and "event" (the JSON of the event).
+type Sink struct {
+	config Config
+	client redis.UniversalClient
+}
+
+// New creates a Sink using the redis client
+func New(client redis.UniversalClient, c Config) (*Sink, error) {
+	if client == nil {
+		return nil, fmt.Errorf("redis client needed")
+	}
+	if c.Stream == "" {
+		c.Stream = "cloudwatcher:{backend}"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return &Sink{
+		config: c,
+		client: client,
+	}, nil
+}
+
+// Send appends the events with a single pipeline, it returns when Redis confirmed all of them
+func (s *Sink) Send(ctx context.Context, events []cloudwatcher.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, len(events))
+	for _, e := range events {
+		data, err := cloudwatcher.MarshalEvent(e)
+		if err != nil {
+			return fmt.Errorf("encoding event '%s': %s", e.Key, err)
+		}
+		cmds = append(cmds, pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: cloudwatcher.ExpandTemplate(s.config.Stream, e),
+			MaxLen: s.config.MaxLen,
+			Approx: s.config.MaxLen > 0,
+			Values: []interface{}{
+				"id", cloudwatcher.EventID(e),
+				"key", e.Key,
+				"type", e.TypeString(),
+				"event", string(data),
+			},
+		}))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		for i, cmd := range cmds {
+			if cmd.Err() != nil {
+				return fmt.Errorf("adding '%s' to the stream: %s", events[i].Key, cmd.Err())
+			}
+		}
+		return fmt.Errorf("adding events to the stream: %s", err)
+	}
+	return nil
+}
+
+// Close releases the resources of the sink, the client is not closed
+func (s *Sink) Close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package redissink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Matrix86/cloudwatcher"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestSink_Send(t *testing.T) {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+
+	s, err := New(client, Config{Stream: "files:{backend}:{op}"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	events := []cloudwatcher.Event{
+		{Key: "a.txt", Type: cloudwatcher.FileCreated, Object: &cloudwatcher.DropboxObject{Key: "a.txt"}},
+		{Key: "b.txt", Type: cloudwatcher.FileCreated, Object: &cloudwatcher.DropboxObject{Key: "b.txt"}},
+		{Key: "b.txt", Type: cloudwatcher.FileDeleted, Object: &cloudwatcher.DropboxObject{Key: "b.txt"}},
+	}
+	if err := s.Send(context.Background(), events); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	entries, err := client.XRange(context.Background(), "files:dropbox:FileCreated", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("wrong number of entries: %d", len(entries))
+	}
+	if entries[0].Values["key"] != "a.txt" || entries[0].Values["id"] != cloudwatcher.EventID(events[0]) {
+		t.Errorf("wrong entry: %v", entries[0].Values)
+	}
+
+	n, _ := client.XLen(context.Background(), "files:dropbox:FileDeleted").Result()
+	if n != 1 {
+		t.Errorf("wrong number of entries: %d", n)
+	}
+
+	srv.Close()
+	if err := s.Send(context.Background(), events); err == nil {
+		t.Errorf("an error should be returned if redis is unreachable")
+	}
+}
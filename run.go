@@ -0,0 +1,173 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// HandlerFunc processes an event received by the watcher
+type HandlerFunc func(ctx context.Context, e Event) error
+
+// RetryPolicy defines how the failed handlers are retried
+type RetryPolicy struct {
+	MaxAttempts int              // executions of the handler for each event, 0 or 1 disables the retries
+	Backoff     Backoff          // delay between two attempts
+	Retryable   func(error) bool // if set, only the errors for which it returns true are retried
+}
+
+// RunOptions contains the options of Run
+type RunOptions struct {
+	Workers int           // size of the worker pool (default runtime.NumCPU())
+	Timeout time.Duration // timeout of each handler execution, 0 means no timeout
+	Retry   RetryPolicy   // policy applied to the errors returned by the handler
+	OnError func(error)   // receives the errors of the watcher and of the handlers after the last attempt
+}
+
+// Run consumes the events of the watcher calling handler on a bounded worker pool.
+// Events with the same Key are processed in order, different keys run in parallel on any idle worker.
+// It returns when ctx is cancelled or the watcher is closed, after the in-flight handlers finish.
+func Run(ctx context.Context, w Watcher, handler HandlerFunc, opts *RunOptions) error {
+	return runEvents(ctx, w.GetEvents(), w.GetErrors(), handler, opts)
+}
+
+func runEvents(ctx context.Context, events <-chan Event, errors <-chan error, handler HandlerFunc, opts *RunOptions) error {
+	if handler == nil {
+		return fmt.Errorf("handler needed")
+	}
+	o := RunOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	ready := make(chan Event)
+	handled := make(chan string)
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range ready {
+				// the events still queued are discarded after the cancellation
+				if ctx.Err() == nil {
+					if err := handleEvent(ctx, handler, e, &o); err != nil && o.OnError != nil {
+						o.OnError(fmt.Errorf("handling event '%s' (%s): %s", e.Key, e.TypeString(), err))
+					}
+				}
+				handled <- e.Key
+			}
+		}()
+	}
+
+	shutdown := func() {
+		close(ready)
+		go func() {
+			wg.Wait()
+			close(handled)
+		}()
+		for range handled {
+		}
+	}
+
+	// runnable are the events ready for a worker, waiting the ones of the keys with a running handler. The keys in
+	// waiting have a running handler, so the events of a key are handled in order.
+	runnable := make([]Event, 0)
+	waiting := make(map[string][]Event)
+	queued := 0
+	maxQueued := o.Workers * 16
+	for events != nil || len(waiting) != 0 {
+		in := events
+		if queued >= maxQueued {
+			in = nil
+		}
+		var out chan Event
+		var next Event
+		if len(runnable) != 0 {
+			out = ready
+			next = runnable[0]
+		}
+
+		select {
+		case e, ok := <-in:
+			if !ok {
+				events = nil
+				continue
+			}
+			queued++
+			if queue, running := waiting[e.Key]; running {
+				waiting[e.Key] = append(queue, e)
+				continue
+			}
+			waiting[e.Key] = nil
+			runnable = append(runnable, e)
+
+		case out <- next:
+			runnable = runnable[1:]
+			queued--
+
+		case key := <-handled:
+			queue := waiting[key]
+			if len(queue) == 0 {
+				delete(waiting, key)
+				continue
+			}
+			runnable = append(runnable, queue[0])
+			waiting[key] = queue[1:]
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			if o.OnError != nil {
+				o.OnError(err)
+			}
+
+		case <-ctx.Done():
+			shutdown()
+			return ctx.Err()
+		}
+	}
+	shutdown()
+	return nil
+}
+
+func handleEvent(ctx context.Context, handler HandlerFunc, e Event, o *RunOptions) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = callHandler(ctx, handler, e, o.Timeout)
+		if err == nil {
+			return nil
+		}
+		if attempt >= o.Retry.MaxAttempts || (o.Retry.Retryable != nil && !o.Retry.Retryable(err)) {
+			return err
+		}
+
+		t := time.NewTimer(o.Retry.Backoff.Duration(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+func callHandler(ctx context.Context, handler HandlerFunc, e Event, timeout time.Duration) (err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, e)
+}
@@ -0,0 +1,210 @@
+package cloudwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun_Order(t *testing.T) {
+	w := newChanWatcher()
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	done := make(chan bool)
+	count := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		Run(ctx, w, func(ctx context.Context, e Event) error {
+			var n int
+			fmt.Sscanf(e.Object.(string), "%d", &n)
+			mu.Lock()
+			defer mu.Unlock()
+			received[e.Key] = append(received[e.Key], n)
+			count++
+			if count == 30 {
+				close(done)
+			}
+			return nil
+		}, &RunOptions{Workers: 4})
+	}()
+
+	for i := 0; i < 10; i++ {
+		for _, k := range []string{"a", "b", "c"} {
+			w.Events <- Event{Key: k, Type: FileChanged, Object: fmt.Sprintf("%d", i)}
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("events not handled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for k, list := range received {
+		for i, n := range list {
+			if n != i {
+				t.Errorf("events of key '%s' handled out of order: %v", k, list)
+				break
+			}
+		}
+	}
+}
+
+func TestRun_Parallel(t *testing.T) {
+	w := newChanWatcher()
+	var running, max int32
+
+	started := make(chan bool, 8)
+	release := make(chan bool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- Run(ctx, w, func(ctx context.Context, e Event) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			started <- true
+			<-release
+			atomic.AddInt32(&running, -1)
+			return nil
+		}, &RunOptions{Workers: 64})
+	}()
+
+	for i := 0; i < 8; i++ {
+		w.Events <- Event{Key: fmt.Sprintf("file%d", i)}
+	}
+	// all the handlers run at the same time
+	for i := 0; i < 8; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the events are not handled in parallel")
+		}
+	}
+	cancel()
+	select {
+	case err := <-result:
+		t.Fatalf("Run returned before the in-flight handlers finished: %v", err)
+	default:
+	}
+	close(release)
+
+	if err := <-result; err != context.Canceled {
+		t.Errorf("Run should return the context error, got %v", err)
+	}
+	if atomic.LoadInt32(&running) != 0 {
+		t.Errorf("Run returned before the in-flight handlers finished")
+	}
+	if atomic.LoadInt32(&max) < 2 {
+		t.Errorf("different keys should be handled in parallel")
+	}
+}
+
+func TestRun_Retry(t *testing.T) {
+	w := newChanWatcher()
+	errFatal := errors.New("fatal")
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	failures := make(chan error, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, w, func(ctx context.Context, e Event) error {
+		mu.Lock()
+		attempts[e.Key]++
+		mu.Unlock()
+		switch e.Key {
+		case "fatal":
+			return errFatal
+		case "timeout":
+			<-ctx.Done()
+			return ctx.Err()
+		default:
+			return errors.New("temporary")
+		}
+	}, &RunOptions{
+		Timeout: 10 * time.Millisecond,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     Backoff{Initial: time.Millisecond},
+			Retryable:   func(err error) bool { return err != errFatal },
+		},
+		OnError: func(err error) { failures <- err },
+	})
+
+	w.Events <- Event{Key: "fatal"}
+	w.Events <- Event{Key: "temporary"}
+	w.Events <- Event{Key: "timeout"}
+	w.Errors <- errors.New("watcher error")
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-failures:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("error not reported")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["fatal"] != 1 {
+		t.Errorf("not retryable errors should not be retried: %d attempts", attempts["fatal"])
+	}
+	if attempts["temporary"] != 3 {
+		t.Errorf("temporary errors should be retried 3 times: %d attempts", attempts["temporary"])
+	}
+	if attempts["timeout"] != 3 {
+		t.Errorf("timed out handlers should be retried 3 times: %d attempts", attempts["timeout"])
+	}
+}
+
+func TestRun_HeadOfLine(t *testing.T) {
+	w := newChanWatcher()
+	release := make(chan bool)
+	handled := make(chan string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, w, func(ctx context.Context, e Event) error {
+		if e.Key == "slow" {
+			<-release
+		}
+		handled <- e.Key
+		return nil
+	}, &RunOptions{Workers: 2})
+
+	// the other keys are handled by the idle worker while the slow one is running
+	w.Events <- Event{Key: "slow"}
+	for i := 0; i < 5; i++ {
+		w.Events <- Event{Key: fmt.Sprintf("file%d", i)}
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case k := <-handled:
+			if k == "slow" {
+				t.Fatalf("the slow handler has not been released")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the events are blocked by the slow handler")
+		}
+	}
+	close(release)
+	if k := <-handled; k != "slow" {
+		t.Errorf("wrong event handled: %s", k)
+	}
+}
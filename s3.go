@@ -4,21 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
 type objectInfo = minio.ObjectInfo
 type s3Configuration struct {
 	BucketName           string `json:"bucket_name"`
 	Endpoint             string `json:"endpoint"`
-	AccessKey            string `json:"access_key"`
-	SecretAccessKey      string `json:"secret_key"`
-	SessionToken         string `json:"token"`
+	AccessKey            string `json:"access_key" secret:"true"`
+	SecretAccessKey      string `json:"secret_key" secret:"true"`
+	SessionToken         string `json:"token" secret:"true"`
 	Region               string `json:"region"`
 	SSLEnabled           Bool   `json:"ssl_enabled"`
 	UseAWSIAMCredentials Bool   `json:"aws_iam_credentials"`
@@ -26,6 +30,10 @@ type s3Configuration struct {
 	UseAWSFile           Bool   `json:"aws_file"`
 	AWSFileName          string `json:"aws_file_name"`
 	AWSFileProfile       string `json:"aws_file_profile"`
+
+	guardConfiguration
+	keysConfiguration
+	rateConfiguration
 }
 
 // S3Watcher is the specialized watcher for Amazon S3 service
@@ -34,7 +42,7 @@ type S3Watcher struct {
 
 	syncing uint32
 
-	ticker *time.Ticker
+	ticker Ticker
 	stop   chan bool
 	config *s3Configuration
 	client IMinio
@@ -43,11 +51,11 @@ type S3Watcher struct {
 
 // S3Object is the object that contains the info of the file
 type S3Object struct {
-	Key          string
-	Etag         string
-	Size         int64
-	Tags         map[string]string
-	LastModified time.Time
+	Key          string            `json:"key"`
+	Etag         string            `json:"etag"`
+	Size         int64             `json:"size"`
+	Tags         map[string]string `json:"tags"`
+	LastModified time.Time         `json:"last_modified"`
 }
 
 func newS3Watcher(dir string, interval time.Duration) (Watcher, error) {
@@ -56,10 +64,11 @@ func newS3Watcher(dir string, interval time.Duration) (Watcher, error) {
 		config: nil,
 		stop:   make(chan bool, 1),
 		WatcherBase: WatcherBase{
-			Events:      make(chan Event, 100),
-			Errors:      make(chan error, 100),
-			watchDir:    dir,
-			pollingTime: interval,
+			Events:       make(chan Event, 100),
+			Errors:       make(chan error, 100),
+			watchDir:     dir,
+			pollingTime:  interval,
+			syncRequests: make(chan bool, 1),
 		},
 	}
 	return upd, nil
@@ -67,37 +76,188 @@ func newS3Watcher(dir string, interval time.Duration) (Watcher, error) {
 
 // SetConfig is used to configure the S3Watcher
 func (u *S3Watcher) SetConfig(m map[string]string) error {
-	j, err := json.Marshal(m)
+	if err := u.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(context.Background(), m, s3Configuration{})
 	if err != nil {
 		return err
 	}
-
-	config := s3Configuration{}
-	if err := json.Unmarshal(j, &config); err != nil {
+	config, client, err := parseS3Config(resolved)
+	if err != nil {
 		return err
 	}
-	u.config = &config
 
-	options := minio.Options{
-		Secure: bool(u.config.SSLEnabled),
+	u.lockCycle(context.Background())
+	defer u.unlockCycle()
+	u.config = config
+	u.client = &throttledMinio{IMinio: client, w: &u.WatcherBase}
+	if u.setKeys(config.keysConfiguration) {
+		u.cache = make(map[string]*S3Object)
+		u.markCacheReset()
 	}
+	u.setRateLimit(config.rateConfiguration)
+	u.setRawConfig(m, resolved)
+	return nil
+}
 
-	if u.config.UseAWSFile {
-		options.Creds = credentials.NewFileAWSCredentials(u.config.AWSFileName, u.config.AWSFileProfile)
-	} else if u.config.UseAWSIAMCredentials {
-		options.Creds = credentials.NewIAM(u.config.AWSIAMEndpoint)
-	} else {
-		options.Creds = credentials.NewStaticV4(u.config.AccessKey, u.config.SecretAccessKey, u.config.SessionToken)
+// Reconfigure validates the new configuration, checking the bucket, and applies it between two synchronizations.
+// The cache is cleared if the endpoint or the bucket change.
+func (u *S3Watcher) Reconfigure(ctx context.Context, m map[string]string) error {
+	if err := u.Capabilities().CheckConfig(m); err != nil {
+		return err
+	}
+	resolved, err := resolveSecrets(ctx, m, s3Configuration{})
+	if err != nil {
+		return err
+	}
+	config, client, err := parseS3Config(resolved)
+	if err != nil {
+		return err
+	}
+	if err := u.throttle(ctx); err != nil {
+		return err
+	}
+	if found, err := client.BucketExists(ctx, config.BucketName); err != nil {
+		return fmt.Errorf("error on checking the bucket: %s", err)
+	} else if !found {
+		return fmt.Errorf("error on checking the bucket: bucket %s not exists", config.BucketName)
 	}
 
-	client, err := minio.New(u.config.Endpoint, &options)
+	if err := u.lockCycle(ctx); err != nil {
+		return err
+	}
+	defer u.unlockCycle()
+	if u.config != nil && (u.config.Endpoint != config.Endpoint || u.config.BucketName != config.BucketName) {
+		u.cache = make(map[string]*S3Object)
+		u.markCacheReset()
+	}
+	u.config = config
+	u.client = &throttledMinio{IMinio: client, w: &u.WatcherBase}
+	if u.setKeys(config.keysConfiguration) {
+		u.cache = make(map[string]*S3Object)
+		u.markCacheReset()
+	}
+	u.setRateLimit(config.rateConfiguration)
+	u.setRawConfig(m, resolved)
+	return nil
+}
+
+// applySecrets replaces the client with one using the secrets resolved again
+func (u *S3Watcher) applySecrets(m map[string]string) error {
+	config, client, err := parseS3Config(m)
 	if err != nil {
 		return err
 	}
-	u.client = client
+	u.config = config
+	u.client = &throttledMinio{IMinio: client, w: &u.WatcherBase}
 	return nil
 }
 
+// throttledMinio waits for the rate limiter of the watcher before the requests of the client
+type throttledMinio struct {
+	IMinio
+	w *WatcherBase
+}
+
+func (c *throttledMinio) BucketExists(ctx context.Context, bucketName string) (bool, error) {
+	if err := c.w.throttle(ctx); err != nil {
+		return false, err
+	}
+	return c.IMinio.BucketExists(ctx, bucketName)
+}
+
+func (c *throttledMinio) GetObjectTagging(ctx context.Context, bucketName, objectName string, opts minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+	if err := c.w.throttle(ctx); err != nil {
+		return nil, err
+	}
+	return c.IMinio.GetObjectTagging(ctx, bucketName, objectName, opts)
+}
+
+func (c *throttledMinio) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	if err := c.w.throttle(ctx); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return c.IMinio.StatObject(ctx, bucketName, objectName, opts)
+}
+
+// ListObjects waits for the limiter before every request of the listing: the pages are requested by the client, and
+// its throttledTransport finds the watcher in the context
+func (c *throttledMinio) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	return c.IMinio.ListObjects(context.WithValue(ctx, throttleKey{}, c.w), bucketName, opts)
+}
+
+// throttleKey is the key of the context of the requests holding the watcher to throttle
+type throttleKey struct{}
+
+// throttledTransport waits for the rate limiter of the watcher in the context of the request, if any
+type throttledTransport struct {
+	http.RoundTripper
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if w, ok := req.Context().Value(throttleKey{}).(*WatcherBase); ok {
+		if err := w.throttle(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// isS3CredentialError returns true if the request has been rejected because of the credentials
+func isS3CredentialError(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken", "AccessDenied":
+		return true
+	}
+	return false
+}
+
+func parseS3Config(m map[string]string) (*s3Configuration, IMinio, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &s3Configuration{}
+	if err := json.Unmarshal(j, config); err != nil {
+		return nil, nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := config.validateRate(); err != nil {
+		return nil, nil, err
+	}
+	client, err := newS3Client(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, client, nil
+}
+
+// newS3Client creates the client used with the configuration, the tests replace it
+var newS3Client = func(config *s3Configuration) (IMinio, error) {
+	transport, err := minio.DefaultTransport(bool(config.SSLEnabled))
+	if err != nil {
+		return nil, err
+	}
+	options := minio.Options{
+		Secure:    bool(config.SSLEnabled),
+		Transport: &throttledTransport{RoundTripper: transport},
+	}
+
+	if config.UseAWSFile {
+		options.Creds = credentials.NewFileAWSCredentials(config.AWSFileName, config.AWSFileProfile)
+	} else if config.UseAWSIAMCredentials {
+		options.Creds = credentials.NewIAM(config.AWSIAMEndpoint)
+	} else {
+		options.Creds = credentials.NewStaticV4(config.AccessKey, config.SecretAccessKey, config.SessionToken)
+	}
+
+	return minio.New(config.Endpoint, &options)
+}
+
 // Start launches the polling process
 func (u *S3Watcher) Start() error {
 	if u.config == nil {
@@ -110,13 +270,19 @@ func (u *S3Watcher) Start() error {
 		return fmt.Errorf("error on checking the bucket: bucket %s not exists", u.config.BucketName)
 	}
 
-	u.ticker = time.NewTicker(u.pollingTime)
+	if u.scheduler != nil {
+		return u.startScheduled(u)
+	}
+	u.ticker = u.getClock().NewTicker(u.pollingTime)
 	go func() {
 		// launch synchronization also the first time
 		u.sync(true)
 		for {
 			select {
-			case <-u.ticker.C:
+			case <-u.ticker.C():
+				u.sync(false)
+
+			case <-u.syncRequests:
 				u.sync(false)
 
 			case <-u.stop:
@@ -131,11 +297,50 @@ func (u *S3Watcher) Start() error {
 
 // Close stop the polling process
 func (u *S3Watcher) Close() {
+	u.leaveRateGroup()
+	if u.closeScheduled(nil) {
+		return
+	}
 	if u.stop != nil {
 		u.stop <- true
 	}
 }
 
+func (u *S3Watcher) service() string {
+	return "s3"
+}
+
+// schedulingGroup returns the group of the watcher in a Scheduler: the endpoint and the access key
+func (u *S3Watcher) schedulingGroup() string {
+	return "s3:" + u.config.Endpoint + "/" + credentialID(u.config.AccessKey)
+}
+
+func (u *S3Watcher) source() string {
+	bucket := ""
+	if u.config != nil {
+		bucket = u.config.BucketName
+	}
+	return "s3://" + path.Join(bucket, u.watchDir)
+}
+
+// String returns the URI of the watcher, the secrets are redacted
+func (u *S3Watcher) String() string {
+	uri := &url.URL{Scheme: "s3", Path: "/" + strings.TrimPrefix(u.watchDir, "/")}
+	if u.config != nil {
+		uri.Host = u.config.BucketName
+	}
+	return formatURI(uri, u.pollingTime, u.config, u.rawConfig, "bucket_name")
+}
+
+// Capabilities returns the features of S3: the objects have tags and an ETag
+func (u *S3Watcher) Capabilities() Capabilities {
+	return Capabilities{
+		Tags:        true,
+		ContentHash: true,
+		ConfigKeys:  configKeys(s3Configuration{}),
+	}
+}
+
 func (u *S3Watcher) getCachedObject(o *S3Object) *S3Object {
 	if cachedObject, ok := u.cache[o.Key]; ok {
 		return cachedObject
@@ -143,22 +348,73 @@ func (u *S3Watcher) getCachedObject(o *S3Object) *S3Object {
 	return nil
 }
 
-func (u *S3Object) areTagsChanged(new *S3Object) bool {
-	// Check if tags are changed
-	if len(u.Tags) != len(new.Tags) {
-		return true
+func (u *S3Object) cacheKey() string {
+	return u.Key
+}
+
+func (u *S3Object) eventKey() string {
+	return u.Key
+}
+
+func (u *S3Object) backend() string {
+	return "s3"
+}
+
+func (u *S3Object) size() int64 {
+	return u.Size
+}
+
+func (u *S3Object) modTime() time.Time {
+	return u.LastModified
+}
+
+func (u *S3Object) hash() string {
+	return u.Etag
+}
+
+func (u *S3Object) changes(cached snapshotObject) []Op {
+	c := cached.(*S3Object)
+	ops := make([]Op, 0)
+	// Check if the LastModified has been changed
+	if !c.LastModified.Equal(u.LastModified) || c.Size != u.Size {
+		ops = append(ops, FileChanged)
 	}
-	for k, v := range u.Tags {
-		if nv, ok := new.Tags[k]; !ok || v != nv {
-			return true
-		}
+	// Check if the tags have been updated
+	if !equalTags(c.Tags, u.Tags) {
+		ops = append(ops, TagsChanged)
+	}
+	return ops
+}
+
+func (u *S3Watcher) list() ([]snapshotObject, error) {
+	if u.config == nil {
+		return nil, fmt.Errorf("configuration for S3 needed")
+	}
+
+	// waiting for the running sync
+	u.lockCycle(context.Background())
+	defer u.unlockCycle()
+
+	if found, err := u.bucketExists(u.config.BucketName); found == false || err != nil {
+		return nil, fmt.Errorf("bucket '%s' not found: %s", u.config.BucketName, err)
 	}
-	for k, v := range new.Tags {
-		if nv, ok := u.Tags[k]; !ok || v != nv {
-			return true
+
+	// an object missing from the snapshot would be reported as deleted by Diff: the listing fails instead
+	objects := make([]snapshotObject, 0)
+	var infoErr error
+	err := u.enumerateFiles(u.config.BucketName, u.watchDir, func(page int64, obj *objectInfo) bool {
+		upd, err := u.getInfoFromObject(obj)
+		if err != nil {
+			infoErr = err
+			return false
 		}
+		objects = append(objects, upd)
+		return true
+	})
+	if err == nil {
+		err = infoErr
 	}
-	return false
+	return objects, err
 }
 
 func (u *S3Watcher) sync(firstSync bool) {
@@ -167,9 +423,30 @@ func (u *S3Watcher) sync(firstSync bool) {
 		return
 	}
 	defer atomic.StoreUint32(&u.syncing, 0)
-
-	if found, err := u.bucketExists(u.config.BucketName); found == false || err != nil {
-		u.Errors <- fmt.Errorf("bucket '%s' not found: %s", u.config.BucketName, err)
+	u.syncStarted()
+	defer u.syncDone()
+
+	u.lockCycle(context.Background())
+	defer u.unlockCycle()
+	firstSync = u.checkCacheReset(firstSync)
+
+	if found, err := u.bucketExists(u.config.BucketName); err != nil {
+		checkErr := fmt.Errorf("checking bucket '%s': %s", u.config.BucketName, err)
+		if isS3CredentialError(err) {
+			u.Errors <- &CredentialError{Err: checkErr}
+			u.refreshSecrets(u.applySecrets)
+			return
+		}
+		u.Errors <- checkErr
+		return
+	} else if !found {
+		u.Errors <- &NotFoundError{Resource: fmt.Sprintf("bucket '%s'", u.config.BucketName)}
+		return
+	}
+	if u.keysMode() {
+		if err := u.syncKeys(firstSync, u.config.guardConfiguration, u.lookupKey); isS3CredentialError(err) {
+			u.refreshSecrets(u.applySecrets)
+		}
 		return
 	}
 
@@ -179,6 +456,11 @@ func (u *S3Watcher) sync(firstSync bool) {
 		// Get Info from S3 object
 		upd, err := u.getInfoFromObject(obj)
 		if err != nil {
+			u.Errors <- err
+			// the object is still there: keeping the cached one
+			if cached, ok := u.cache[obj.Key]; ok {
+				fileList[obj.Key] = cached
+			}
 			return true // continue
 		}
 
@@ -190,20 +472,10 @@ func (u *S3Watcher) sync(firstSync bool) {
 			cached := u.getCachedObject(upd)
 			// Object has been cached previously by Key
 			if cached != nil {
-				// Check if the LastModified has been changed
-				if !cached.LastModified.Equal(upd.LastModified) || cached.Size != upd.Size {
-					event := Event{
-						Key:    upd.Key,
-						Type:   FileChanged,
-						Object: upd,
-					}
-					u.Events <- event
-				}
-				// Check if the tags have been updated
-				if cached.areTagsChanged(upd) {
+				for _, op := range upd.changes(cached) {
 					event := Event{
 						Key:    upd.Key,
-						Type:   TagsChanged,
+						Type:   op,
 						Object: upd,
 					}
 					u.Events <- event
@@ -227,18 +499,27 @@ func (u *S3Watcher) sync(firstSync bool) {
 	}
 
 	if !firstSync {
-		for k, o := range u.cache {
+		deleted := make([]string, 0)
+		for k := range u.cache {
 			if _, found := fileList[k]; !found {
-				// file not found in the list...deleting it
-				delete(u.cache, k)
-				event := Event{
-					Key:    o.Key,
-					Type:   FileDeleted,
-					Object: o,
-				}
-				u.Events <- event
+				deleted = append(deleted, k)
 			}
 		}
+		deleted = u.confirmMissing(u.config.guardConfiguration, deleted, u.objectExists)
+		if !u.checkDeletes(u.config.guardConfiguration, deleted, len(u.cache)) {
+			return
+		}
+		for _, k := range deleted {
+			// file not found in the list...deleting it
+			o := u.cache[k]
+			delete(u.cache, k)
+			event := Event{
+				Key:    o.Key,
+				Type:   FileDeleted,
+				Object: o,
+			}
+			u.Events <- event
+		}
 	}
 }
 
@@ -250,6 +531,35 @@ func (u *S3Watcher) bucketExists(bucket string) (bool, error) {
 	return found, nil
 }
 
+// objectExists checks the object missing from the listing with a StatObject
+func (u *S3Watcher) objectExists(key string) (bool, error) {
+	_, err := u.client.StatObject(context.Background(), u.config.BucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// lookupKey returns the object with the key for the keys mode
+func (u *S3Watcher) lookupKey(key string) (snapshotObject, error) {
+	info, err := u.client.StatObject(context.Background(), u.config.BucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info.Key = key
+	upd, err := u.getInfoFromObject(&info)
+	if err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
 func (u *S3Watcher) getTags(key string, bucket string) (map[string]string, error) {
 	t, err := u.client.GetObjectTagging(context.Background(), bucket, key, minio.GetObjectTaggingOptions{})
 	if err != nil {
@@ -303,10 +613,15 @@ func (u *S3Watcher) enumerateFiles(bucket, prefix string, callback func(page int
 		UseV1:        false,
 	}
 
+	// the listing is stopped on return
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// List all objects from a bucket-name with a matching prefix.
-	for object := range u.client.ListObjects(context.Background(), bucket, options) {
+	for object := range u.client.ListObjects(ctx, bucket, options) {
+		// a partial listing would delete the missing objects
 		if object.Err != nil {
-			continue
+			return fmt.Errorf("listing bucket '%s': %s", bucket, object.Err)
 		}
 
 		if callback(0, &object) == false {
@@ -318,4 +633,5 @@ func (u *S3Watcher) enumerateFiles(bucket, prefix string, callback func(page int
 
 func init() {
 	supportedServices["s3"] = newS3Watcher
+	objectTypes["s3"] = func() snapshotObject { return &S3Object{} }
 }
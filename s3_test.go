@@ -2,10 +2,12 @@ package cloudwatcher
 
 import (
 	"context"
+	"github.com/Matrix86/cloudwatcher/internal/clock"
 	"github.com/Matrix86/cloudwatcher/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/tags"
+	"strings"
 	"testing"
 	"time"
 )
@@ -100,6 +102,7 @@ func TestS3Watcher_Start(t *testing.T) {
 		t.Errorf("wrong type returned")
 	} else {
 		var event Event
+		created := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
 
 		config := map[string]string{
 			"bucket_name": "test.storage.wrong",
@@ -148,7 +151,7 @@ func TestS3Watcher_Start(t *testing.T) {
 					out <- minio.ObjectInfo{
 						ETag:         "xxx",
 						Key:          "filename.test",
-						LastModified: time.Now(),
+						LastModified: created,
 						Size:         100,
 					}
 					close(out)
@@ -180,7 +183,7 @@ func TestS3Watcher_Start(t *testing.T) {
 		}
 
 		// File changed : Lastmodified changes
-		lastmod := time.Now()
+		lastmod := created.Add(time.Minute)
 		m.EXPECT().ListObjects(
 			gomock.Any(),
 			gomock.Eq("test.storage"),
@@ -338,3 +341,108 @@ func TestS3Watcher_Start(t *testing.T) {
 	}
 
 }
+
+func TestS3Watcher_Polling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+
+	fake := clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	w, err := New("s3", "/", time.Minute, WithClock(fake))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw := w.(*S3Watcher)
+	if err := sw.SetConfig(map[string]string{"bucket_name": "test.storage", "endpoint": "endpoint:9000"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw.client = m
+
+	// the object is created after the first sync
+	listings := [][]minio.ObjectInfo{
+		{},
+		{{ETag: "xxx", Key: "filename.test", LastModified: fake.Now(), Size: 100}},
+	}
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Eq("test.storage")).Return(true, nil).AnyTimes()
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Eq("test.storage"), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			out := make(chan minio.ObjectInfo, 1)
+			for _, o := range listings[0] {
+				out <- o
+			}
+			listings = listings[1:]
+			close(out)
+			return out
+		},
+	).Times(2)
+	tag, _ := tags.NewTags(map[string]string{"key": "value"}, true)
+	m.EXPECT().GetObjectTagging(gomock.Any(), gomock.Eq("test.storage"), gomock.Eq("filename.test"), gomock.Any()).Return(tag, nil)
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the tick is received after the first sync, no more syncs are done until the next Advance
+	fake.Advance(time.Minute)
+
+	select {
+	case event := <-w.GetEvents():
+		if event.Key != "filename.test" || event.Type != FileCreated {
+			t.Errorf("wrong event received: %s %s", event.Key, event.TypeString())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("FileCreated event not received")
+	}
+
+	w.Close()
+	for range w.GetEvents() {
+	}
+	if status := w.Status(); status.Syncs != 2 || !status.LastSync.Equal(fake.Now()) {
+		t.Errorf("wrong status: %#v", status)
+	}
+}
+
+func TestS3Watcher_list(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+
+	defer func(f func(*s3Configuration) (IMinio, error)) { newS3Client = f }(newS3Client)
+	newS3Client = func(*s3Configuration) (IMinio, error) { return m, nil }
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	m.EXPECT().ListObjects(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _ minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+			out := make(chan minio.ObjectInfo, 1)
+			out <- minio.ObjectInfo{Key: "a.txt"}
+			close(out)
+			return out
+		},
+	).Times(2)
+	m.EXPECT().GetObjectTagging(gomock.Any(), gomock.Any(), "a.txt", gomock.Any()).Return(nil, minio.ErrorResponse{Code: "SlowDown"}).Times(2)
+
+	w, err := New("s3", "/", time.Minute)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.SetConfig(map[string]string{"bucket_name": "test"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the object can't be left out of the snapshot, Diff would report it as deleted
+	if _, err := NewSnapshot(w); err == nil {
+		t.Errorf("the snapshot should fail")
+	}
+
+	// nor skipped silently by the synchronization
+	w.(*S3Watcher).sync(false)
+	select {
+	case err := <-w.GetErrors():
+		if !strings.Contains(err.Error(), "a.txt") {
+			t.Errorf("wrong error: %s", err)
+		}
+	default:
+		t.Errorf("the error has not been reported")
+	}
+	if events := pendingEvents(w); len(events) != 0 {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
@@ -0,0 +1,432 @@
+package cloudwatcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+// SchedulerOptions contains the options of a Scheduler
+type SchedulerOptions struct {
+	Workers int // synchronizations running at the same time (default 4)
+
+	// limits of the synchronizations of the watchers using the same endpoint and credentials, zero disables them
+	GroupConcurrency int     // synchronizations of a group running at the same time
+	GroupRate        float64 // synchronizations of a group started per second
+	GroupBurst       int     // synchronizations of a group started at once within GroupRate (default 1)
+
+	Clock Clock // default is the system clock
+}
+
+// Scheduler runs the synchronizations of many polling watchers on a bounded pool of workers, instead of a goroutine
+// and a ticker for each watcher. The watchers are registered creating them with WithScheduler: Start adds them to
+// the scheduler and Close removes them.
+//
+// The synchronizations of the watchers, also the first ones, are spread over the polling interval so that the
+// watchers with the same interval don't synchronize at the same time. When the workers are busy, the most overdue synchronization runs first. The watchers are grouped by endpoint and credentials (e.g.
+// the S3 endpoint and access key), and each group is limited by GroupConcurrency and GroupRate.
+type Scheduler struct {
+	opts SchedulerOptions
+	clk  Clock
+
+	mu         sync.Mutex
+	entries    []*scheduledEntry
+	groups     map[string]*schedulerGroup
+	running    int
+	registered int
+	closed     bool
+
+	jobs    chan *scheduledEntry
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+// schedulable is implemented by the watchers that can be run by a Scheduler
+type schedulable interface {
+	sync(firstSync bool)
+	schedulingGroup() string
+}
+
+// scheduledEntry is a watcher registered with the scheduler. The synchronizations are due on a grid starting from
+// anchor. closed is called when the watcher is removed while synchronizing.
+type scheduledEntry struct {
+	w        schedulable
+	group    string
+	interval time.Duration
+	anchor   time.Time
+
+	next      time.Time
+	first     bool
+	running   bool
+	requested bool
+	closed    func()
+}
+
+type schedulerGroup struct {
+	running int
+	bucket  *tokenBucket
+}
+
+// NewScheduler creates a Scheduler and starts its workers
+func NewScheduler(opts *SchedulerOptions) *Scheduler {
+	s := newScheduler(opts)
+	for i := 0; i < s.opts.Workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	go s.run()
+	return s
+}
+
+func newScheduler(opts *SchedulerOptions) *Scheduler {
+	s := &Scheduler{
+		groups: make(map[string]*schedulerGroup),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Workers <= 0 {
+		s.opts.Workers = 4
+	}
+	if s.opts.GroupBurst <= 0 {
+		s.opts.GroupBurst = 1
+	}
+	s.clk = s.opts.Clock
+	if s.clk == nil {
+		s.clk = clock.Real
+	}
+	s.jobs = make(chan *scheduledEntry, s.opts.Workers)
+	return s
+}
+
+// Close stops the scheduler waiting for the running synchronizations, the registered watchers are not synchronized
+// anymore but they still have to be closed
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	close(s.jobs)
+	s.workers.Wait()
+}
+
+// Watchers returns the number of registered watchers
+func (s *Scheduler) Watchers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// add registers a watcher, its first synchronization is due at its phase of the interval
+func (s *Scheduler) add(w schedulable, interval time.Duration) (*scheduledEntry, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("wrong polling interval %s", interval)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("the scheduler is closed")
+	}
+
+	// the phases of the golden ratio sequence are spread evenly for any number of watchers
+	_, phase := math.Modf(float64(s.registered) * (math.Sqrt(5) - 1) / 2)
+	s.registered++
+	anchor := s.clk.Now().Add(time.Duration(phase * float64(interval)))
+	e := &scheduledEntry{
+		w:        w,
+		group:    w.schedulingGroup(),
+		interval: interval,
+		anchor:   anchor,
+		next:     anchor,
+		first:    true,
+	}
+	s.entries = append(s.entries, e)
+	s.notify()
+	return e, nil
+}
+
+// remove unregisters the watcher without waiting for its running synchronization, that could be blocked on the
+// Events chan: closed is called when it ends, or immediately if the watcher is not synchronizing
+func (s *Scheduler) remove(e *scheduledEntry, closed func()) {
+	s.mu.Lock()
+	for i, v := range s.entries {
+		if v == e {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	if e.running {
+		e.closed = closed
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	closed()
+}
+
+// request makes the synchronization of the watcher due immediately, or as soon as the running one ends
+func (s *Scheduler) request(e *scheduledEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.running {
+		e.requested = true
+		return
+	}
+	if now := s.clk.Now(); e.next.After(now) {
+		e.next = now
+	}
+	s.notify()
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		wait := s.dispatch()
+		s.mu.Unlock()
+
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = s.clk.After(wait)
+		}
+		select {
+		case <-s.wake:
+		case <-timer:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// dispatch is called holding the lock, it starts the due synchronizations allowed by the limits from the most
+// overdue one. It returns the time to wait before the next one, or -1 if it has to wait for a change.
+func (s *Scheduler) dispatch() time.Duration {
+	now := s.clk.Now()
+	wait := time.Duration(-1)
+	setWait := func(d time.Duration) {
+		if wait < 0 || d < wait {
+			wait = d
+		}
+	}
+
+	due := make([]*scheduledEntry, 0)
+	for _, e := range s.entries {
+		if e.running {
+			continue
+		}
+		if e.next.After(now) {
+			setWait(e.next.Sub(now))
+			continue
+		}
+		due = append(due, e)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].next.Before(due[j].next)
+	})
+
+	for _, e := range due {
+		if s.running >= s.opts.Workers {
+			break
+		}
+		g := s.group(e.group)
+		if s.opts.GroupConcurrency > 0 && g.running >= s.opts.GroupConcurrency {
+			continue
+		}
+		if g.bucket != nil {
+			if d := g.bucket.take(now); d > 0 {
+				setWait(d)
+				continue
+			}
+		}
+		e.running = true
+		g.running++
+		s.running++
+		s.jobs <- e
+	}
+	return wait
+}
+
+func (s *Scheduler) group(name string) *schedulerGroup {
+	g, ok := s.groups[name]
+	if !ok {
+		g = &schedulerGroup{}
+		if s.opts.GroupRate > 0 {
+			g.bucket = newTokenBucket(s.opts.GroupRate, s.opts.GroupBurst, s.clk.Now())
+		}
+		s.groups[name] = g
+	}
+	return g
+}
+
+func (s *Scheduler) work() {
+	defer s.workers.Done()
+	for e := range s.jobs {
+		e.w.sync(e.first)
+		s.finish(e)
+	}
+}
+
+// regroup moves the watcher in the group of its current configuration, it's called holding the cycle of the watcher
+// when the configuration changes (i.e. a new access key)
+func (s *Scheduler) regroup(e *scheduledEntry) {
+	group := e.w.schedulingGroup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group == e.group {
+		return
+	}
+	if e.running {
+		s.groups[e.group].running--
+		s.group(group).running++
+	}
+	e.group = group
+	s.notify()
+}
+
+// finish schedules the next synchronization of the watcher on its grid, skipping the missed ones. If the watcher
+// has been removed meanwhile its channels are closed.
+func (s *Scheduler) finish(e *scheduledEntry) {
+	s.mu.Lock()
+	e.running = false
+	e.first = false
+	s.groups[e.group].running--
+	s.running--
+	if closed := e.closed; closed != nil {
+		s.mu.Unlock()
+		closed()
+		return
+	}
+	defer s.mu.Unlock()
+
+	now := s.clk.Now()
+	if e.requested {
+		e.requested = false
+		e.next = now
+	} else if now.Before(e.anchor) {
+		e.next = e.anchor
+	} else {
+		e.next = e.anchor.Add((now.Sub(e.anchor)/e.interval + 1) * e.interval)
+	}
+	s.notify()
+}
+
+// tokenBucket allows rate events per second, up to burst at once
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// take consumes a token returning 0, or returns the time to wait for the next token
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// WithScheduler makes the polling of the watcher run by the Scheduler s. It is ignored by the watchers pushing the
+// changes (local with fsnotify).
+func WithScheduler(s *Scheduler) Option {
+	return func(o *options) {
+		o.scheduler = s
+	}
+}
+
+// schedulerSetter is implemented by all the watchers including WatcherBase
+type schedulerSetter interface {
+	setScheduler(s *Scheduler)
+}
+
+func (w *WatcherBase) setScheduler(s *Scheduler) {
+	w.scheduler = s
+}
+
+// startScheduled registers the watcher with its scheduler instead of starting the polling loop
+func (w *WatcherBase) startScheduled(s schedulable) error {
+	w.scheduledMu.Lock()
+	defer w.scheduledMu.Unlock()
+	e, err := w.scheduler.add(s, w.pollingTime)
+	if err != nil {
+		return err
+	}
+	w.scheduled = e
+	return nil
+}
+
+// getScheduled returns the entry of the watcher in its scheduler, nil if it's not scheduled
+func (w *WatcherBase) getScheduled() *scheduledEntry {
+	w.scheduledMu.Lock()
+	defer w.scheduledMu.Unlock()
+	return w.scheduled
+}
+
+// updateSchedulingGroup moves the watcher in the scheduling group of the configuration just applied
+func (w *WatcherBase) updateSchedulingGroup() {
+	if e := w.getScheduled(); e != nil {
+		w.scheduler.regroup(e)
+	}
+}
+
+// closeScheduled removes the watcher from its scheduler and closes the channels as the polling loop does, once the
+// running synchronization ends, after calling cleanup (if not nil). It returns false if the watcher is not scheduled.
+func (w *WatcherBase) closeScheduled(cleanup func()) bool {
+	w.scheduledMu.Lock()
+	e := w.scheduled
+	w.scheduled = nil
+	w.scheduledMu.Unlock()
+	if e == nil {
+		return false
+	}
+	w.scheduler.remove(e, func() {
+		if cleanup != nil {
+			cleanup()
+		}
+		close(w.Events)
+		close(w.Errors)
+	})
+	return true
+}
+
+// credentialID identifies a credential in a scheduling group without disclosing it
+func credentialID(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
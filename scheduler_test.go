@@ -0,0 +1,245 @@
+package cloudwatcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/internal/clock"
+)
+
+type testSchedulable struct {
+	group string
+}
+
+func (w *testSchedulable) sync(firstSync bool) {}
+
+func (w *testSchedulable) schedulingGroup() string {
+	return w.group
+}
+
+// dispatched returns the watchers started by the last dispatch of the scheduler
+func dispatched(s *Scheduler) []*scheduledEntry {
+	entries := make([]*scheduledEntry, 0)
+	for {
+		select {
+		case e := <-s.jobs:
+			entries = append(entries, e)
+		default:
+			return entries
+		}
+	}
+}
+
+func newTestScheduler(t *testing.T, opts *SchedulerOptions, groups ...string) (*Scheduler, *clock.Fake, []*scheduledEntry) {
+	clk := clock.NewFake(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	opts.Clock = clk
+	s := newScheduler(opts)
+	entries := make([]*scheduledEntry, 0)
+	for _, g := range groups {
+		e, err := s.add(&testSchedulable{group: g}, time.Minute)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		entries = append(entries, e)
+	}
+	return s, clk, entries
+}
+
+func TestScheduler_dispatch(t *testing.T) {
+	s, clk, entries := newTestScheduler(t, &SchedulerOptions{Workers: 2}, "a", "b", "c")
+	a, b, c := entries[0], entries[1], entries[2]
+	start := clk.Now()
+
+	// the first synchronizations are spread over the interval by the golden ratio sequence (0, 0.618, 0.236)
+	for e, next := range map[*scheduledEntry]time.Duration{a: 0, b: 37082039324, c: 14164078649} {
+		if d := e.next.Sub(start); d != next || d != e.anchor.Sub(start) {
+			t.Errorf("wrong first synchronization %s, expected %s", d, next)
+		}
+	}
+	if wait := s.dispatch(); wait != 14164078649 {
+		t.Errorf("wrong wait %s", wait)
+	}
+	if started := dispatched(s); len(started) != 1 || started[0] != a || !a.first {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	s.finish(a)
+	if d := a.next.Sub(start); d != time.Minute {
+		t.Errorf("wrong next synchronization %s", d)
+	}
+
+	// the synchronizations are bounded by the workers, the most overdue first
+	clk.Advance(time.Minute)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 2 || started[0] != c || started[1] != b || !c.first {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	s.finish(c)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 1 || started[0] != a {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	s.finish(a)
+	s.finish(b)
+
+	// the missed synchronizations are skipped
+	clk.Advance(2 * time.Minute)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 2 || started[0] != c || started[1] != b || c.first {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	s.finish(c)
+	if d := c.next.Sub(start); d != 3*time.Minute+14164078649 {
+		t.Errorf("the missed synchronizations should be skipped: %s", d)
+	}
+}
+
+func TestScheduler_groups(t *testing.T) {
+	s, clk, entries := newTestScheduler(t, &SchedulerOptions{GroupConcurrency: 1, GroupRate: 1.0 / 60}, "g", "g", "h")
+	a, b, c := entries[0], entries[1], entries[2]
+
+	s.dispatch()
+	if started := dispatched(s); len(started) != 1 || started[0] != a {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+
+	// b waits for the synchronization of a
+	clk.Advance(b.next.Sub(clk.Now()))
+	s.dispatch()
+	if started := dispatched(s); len(started) != 1 || started[0] != c {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+
+	// then for the token of the group
+	s.finish(a)
+	wait := s.dispatch()
+	if started := dispatched(s); len(started) != 0 || wait <= 0 {
+		t.Fatalf("wrong synchronizations started: %v (wait %s)", started, wait)
+	}
+	clk.Advance(wait)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 1 || started[0] != b {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+
+	// a synchronization requested while running is run again
+	s.request(b)
+	s.finish(b)
+	if !b.next.Equal(clk.Now()) {
+		t.Errorf("the requested synchronization should be due: %s", b.next)
+	}
+}
+
+func TestScheduler_regroup(t *testing.T) {
+	s, _, entries := newTestScheduler(t, &SchedulerOptions{GroupConcurrency: 1}, "g", "h", "h")
+	a, b, c := entries[0], entries[1], entries[2]
+	s.request(b)
+	s.request(c)
+
+	s.dispatch()
+	if started := dispatched(s); len(started) != 2 || started[0] != a || started[1] != b {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+
+	// the running synchronization is counted in the new group
+	a.w.(*testSchedulable).group = "h"
+	s.regroup(a)
+	s.finish(b)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 0 {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	s.finish(a)
+	s.dispatch()
+	if started := dispatched(s); len(started) != 1 || started[0] != c {
+		t.Fatalf("wrong synchronizations started: %v", started)
+	}
+	if a.group != "h" || s.groups["g"].running != 0 || s.groups["h"].running != 1 {
+		t.Errorf("wrong groups: %s %d %d", a.group, s.groups["g"].running, s.groups["h"].running)
+	}
+
+	w, _ := newS3Watcher("", time.Minute)
+	w.(*S3Watcher).config = &s3Configuration{Endpoint: "s3.local", AccessKey: "AKIAEXAMPLE"}
+	if g := w.(*S3Watcher).schedulingGroup(); g != "s3:s3.local/"+credentialID("AKIAEXAMPLE") {
+		t.Errorf("wrong group: %s", g)
+	}
+}
+
+func TestScheduler_MemoryWatcher(t *testing.T) {
+	s := NewScheduler(&SchedulerOptions{Workers: 1})
+	defer s.Close()
+	w, err := New("memory", "scheduled", time.Hour, WithScheduler(s))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s.Watchers() != 1 {
+		t.Errorf("the watcher is not registered")
+	}
+	waitSyncs(t, w, 1)
+
+	w.(*MemoryWatcher).Put("file.txt", nil)
+	if err := SyncNow(w); err != nil {
+		t.Fatalf("%s", err)
+	}
+	select {
+	case e := <-w.GetEvents():
+		if e.Key != "file.txt" || e.Type != FileCreated {
+			t.Errorf("wrong event: %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event not received")
+	}
+
+	w.Close()
+	if _, ok := <-w.GetEvents(); ok {
+		t.Errorf("the events chan should be closed")
+	}
+	if s.Watchers() != 0 {
+		t.Errorf("the watcher is still registered")
+	}
+}
+
+func TestScheduler_CloseBlocked(t *testing.T) {
+	s := NewScheduler(&SchedulerOptions{Workers: 1})
+	defer s.Close()
+	w := newTestMemoryWatcher(t, time.Hour, nil)
+	w.setScheduler(s)
+	if err := w.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	waitSyncs(t, w, 1)
+
+	// the synchronization is blocked on the full Events chan
+	for i := 0; i < cap(w.Events)+10; i++ {
+		w.Put(fmt.Sprintf("%03d", i), nil)
+	}
+	// nothing reads the events, the started synchronization can't end
+	SyncNow(w)
+	waitSyncStarts(t, w, 2)
+
+	closed := make(chan bool)
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Close is blocked by the synchronization")
+	}
+
+	// the channels are closed once the synchronization ends
+	n := 0
+	for range w.Events {
+		n++
+	}
+	if n != cap(w.Events)+10 {
+		t.Errorf("wrong number of events: %d", n)
+	}
+	if s.Watchers() != 0 {
+		t.Errorf("the watcher is still registered")
+	}
+}
@@ -0,0 +1,161 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// SecretResolver resolves the references of the config values starting with the scheme it is registered with, e.g.
+// "vault:secret/data/s3#key". ref is the part after the colon.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc is a function implementing SecretResolver
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"env":  SecretResolverFunc(resolveEnv),
+		"file": SecretResolverFunc(resolveFile),
+	}
+)
+
+// RegisterSecretResolver adds the resolver of the config values starting with "scheme:", the env and file schemes
+// are already registered
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	secretResolvers[scheme] = r
+}
+
+// resolveEnv returns the value of the environment variable
+func resolveEnv(ctx context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", name)
+	}
+	return v, nil
+}
+
+// resolveFile returns the content of the file without the final newline
+func resolveFile(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// secretResolver returns the resolver of the value if it is a reference
+func secretResolver(v string) (SecretResolver, string) {
+	scheme, ref, ok := strings.Cut(v, ":")
+	if !ok {
+		return nil, ""
+	}
+	secretResolversMu.RLock()
+	defer secretResolversMu.RUnlock()
+	return secretResolvers[scheme], ref
+}
+
+// isSecretReference returns true if the value is resolved by a SecretResolver
+func isSecretReference(v string) bool {
+	r, _ := secretResolver(v)
+	return r != nil
+}
+
+// secretFields returns the names of the fields of the configuration marked with the secret tag, only their values
+// can be references: a repo_url as "file:/srv/repo.git" is not resolved
+func secretFields(config interface{}) map[string]bool {
+	fields := make(map[string]bool)
+	for _, f := range configFields(reflect.TypeOf(config)) {
+		if f.Tag.Get("secret") == "true" {
+			fields[configName(f)] = true
+		}
+	}
+	return fields
+}
+
+// ResolveSecret returns the value of the reference v (e.g. "env:NAME"), or v if it is not a reference. The watchers
+// resolve only the secret fields of their configuration.
+func ResolveSecret(ctx context.Context, v string) (string, error) {
+	r, ref := secretResolver(v)
+	if r == nil {
+		return v, nil
+	}
+	return r.Resolve(ctx, ref)
+}
+
+// resolveSecrets returns a copy of the configuration with the references of the secret fields of config replaced
+// by their values
+func resolveSecrets(ctx context.Context, m map[string]string, config interface{}) (map[string]string, error) {
+	secrets := secretFields(config)
+	resolved := make(map[string]string, len(m))
+	for k, v := range m {
+		if secrets[k] {
+			s, err := ResolveSecret(ctx, v)
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s: %s", k, err)
+			}
+			v = s
+		}
+		resolved[k] = v
+	}
+	return resolved, nil
+}
+
+// setRawConfig stores the configuration with the references, it's called holding the cycle once the configuration
+// has been applied
+func (w *WatcherBase) setRawConfig(raw, resolved map[string]string) {
+	w.rawConfig = raw
+	w.resolvedConfig = resolved
+	w.updateSchedulingGroup()
+}
+
+// sendSecretError doesn't block as it's called holding the cycle
+func (w *WatcherBase) sendSecretError(err error) {
+	select {
+	case w.Errors <- err:
+	default:
+	}
+}
+
+// refreshSecrets resolves again the references of the configuration after a credential error, if a value changed
+// (e.g. a rotated key) it calls apply and requests a new synchronization. It's called holding the cycle.
+func (w *WatcherBase) refreshSecrets(apply func(resolved map[string]string) error) {
+	// the resolved fields are the ones with a value different from the raw one
+	resolved := make(map[string]string, len(w.resolvedConfig))
+	changed := false
+	for k, v := range w.resolvedConfig {
+		if raw := w.rawConfig[k]; raw != v {
+			s, err := ResolveSecret(context.Background(), raw)
+			if err != nil {
+				w.sendSecretError(fmt.Errorf("resolving %s: %s", k, err))
+				return
+			}
+			changed = changed || s != v
+			v = s
+		}
+		resolved[k] = v
+	}
+	if !changed {
+		return
+	}
+	if err := apply(resolved); err != nil {
+		w.sendSecretError(err)
+		return
+	}
+	w.resolvedConfig = resolved
+	w.updateSchedulingGroup()
+	w.requestSync()
+}
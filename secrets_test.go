@@ -0,0 +1,121 @@
+package cloudwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Matrix86/cloudwatcher/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(file, []byte("from-file\n"), 0600)
+	t.Setenv("CW_TEST_SECRET", "from-env")
+	RegisterSecretResolver("test", SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		return "resolved-" + ref, nil
+	}))
+
+	// only the secret fields are resolved
+	m, err := resolveSecrets(context.Background(), map[string]string{
+		"http_token":        "env:CW_TEST_SECRET",
+		"http_password":     "file:" + file,
+		"ssh_pkey_password": "test:path#key",
+		"repo_url":          "file:/srv/repo.git",
+		"temp_dir":          "env:CW_TEST_SECRET",
+	}, gitConfiguration{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := map[string]string{
+		"http_token":        "from-env",
+		"http_password":     "from-file",
+		"ssh_pkey_password": "resolved-path#key",
+		"repo_url":          "file:/srv/repo.git",
+		"temp_dir":          "env:CW_TEST_SECRET",
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("%s: expected %s got %s", k, v, m[k])
+		}
+	}
+
+	if _, err := resolveSecrets(context.Background(), map[string]string{"http_token": "env:CW_TEST_MISSING"}, gitConfiguration{}); err == nil {
+		t.Errorf("the variable is not set")
+	}
+}
+
+func TestS3Watcher_refreshSecrets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockMinio(ctrl)
+
+	defer func(f func(*s3Configuration) (IMinio, error)) { newS3Client = f }(newS3Client)
+	newS3Client = func(*s3Configuration) (IMinio, error) { return m, nil }
+	m.EXPECT().BucketExists(gomock.Any(), gomock.Any()).Return(false, minio.ErrorResponse{Code: "InvalidAccessKeyId"}).AnyTimes()
+
+	t.Setenv("CW_TEST_SECRET", "old")
+	w, err := NewFromURI("s3://bucket/?secret_key=env:CW_TEST_SECRET")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	sw := w.(*S3Watcher)
+	if sw.config.SecretAccessKey != "old" {
+		t.Errorf("the reference has not been resolved: %s", sw.config.SecretAccessKey)
+	}
+	if s := fmt.Sprint(w); !strings.Contains(s, "secret_key=env%3ACW_TEST_SECRET") {
+		t.Errorf("the reference should be in the uri: %s", s)
+	}
+
+	// the same secret is not applied again
+	sw.sync(false)
+	if err := <-sw.GetErrors(); !errors.As(err, new(*CredentialError)) {
+		t.Errorf("wrong error: %#v", err)
+	}
+	select {
+	case <-sw.syncRequests:
+		t.Errorf("the secrets didn't change")
+	default:
+	}
+
+	// the rotated secret is used by the next synchronization
+	os.Setenv("CW_TEST_SECRET", "new")
+	sw.sync(false)
+	select {
+	case err := <-sw.GetErrors():
+		if strings.Contains(err.Error(), "old") || strings.Contains(err.Error(), "new") {
+			t.Errorf("the error contains the secret: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("error not received")
+	}
+	if sw.config.SecretAccessKey != "new" {
+		t.Errorf("the secret has not been resolved again: %s", sw.config.SecretAccessKey)
+	}
+	select {
+	case <-sw.syncRequests:
+	default:
+		t.Errorf("a synchronization should be requested")
+	}
+}
+
+func TestGitWatcher_applySecrets(t *testing.T) {
+	w, _ := newGitWatcher("", time.Minute)
+	gw := w.(*GitWatcher)
+	gw.config = &gitConfiguration{RepoURL: "https://host/a.git", MonitorType: "repo", AuthType: "http_token", HTTPToken: "old"}
+	gw.tempDir = t.TempDir()
+
+	if err := gw.applySecrets(map[string]string{"repo_url": "https://host/b.git", "auth_type": "http_token", "http_token": "new"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if gw.config.HTTPToken != "new" || gw.config.RepoURL != "https://host/a.git" || gw.tempDir == "" {
+		t.Errorf("only the credentials should be updated: %#v", gw.config)
+	}
+}
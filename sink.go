@@ -0,0 +1,204 @@
+package cloudwatcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the events forwarded from a watcher
+type Sink interface {
+	// Send delivers a batch of events, it returns when they have been delivered or definitively failed
+	Send(ctx context.Context, events []Event) error
+	// Close releases the resources of the sink
+	Close() error
+}
+
+// EventSource is implemented by all the types producing events: watchers, Mux...
+type EventSource interface {
+	GetEvents() chan Event
+	GetErrors() chan error
+}
+
+// ExpandTemplate replaces the placeholders of tpl with the values of the event:
+// {backend} is the service that generated the event ("unknown" if it cannot be determined),
+// {op} is the event type and {key} the key of the object
+func ExpandTemplate(tpl string, e Event) string {
+	backend := "unknown"
+	if o, ok := e.Object.(snapshotObject); ok {
+		backend = o.backend()
+	}
+	return strings.NewReplacer(
+		"{backend}", backend,
+		"{op}", e.TypeString(),
+		"{key}", e.Key,
+	).Replace(tpl)
+}
+
+// ForwardOptions contains the options of Forward
+type ForwardOptions struct {
+	BatchSize     int           // max number of events sent with a single call to the sink (default 1)
+	FlushInterval time.Duration // max time an incomplete batch waits before being sent (default 1s)
+	OnError       func(error)   // receives the errors of the source and of the sink
+}
+
+// Forward sends the events of src to the sink, grouping them in batches.
+// It returns when ctx is cancelled or the source is closed, after sending the last batch.
+func Forward(ctx context.Context, src EventSource, sink Sink, opts *ForwardOptions) error {
+	if src == nil || sink == nil {
+		return fmt.Errorf("source and sink needed")
+	}
+	o := ForwardOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+
+	batch := make([]Event, 0, o.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sink.Send(ctx, batch); err != nil && o.OnError != nil {
+			o.OnError(err)
+		}
+		batch = make([]Event, 0, o.BatchSize)
+	}
+
+	ticker := time.NewTicker(o.FlushInterval)
+	defer ticker.Stop()
+
+	events := src.GetEvents()
+	errors := src.GetErrors()
+	for events != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			batch = append(batch, e)
+			if len(batch) >= o.BatchSize {
+				flush(ctx)
+			}
+
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			if o.OnError != nil {
+				o.OnError(err)
+			}
+
+		case <-ticker.C:
+			flush(ctx)
+
+		case <-ctx.Done():
+			// the context is gone but the events already received have to be delivered
+			flush(context.Background())
+			return ctx.Err()
+		}
+	}
+	flush(ctx)
+	return nil
+}
+
+// Mux merges the events of many watchers in a single stream
+type Mux struct {
+	WatcherBase
+
+	watchers []Watcher
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewMux creates a Mux for the watchers
+func NewMux(watchers ...Watcher) (*Mux, error) {
+	if len(watchers) == 0 {
+		return nil, fmt.Errorf("at least one watcher needed")
+	}
+	return &Mux{
+		watchers: watchers,
+		WatcherBase: WatcherBase{
+			Events: make(chan Event, 100),
+			Errors: make(chan error, 100),
+		},
+	}, nil
+}
+
+// SetConfig is not supported: the watchers have to be configured before creating the Mux
+func (m *Mux) SetConfig(c map[string]string) error {
+	return fmt.Errorf("the configuration of a Mux is not supported")
+}
+
+// Capabilities returns the events sent by any of the watchers (Tags and DirectoryEvents) and the
+// features of the objects supported by all of them, no configuration keys are accepted
+func (m *Mux) Capabilities() Capabilities {
+	c := m.watchers[0].Capabilities()
+	for _, w := range m.watchers[1:] {
+		c = c.merge(w.Capabilities())
+	}
+	c.ConfigKeys = []string{}
+	return c
+}
+
+// Start launches all the watchers
+func (m *Mux) Start() error {
+	for i, w := range m.watchers {
+		if err := w.Start(); err != nil {
+			for _, started := range m.watchers[:i] {
+				started.Close()
+			}
+			return err
+		}
+	}
+
+	for _, w := range m.watchers {
+		m.wg.Add(1)
+		go func(w Watcher) {
+			defer m.wg.Done()
+			events := w.GetEvents()
+			errors := w.GetErrors()
+			for events != nil || errors != nil {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						events = nil
+						continue
+					}
+					m.Events <- e
+				case err, ok := <-errors:
+					if !ok {
+						errors = nil
+						continue
+					}
+					m.Errors <- err
+				}
+			}
+		}(w)
+	}
+
+	go func() {
+		m.wg.Wait()
+		close(m.Events)
+		close(m.Errors)
+	}()
+	return nil
+}
+
+// Close stops all the watchers, the Mux channels are closed when all of them are terminated
+func (m *Mux) Close() {
+	m.once.Do(func() {
+		for _, w := range m.watchers {
+			w.Close()
+		}
+	})
+}
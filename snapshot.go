@@ -0,0 +1,209 @@
+package cloudwatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this package
+const SnapshotVersion = 1
+
+// snapshotObject is implemented by the objects stored in the snapshots
+type snapshotObject interface {
+	// cacheKey returns the key used by the watcher to cache the object
+	cacheKey() string
+	// eventKey returns the key of the events generated for the object
+	eventKey() string
+	// changes returns the events generated comparing the object with its cached version
+	changes(cached snapshotObject) []Op
+
+	// backend returns the name of the service that generated the object
+	backend() string
+	// size returns the size of the object
+	size() int64
+	// modTime returns the time of the last modification of the object, zero if it is unknown
+	modTime() time.Time
+	// hash returns the content hash of the object, empty if it is not available
+	hash() string
+}
+
+// lister is implemented by the watchers that can list the objects of the watched directory in one pass
+type lister interface {
+	service() string
+	list() ([]snapshotObject, error)
+}
+
+// objectTypes returns an empty object of the service, used to decode the snapshots and the events
+var objectTypes = make(map[string]func() snapshotObject)
+
+// Snapshot contains all the objects found in a directory at a given time
+type Snapshot struct {
+	Version int
+	Service string
+	Dir     string
+	Source  string // CloudEvents source of the watched location
+	Time    time.Time
+
+	objects map[string]snapshotObject
+}
+
+type snapshotFile struct {
+	Version int               `json:"version"`
+	Service string            `json:"service"`
+	Dir     string            `json:"dir"`
+	Source  string            `json:"source,omitempty"`
+	Time    time.Time         `json:"time"`
+	Objects []json.RawMessage `json:"objects"`
+}
+
+// TakeSnapshot lists all the objects of the directory dir of the service, the watcher used is closed (removing the
+// git clone it created)
+func TakeSnapshot(service string, dir string, config map[string]string) (*Snapshot, error) {
+	w, err := New(service, dir, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	if err := w.SetConfig(config); err != nil {
+		return nil, fmt.Errorf("configuring %s: %s", service, err)
+	}
+	return NewSnapshot(w)
+}
+
+// NewSnapshot lists all the objects of the directory watched by w. The watcher has to be configured and it can be
+// running: the listing waits for the end of the running sync.
+func NewSnapshot(w Watcher) (*Snapshot, error) {
+	l, ok := w.(lister)
+	if !ok {
+		return nil, fmt.Errorf("the watcher doesn't support snapshots")
+	}
+
+	objects, err := l.list()
+	if err != nil {
+		return nil, fmt.Errorf("listing objects: %s", err)
+	}
+
+	s := &Snapshot{
+		Version: SnapshotVersion,
+		Service: l.service(),
+		Dir:     w.Status().Dir,
+		Source:  CloudEventSource(w),
+		Time:    time.Now().UTC(),
+		objects: make(map[string]snapshotObject, len(objects)),
+	}
+	for _, o := range objects {
+		s.objects[o.cacheKey()] = o
+	}
+	return s, nil
+}
+
+// Len returns the number of objects in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.objects)
+}
+
+// Objects returns the objects of the snapshot sorted by key
+func (s *Snapshot) Objects() []interface{} {
+	objects := make([]interface{}, 0, len(s.objects))
+	for _, k := range s.sortedKeys() {
+		objects = append(objects, s.objects[k])
+	}
+	return objects
+}
+
+func (s *Snapshot) sortedKeys() []string {
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON encodes the snapshot with its objects
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	f := snapshotFile{
+		Version: s.Version,
+		Service: s.Service,
+		Dir:     s.Dir,
+		Source:  s.Source,
+		Time:    s.Time,
+		Objects: make([]json.RawMessage, 0, len(s.objects)),
+	}
+	for _, o := range s.Objects() {
+		j, err := json.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		f.Objects = append(f.Objects, j)
+	}
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON decodes a snapshot written by MarshalJSON
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	f := snapshotFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", f.Version)
+	}
+	newObject, ok := objectTypes[f.Service]
+	if !ok {
+		return fmt.Errorf("service %s doesn't support snapshots", f.Service)
+	}
+
+	s.Version = f.Version
+	s.Service = f.Service
+	s.Dir = f.Dir
+	s.Source = f.Source
+	s.Time = f.Time
+	s.objects = make(map[string]snapshotObject, len(f.Objects))
+	for _, j := range f.Objects {
+		o := newObject()
+		if err := json.Unmarshal(j, o); err != nil {
+			return fmt.Errorf("decoding object: %s", err)
+		}
+		s.objects[o.cacheKey()] = o
+	}
+	return nil
+}
+
+// Diff returns the events that a watcher would generate if the directory changed from old to new, the snapshots
+// have to be of the same location
+func Diff(old, new *Snapshot) ([]Event, error) {
+	if old.Service != new.Service {
+		return nil, fmt.Errorf("cannot compare a %s snapshot with a %s one", old.Service, new.Service)
+	}
+	if old.Source != new.Source || old.Dir != new.Dir {
+		return nil, fmt.Errorf("cannot compare the snapshot of '%s' (dir '%s') with the one of '%s' (dir '%s')",
+			old.Source, old.Dir, new.Source, new.Dir)
+	}
+
+	events := make([]Event, 0)
+	for _, k := range new.sortedKeys() {
+		obj := new.objects[k]
+		cached, ok := old.objects[k]
+		if !ok {
+			events = append(events, Event{Key: obj.eventKey(), Type: FileCreated, Object: obj})
+			continue
+		}
+		for _, op := range obj.changes(cached) {
+			events = append(events, Event{Key: obj.eventKey(), Type: op, Object: obj})
+		}
+	}
+	for _, k := range old.sortedKeys() {
+		if _, ok := new.objects[k]; !ok {
+			obj := old.objects[k]
+			events = append(events, Event{Key: obj.eventKey(), Type: FileDeleted, Object: obj})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+	return events, nil
+}
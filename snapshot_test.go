@@ -0,0 +1,94 @@
+package cloudwatcher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_Diff(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "changed.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "deleted.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "mode.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "same.txt"), []byte("a"), 0644)
+
+	old, err := TakeSnapshot("local", dir, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if old.Len() != 4 {
+		t.Errorf("wrong number of objects: %d", old.Len())
+	}
+
+	// the snapshot has to survive the encoding
+	j, err := json.Marshal(old)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	decoded := &Snapshot{}
+	if err := json.Unmarshal(j, decoded); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if decoded.Version != SnapshotVersion || decoded.Service != "local" || decoded.Dir != dir || decoded.Len() != 4 {
+		t.Errorf("wrong snapshot decoded: %+v", decoded)
+	}
+
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(filepath.Join(dir, "changed.txt"), []byte("ab"), 0644)
+	os.Chtimes(filepath.Join(dir, "changed.txt"), later, later)
+	os.Remove(filepath.Join(dir, "deleted.txt"))
+	os.Chmod(filepath.Join(dir, "mode.txt"), 0600)
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("a"), 0644)
+
+	current, err := TakeSnapshot("local", dir, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	events, err := Diff(decoded, current)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []struct {
+		name string
+		op   Op
+	}{
+		{"changed.txt", FileChanged},
+		{"deleted.txt", FileDeleted},
+		{"mode.txt", TagsChanged},
+		{"new.txt", FileCreated},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of events: %v", events)
+	}
+	for i, e := range expected {
+		if events[i].Key != filepath.Join(dir, e.name) || events[i].Type != e.op {
+			t.Errorf("wrong event %d: %s %s", i, events[i].Key, events[i].TypeString())
+		}
+	}
+
+	other, err := TakeSnapshot("local", t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := Diff(decoded, other); err == nil {
+		t.Errorf("an error should be returned comparing different directories")
+	}
+	decoded.Service = "s3"
+	if _, err := Diff(decoded, current); err == nil {
+		t.Errorf("an error should be returned comparing different services")
+	}
+}
+
+func TestSnapshot_UnmarshalJSON(t *testing.T) {
+	s := &Snapshot{}
+	if err := json.Unmarshal([]byte(`{"version":99,"service":"local"}`), s); err == nil {
+		t.Errorf("an error should be returned with an unsupported version")
+	}
+	if err := json.Unmarshal([]byte(`{"version":1,"service":"unknown"}`), s); err == nil {
+		t.Errorf("an error should be returned with an unknown service")
+	}
+}
@@ -0,0 +1,145 @@
+package cloudwatcher
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// defaultURIInterval is the polling interval of the watchers created by NewFromURI without the interval parameter
+const defaultURIInterval = 30 * time.Second
+
+// redacted replaces the values of the secret fields in the URIs returned by String
+const redacted = "xxxxx"
+
+// uriSchemes maps the schemes of the URIs to the services, git uses the schemes starting with "git+"
+var uriSchemes = map[string]string{
+	"s3":      "s3",
+	"file":    "local",
+	"gdrive":  "gdrive",
+	"dropbox": "dropbox",
+	"memory":  "memory",
+	"git":     "git",
+}
+
+// NewFromURI creates and configures a watcher from an URI: the scheme is the service, the path is the watched
+// directory and the query contains the configuration, except the interval parameter that is the polling interval
+// (default 30s). The host of s3 URIs is the bucket, a git+ URI (or a git:// one, for the git protocol) is the
+// repository with the branch and dir parameters
+//
+//	s3://bucket/prefix?endpoint=localhost:9000&ssl_enabled=false
+//	git+ssh://host/repo.git?branch=main&monitor_type=file
+//	git:?repo_url=git@host:repo.git
+//	file:///var/data?disable_fsnotify=true
+func NewFromURI(uri string, opts ...Option) (Watcher, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri: %s", err)
+	}
+
+	config := make(map[string]string)
+	for k, v := range u.Query() {
+		config[k] = v[len(v)-1]
+	}
+	interval := defaultURIInterval
+	if v, ok := config["interval"]; ok {
+		interval, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %s", err)
+		}
+		delete(config, "interval")
+	}
+
+	service := uriSchemes[u.Scheme]
+	dir := u.Path
+	switch {
+	case strings.HasPrefix(u.Scheme, "git+"), service == "git" && u.Host != "":
+		// git://host/repo.git is a repository on the git protocol, as git+git://host/repo.git
+		service = "git"
+		repo := *u
+		repo.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+		repo.RawQuery = ""
+		repo.Fragment = ""
+		config["repo_url"] = repo.String()
+		fallthrough
+	case service == "git":
+		if u.Host == "" && u.Path != "" {
+			return nil, fmt.Errorf("the path is not supported by the scheme '%s' without a host", u.Scheme)
+		}
+		dir = config["dir"]
+		delete(config, "dir")
+		if v, ok := config["branch"]; ok {
+			config["repo_branch"] = v
+			delete(config, "branch")
+		}
+
+	case service == "s3":
+		config["bucket_name"] = u.Host
+		dir = strings.TrimPrefix(u.Path, "/")
+		if u.User != nil {
+			config["access_key"] = u.User.Username()
+			if p, ok := u.User.Password(); ok {
+				config["secret_key"] = p
+			}
+		}
+
+	case service == "":
+		return nil, fmt.Errorf("scheme '%s' is not supported", u.Scheme)
+
+	case u.Host != "":
+		return nil, fmt.Errorf("the host is not supported by the scheme '%s'", u.Scheme)
+	}
+
+	w, err := New(service, dir, interval, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.SetConfig(config); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// formatURI sets the query of u with the interval and the fields of config that are not empty or in skip, the
+// values of the secret fields are redacted, or contain the secret reference they are set with in raw.
+func formatURI(u *url.URL, interval time.Duration, config interface{}, raw map[string]string, skip ...string) string {
+	query := u.Query()
+	if interval != defaultURIInterval {
+		query.Set("interval", interval.String())
+	}
+
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+		v = v.Elem()
+	}
+fields:
+	for _, f := range configFields(v.Type()) {
+		name := configName(f)
+		field := v.FieldByIndex(f.Index)
+		if field.IsZero() {
+			continue
+		}
+		for _, s := range skip {
+			if s == name {
+				continue fields
+			}
+		}
+		if f.Tag.Get("secret") == "true" {
+			if ref := raw[name]; isSecretReference(ref) {
+				query.Set(name, ref)
+			} else {
+				query.Set(name, redacted)
+			}
+		} else {
+			query.Set(name, fmt.Sprint(field.Interface()))
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -10,3 +10,27 @@ func inArray(needle interface{}, generic interface{}) bool {
 	}
 	return false
 }
+
+// equalTags returns true if the two sets of tags contain the same values, a nil map is equal to an empty one
+func equalTags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,23 @@
+package cloudwatcher
+
+import "testing"
+
+func TestEqualTags(t *testing.T) {
+	tests := []struct {
+		a, b  map[string]string
+		equal bool
+	}{
+		{nil, nil, true},
+		{nil, map[string]string{}, true},
+		{map[string]string{"a": "1"}, map[string]string{"a": "1"}, true},
+		{map[string]string{"a": "1"}, map[string]string{"a": "2"}, false},
+		{map[string]string{"a": "1"}, map[string]string{"b": "1"}, false},
+		{map[string]string{"a": "1"}, map[string]string{"a": "1", "b": "2"}, false},
+		{map[string]string{"a": ""}, nil, false},
+	}
+	for _, test := range tests {
+		if equalTags(test.a, test.b) != test.equal || equalTags(test.b, test.a) != test.equal {
+			t.Errorf("wrong comparison of %v and %v", test.a, test.b)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package cloudwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader is the header containing the HMAC-SHA256 of the body, if a secret is configured
+const WebhookSignatureHeader = "X-Cloudwatcher-Signature"
+
+// WebhookFormat is the encoding of the requests sent by the WebhookSink
+type WebhookFormat int
+
+// formats of the webhook requests
+const (
+	WebhookJSON              WebhookFormat = iota // {"events": [...]} with all the events of the batch
+	WebhookCloudEvents                            // CloudEvents structured mode (batch mode with more events)
+	WebhookCloudEventsBinary                      // CloudEvents binary mode, one request for each event
+)
+
+// WebhookConfig contains the options of the WebhookSink
+type WebhookConfig struct {
+	URLs           []string          // endpoints receiving the events
+	Secret         string            // if set, each request is signed with HMAC-SHA256 of the body
+	Headers        map[string]string // custom headers added to each request
+	Timeout        time.Duration     // timeout of a single request (default 10s)
+	MaxRetries     int               // retries after the first failed request (default 3, negative disables them)
+	Backoff        Backoff           // delay between two retries
+	DeadLetterFile string            // if set, the events that failed permanently are appended to this file with the url
+	Client         *http.Client      // client used for the requests (default http.DefaultClient)
+	Format         WebhookFormat     // encoding of the requests (default WebhookJSON)
+	Source         string            // CloudEvents source of the events, see NewCloudEvent
+}
+
+// WebhookSink is a Sink that POSTs the events as JSON to HTTP endpoints
+type WebhookSink struct {
+	config     WebhookConfig
+	client     *http.Client
+	deadLetter *FileDeadLetter
+}
+
+type webhookPayload struct {
+	Events []Event `json:"events"`
+}
+
+// webhookRequest is a request to send to each URL with the events it contains
+type webhookRequest struct {
+	body   []byte
+	header http.Header
+	events []Event
+}
+
+// permanentError is an HTTP error that will not be solved retrying the request
+type permanentError struct {
+	error
+}
+
+// NewWebhookSink creates a WebhookSink
+func NewWebhookSink(c WebhookConfig) (*WebhookSink, error) {
+	if len(c.URLs) == 0 {
+		return nil, fmt.Errorf("at least one url needed")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+
+	s := &WebhookSink{
+		config: c,
+		client: c.Client,
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if c.DeadLetterFile != "" {
+		s.deadLetter = NewFileDeadLetter(c.DeadLetterFile)
+	}
+	return s, nil
+}
+
+// Send POSTs the batch of events to all the configured URLs
+func (s *WebhookSink) Send(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	requests, err := s.encode(events)
+	if err != nil {
+		return fmt.Errorf("encoding events: %s", err)
+	}
+
+	var failed error
+	for _, url := range s.config.URLs {
+		for _, r := range requests {
+			attempts, err := s.post(ctx, url, r)
+			if err == nil {
+				continue
+			}
+			failed = fmt.Errorf("webhook '%s': %s", url, err)
+			if s.deadLetter != nil {
+				for _, e := range r.events {
+					if err := s.deadLetter.deadLetterURL(e, attempts, url); err != nil {
+						failed = fmt.Errorf("%s (dead-letter: %s)", failed, err)
+					}
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// encode returns the requests needed to send the events with the configured format
+func (s *WebhookSink) encode(events []Event) ([]*webhookRequest, error) {
+	switch s.config.Format {
+	case WebhookCloudEvents:
+		r := &webhookRequest{header: http.Header{}, events: events}
+		var err error
+		if len(events) == 1 {
+			r.header.Set("Content-Type", CloudEventsContentType)
+			r.body, err = json.Marshal(NewCloudEvent(events[0], s.config.Source))
+		} else {
+			batch := make([]*CloudEvent, 0, len(events))
+			for _, e := range events {
+				batch = append(batch, NewCloudEvent(e, s.config.Source))
+			}
+			r.header.Set("Content-Type", CloudEventsBatchContentType)
+			r.body, err = json.Marshal(batch)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*webhookRequest{r}, nil
+
+	case WebhookCloudEventsBinary:
+		requests := make([]*webhookRequest, 0, len(events))
+		for _, e := range events {
+			r := &webhookRequest{header: http.Header{}, events: []Event{e}}
+			body, err := NewCloudEvent(e, s.config.Source).EncodeBinary(r.header)
+			if err != nil {
+				return nil, err
+			}
+			r.body = body
+			requests = append(requests, r)
+		}
+		return requests, nil
+
+	default:
+		body, err := json.Marshal(webhookPayload{Events: events})
+		if err != nil {
+			return nil, err
+		}
+		r := &webhookRequest{body: body, header: http.Header{}, events: events}
+		r.header.Set("Content-Type", "application/json")
+		return []*webhookRequest{r}, nil
+	}
+}
+
+// Close releases the resources of the sink
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+func (s *WebhookSink) post(ctx context.Context, url string, r *webhookRequest) (int, error) {
+	attempt := 1
+	for ; ; attempt++ {
+		err := s.request(ctx, url, r)
+		if err == nil {
+			return attempt, nil
+		}
+		if _, ok := err.(permanentError); ok || attempt > s.config.MaxRetries {
+			return attempt, err
+		}
+
+		t := time.NewTimer(s.config.Backoff.Duration(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return attempt, err
+		}
+	}
+}
+
+func (s *WebhookSink) request(ctx context.Context, url string, r *webhookRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(r.body))
+	if err != nil {
+		return permanentError{err}
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "cloudwatcher/"+Version)
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignPayload(s.config.Secret, r.body))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected status %s", res.Status)
+	// client errors are permanent, except for timeouts and rate limits
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// SignPayload returns the value of the signature header for the body: "sha256=" followed by the hex HMAC-SHA256
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
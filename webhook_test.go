@@ -0,0 +1,203 @@
+package cloudwatcher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink_Send(t *testing.T) {
+	var mu sync.Mutex
+	received := make([]Event, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != SignPayload("secret", body) {
+			t.Errorf("wrong signature: %s", r.Header.Get(WebhookSignatureHeader))
+		}
+		if r.Header.Get("X-Custom") != "value" {
+			t.Errorf("custom header not set")
+		}
+		payload := webhookPayload{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("wrong payload: %s", err)
+		}
+		mu.Lock()
+		received = append(received, payload.Events...)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s, err := NewWebhookSink(WebhookConfig{
+		URLs:    []string{srv.URL},
+		Secret:  "secret",
+		Headers: map[string]string{"X-Custom": "value"},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w := newChanWatcher()
+	w.Events <- Event{Key: "a.txt", Type: FileCreated, Object: &LocalObject{Key: "a.txt", Size: 10}}
+	w.Events <- Event{Key: "b.txt", Type: FileDeleted, Object: &LocalObject{Key: "b.txt"}}
+	w.Events <- Event{Key: "c.txt", Type: FileChanged, Object: &LocalObject{Key: "c.txt"}}
+	close(w.Events)
+
+	err = Forward(context.Background(), w, s, &ForwardOptions{BatchSize: 2, FlushInterval: time.Hour})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 {
+		t.Fatalf("wrong number of events received: %d", len(received))
+	}
+	if received[1].Key != "b.txt" || received[1].Type != FileDeleted {
+		t.Errorf("wrong event received: %#v", received[1])
+	}
+	if obj, ok := received[0].Object.(*LocalObject); !ok || obj.Size != 10 {
+		t.Errorf("wrong object received: %#v", received[0].Object)
+	}
+}
+
+func TestWebhookSink_Retry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	s, _ := NewWebhookSink(WebhookConfig{
+		URLs:       []string{srv.URL},
+		MaxRetries: 3,
+		Backoff:    Backoff{Initial: time.Millisecond},
+	})
+	if err := s.Send(context.Background(), []Event{{Key: "a.txt"}}); err != nil {
+		t.Errorf("%s", err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("wrong number of requests: %d", calls)
+	}
+}
+
+func TestWebhookSink_DeadLetter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	dlq := filepath.Join(t.TempDir(), "dead.jsonl")
+	s, _ := NewWebhookSink(WebhookConfig{
+		URLs:           []string{srv.URL},
+		Backoff:        Backoff{Initial: time.Millisecond},
+		DeadLetterFile: dlq,
+	})
+	if err := s.Send(context.Background(), []Event{{Key: "a.txt"}, {Key: "b.txt"}}); err == nil {
+		t.Errorf("an error should be returned")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("client errors should not be retried: %d requests", calls)
+	}
+
+	j, err := os.ReadFile(dlq)
+	if err != nil {
+		t.Fatalf("dead-letter file not created: %s", err)
+	}
+	if lines := strings.Count(string(j), "\n"); lines != 2 {
+		t.Errorf("dead-letter file should contain 2 events, found %d", lines)
+	}
+	if !strings.Contains(string(j), `"url":"`+srv.URL+`"`) {
+		t.Errorf("the url is not in the dead-letter file: %s", j)
+	}
+}
+
+func TestMux(t *testing.T) {
+	w1 := newChanWatcher()
+	w2 := newChanWatcher()
+	m, err := NewMux(w1, w2)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w1.Events <- Event{Key: "a"}
+	w2.Events <- Event{Key: "b"}
+	keys := map[string]bool{}
+	keys[receiveEvent(t, m.GetEvents()).Key] = true
+	keys[receiveEvent(t, m.GetEvents()).Key] = true
+	if !keys["a"] || !keys["b"] {
+		t.Errorf("events not merged: %v", keys)
+	}
+
+	close(w1.Events)
+	close(w1.Errors)
+	close(w2.Events)
+	close(w2.Errors)
+	if _, ok := <-m.GetEvents(); ok {
+		t.Errorf("the Mux should be closed with its watchers")
+	}
+}
+
+func TestWebhookSink_CloudEvents(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([]*http.Request, 0)
+	bodies := make([][]byte, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	events := []Event{
+		{Key: "a.txt", Type: FileCreated, Object: &LocalObject{Key: "a.txt", Size: 10}},
+		{Key: "b.txt", Type: FileDeleted, Object: &LocalObject{Key: "b.txt"}},
+	}
+
+	s, _ := NewWebhookSink(WebhookConfig{URLs: []string{srv.URL}, Format: WebhookCloudEvents, Source: "local:///tmp"})
+	if err := s.Send(context.Background(), events); err != nil {
+		t.Fatalf("%s", err)
+	}
+	s, _ = NewWebhookSink(WebhookConfig{URLs: []string{srv.URL}, Format: WebhookCloudEventsBinary, Source: "local:///tmp"})
+	if err := s.Send(context.Background(), events); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 3 {
+		t.Fatalf("wrong number of requests: %d", len(requests))
+	}
+
+	if requests[0].Header.Get("Content-Type") != CloudEventsBatchContentType {
+		t.Errorf("wrong content type: %s", requests[0].Header.Get("Content-Type"))
+	}
+	batch := make([]CloudEvent, 0)
+	if err := json.Unmarshal(bodies[0], &batch); err != nil || len(batch) != 2 {
+		t.Errorf("wrong batch: %s", bodies[0])
+	} else if batch[1].Type != "io.cloudwatcher.file.deleted" || batch[1].Source != "local:///tmp" {
+		t.Errorf("wrong event in the batch: %#v", batch[1])
+	}
+
+	if requests[1].Header.Get("ce-subject") != "a.txt" || requests[2].Header.Get("ce-subject") != "b.txt" {
+		t.Errorf("wrong binary mode headers: %v %v", requests[1].Header, requests[2].Header)
+	}
+}